@@ -0,0 +1,225 @@
+package stakego
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultConfigDir - returns the directory stakego keeps its configuration
+// in, $XDG_CONFIG_HOME/stakego or ~/.config/stakego on every platform. This
+// differs from os.UserConfigDir, which is ~/Library/Application Support on
+// macOS and %AppData% on Windows.
+func DefaultConfigDir() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			home = "."
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "stakego")
+}
+
+// DefaultTokenPath - returns where the session token for a named account is saved
+func DefaultTokenPath(account string) string {
+	return filepath.Join(DefaultConfigDir(), "tokens", account+".token")
+}
+
+// NewAccountManager - create an empty AccountManager
+func NewAccountManager() *AccountManager {
+	m := AccountManager{}
+	m.clients = make(map[string]*ASXClient)
+	return &m
+}
+
+// AccountManager - holds one client per named Stake account
+type AccountManager struct {
+	mutex   sync.Mutex
+	clients map[string]*ASXClient
+}
+
+// AccountResult - the result of a call made against a single account
+type AccountResult[T any] struct {
+	Account string
+	Value   T
+	Err     error
+}
+
+// AccountFunc - callback used by ForEach
+type AccountFunc func(ctx context.Context, account string, c *ASXClient) error
+
+// AddAccount - add a named account, each with its own credentials and token store
+func (m *AccountManager) AddAccount(name string, creds *Credentials, store TokenStore) (*ASXClient, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if name == "" {
+		return nil, NewStakeError("accounts", fmt.Errorf("account name is empty"))
+	}
+	if _, exists := m.clients[name]; exists {
+		return nil, NewStakeError("accounts", fmt.Errorf("account '%s' already exists", name))
+	}
+
+	c := NewASXClient()
+	c.Credentials = creds
+	c.TokenStore = store
+	m.clients[name] = c
+	return c, nil
+}
+
+// LoadProfilesFromEnv - add accounts whose credentials are in environment
+// variables prefixed with STAKE_<NAME>_, e.g. STAKE_SMSF_USERNAME. Tokens are
+// saved to DefaultTokenPath(name).
+func (m *AccountManager) LoadProfilesFromEnv(names ...string) error {
+	for _, name := range names {
+		creds := &Credentials{}
+		creds.FromEnvPrefix(fmt.Sprintf("STAKE_%s_", strings.ToUpper(name)))
+		_, err := m.AddAccount(name, creds, NewFileTokenStore(DefaultTokenPath(name)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadProfiles - add accounts using the default credential source chain
+// for each profile (see DefaultCredentialLoader). Each profile must supply
+// its own session token, or a username and password. Tokens are saved to
+// DefaultTokenPath(name).
+func (m *AccountManager) LoadProfiles(names ...string) error {
+	for _, name := range names {
+		creds, report, err := LoadCredentials(name)
+		if err != nil {
+			return err
+		}
+		// a session token is enough on its own, otherwise both a
+		// username and password are needed to log in
+		missing := []string{}
+		if _, ok := report[CredentialSessionToken]; !ok {
+			for _, field := range []string{CredentialUsername, CredentialPassword} {
+				if _, ok := report[field]; !ok {
+					missing = append(missing, field)
+				}
+			}
+		}
+		if len(missing) > 0 {
+			return NewStakeError("accounts", fmt.Errorf("profile '%s' is missing %s", name, strings.Join(missing, ", ")))
+		}
+		_, err = m.AddAccount(name, creds, NewFileTokenStore(DefaultTokenPath(name)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Client - get the client for a named account
+func (m *AccountManager) Client(name string) (*ASXClient, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	c, exists := m.clients[name]
+	if !exists {
+		return nil, NewStakeError("accounts", fmt.Errorf("unknown account '%s'", name))
+	}
+	return c, nil
+}
+
+// Names - returns the account names in sorted order
+func (m *AccountManager) Names() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	names := make([]string, 0, len(m.clients))
+	for n := range m.clients {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ForEach - run fn concurrently against every account. The returned map
+// has an entry for every account, nil if fn succeeded.
+func (m *AccountManager) ForEach(ctx context.Context, fn AccountFunc) map[string]error {
+	names := m.Names()
+	errs := make(map[string]error, len(names))
+	var wg sync.WaitGroup
+	var errMutex sync.Mutex
+
+	for _, name := range names {
+		c, _ := m.Client(name)
+		wg.Add(1)
+		go func(name string, c *ASXClient) {
+			defer wg.Done()
+			err := ctx.Err()
+			if err == nil {
+				err = fn(ctx, name, c)
+			}
+			errMutex.Lock()
+			errs[name] = err
+			errMutex.Unlock()
+		}(name, c)
+	}
+	wg.Wait()
+	return errs
+}
+
+// forEachResult - run fn against every account, collecting the results in account order
+func forEachResult[T any](ctx context.Context, m *AccountManager, fn func(c *ASXClient) (T, error)) []AccountResult[T] {
+	var mutex sync.Mutex
+	values := make(map[string]T)
+	errs := m.ForEach(ctx, func(ctx context.Context, account string, c *ASXClient) error {
+		v, err := fn(c)
+		mutex.Lock()
+		values[account] = v
+		mutex.Unlock()
+		return err
+	})
+
+	results := []AccountResult[T]{}
+	for _, name := range m.Names() {
+		results = append(results, AccountResult[T]{Account: name, Value: values[name], Err: errs[name]})
+	}
+	return results
+}
+
+// LoginAll - log in to every account
+func (m *AccountManager) LoginAll(ctx context.Context) map[string]error {
+	return m.ForEach(ctx, func(ctx context.Context, account string, c *ASXClient) error {
+		return c.Login()
+	})
+}
+
+// GetCash - get the cash balances of every account
+func (m *AccountManager) GetCash(ctx context.Context) []AccountResult[*Cash] {
+	return forEachResult(ctx, m, func(c *ASXClient) (*Cash, error) {
+		return c.GetCash()
+	})
+}
+
+// GetEquityPositions - get the equity positions of every account
+func (m *AccountManager) GetEquityPositions(ctx context.Context) []AccountResult[*EquityPositions] {
+	return forEachResult(ctx, m, func(c *ASXClient) (*EquityPositions, error) {
+		return c.GetEquityPositions()
+	})
+}
+
+// GetOrders - get the pending orders of every account
+func (m *AccountManager) GetOrders(ctx context.Context) []AccountResult[*[]OrderDetails] {
+	return forEachResult(ctx, m, func(c *ASXClient) (*[]OrderDetails, error) {
+		return c.GetOrders()
+	})
+}
+
+// Close - stop background work on every client
+func (m *AccountManager) Close() error {
+	for _, name := range m.Names() {
+		c, _ := m.Client(name)
+		c.Close()
+	}
+	return nil
+}
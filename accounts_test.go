@@ -0,0 +1,45 @@
+package stakego_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mdusher/stakego"
+)
+
+func TestLoadProfiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		missing string
+	}{
+		{"password", map[string]string{"USERNAME": "me@example.com", "PASSWORD": "secret"}, ""},
+		{"token", map[string]string{"SESSION_TOKEN": "48d345ae2321ebb7db112a6745cb7f11"}, ""},
+		{"nopassword", map[string]string{"USERNAME": "me@example.com"}, "PASSWORD"},
+		{"empty", map[string]string{}, "USERNAME, PASSWORD"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("HOME", dir)
+			t.Setenv("XDG_CONFIG_HOME", dir)
+			for k, v := range tt.env {
+				t.Setenv("STAKE_"+strings.ToUpper(tt.name)+"_"+k, v)
+			}
+			m := stakego.NewAccountManager()
+			err := m.LoadProfiles(tt.name)
+			if tt.missing == "" {
+				if err != nil {
+					t.Fatalf("LoadProfiles: %v", err)
+				}
+				if _, err := m.Client(tt.name); err != nil {
+					t.Errorf("Client: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "missing "+tt.missing) {
+				t.Errorf("LoadProfiles = %v, want missing %s", err, tt.missing)
+			}
+		})
+	}
+}
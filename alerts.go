@@ -0,0 +1,429 @@
+package stakego
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Alert rule kinds
+const AlertPrice = "price"
+const AlertDayChange = "day_change"
+const AlertPortfolioValue = "portfolio_value"
+
+// Alert rule directions
+const AlertAbove = "above"
+const AlertBelow = "below"
+
+// NotifyAlert - the notification type of a triggered alert
+const NotifyAlert = "ALERT"
+
+// DefaultAlertCooldown - minimum time between two triggers of a rule, if not set
+const DefaultAlertCooldown = time.Hour
+
+// AlertRule - a condition to alert on. Value is a price for AlertPrice, a
+// percentage for AlertDayChange and dollars for AlertPortfolioValue.
+type AlertRule struct {
+	ID     string  `json:"id"`
+	Kind   string  `json:"kind"`
+	Symbol string  `json:"symbol,omitempty"`
+	Op     string  `json:"op"`
+	Value  float64 `json:"value"`
+
+	// Hysteresis - how far back past Value, in the same unit, the value must
+	// move before the rule can trigger again
+	Hysteresis float64 `json:"hysteresis,omitempty"`
+	// Cooldown - minimum time between triggers, e.g. "30m". Defaults to an hour.
+	Cooldown string `json:"cooldown,omitempty"`
+}
+
+// ParseAlertRule - parse a rule such as "BHP below 42.00", "VAS day change
+// < -2%" or "portfolio value above 100000"
+func ParseAlertRule(s string) (*AlertRule, error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid alert rule '%s'", s)
+	}
+
+	r := AlertRule{}
+	op, value := fields[len(fields)-2], fields[len(fields)-1]
+	subject := fields[:len(fields)-2]
+	switch op {
+	case "below", "<", "under":
+		r.Op = AlertBelow
+	case "above", ">", "over":
+		r.Op = AlertAbove
+	default:
+		return nil, fmt.Errorf("invalid alert rule '%s': expected above or below", s)
+	}
+
+	switch {
+	case len(subject) == 2 && subject[0] == "portfolio" && subject[1] == "value":
+		r.Kind = AlertPortfolioValue
+	case len(subject) == 3 && subject[1] == "day" && subject[2] == "change":
+		r.Kind = AlertDayChange
+		r.Symbol = strings.ToUpper(subject[0])
+	case len(subject) == 1 || (len(subject) == 2 && subject[1] == "price"):
+		r.Kind = AlertPrice
+		r.Symbol = strings.ToUpper(subject[0])
+	default:
+		return nil, fmt.Errorf("invalid alert rule '%s'", s)
+	}
+
+	percent := strings.HasSuffix(value, "%")
+	if percent != (r.Kind == AlertDayChange) {
+		return nil, fmt.Errorf("invalid alert rule '%s': only day change is a percentage", s)
+	}
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimPrefix(value, "$"), "%"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alert rule '%s': %v", s, err)
+	}
+	r.Value = v
+	return &r, nil
+}
+
+// String - the rule in the form accepted by ParseAlertRule
+func (r AlertRule) String() string {
+	switch r.Kind {
+	case AlertDayChange:
+		return fmt.Sprintf("%s day change %s %g%%", r.Symbol, r.Op, r.Value)
+	case AlertPortfolioValue:
+		return fmt.Sprintf("portfolio value %s %.2f", r.Op, r.Value)
+	}
+	return fmt.Sprintf("%s %s %.3f", r.Symbol, r.Op, r.Value)
+}
+
+// Validate - checks the rule is complete
+func (r AlertRule) Validate() error {
+	switch r.Kind {
+	case AlertPrice, AlertDayChange:
+		if r.Symbol == "" {
+			return fmt.Errorf("alert rule '%s' needs a symbol", r)
+		}
+	case AlertPortfolioValue:
+	default:
+		return fmt.Errorf("unknown alert kind '%s'", r.Kind)
+	}
+	if r.Op != AlertAbove && r.Op != AlertBelow {
+		return fmt.Errorf("unknown alert direction '%s'", r.Op)
+	}
+	if r.Hysteresis < 0 {
+		return fmt.Errorf("alert hysteresis must not be negative")
+	}
+	if r.Cooldown != "" {
+		if _, err := time.ParseDuration(r.Cooldown); err != nil {
+			return fmt.Errorf("invalid alert cooldown '%s': %v", r.Cooldown, err)
+		}
+	}
+	return nil
+}
+
+// cooldown - the parsed Cooldown
+func (r AlertRule) cooldown() time.Duration {
+	d, err := time.ParseDuration(r.Cooldown)
+	if r.Cooldown == "" || err != nil {
+		return DefaultAlertCooldown
+	}
+	return d
+}
+
+// AlertState - a rule and whether it is waiting to re-arm
+type AlertState struct {
+	Rule        AlertRule `json:"rule"`
+	Triggered   bool      `json:"triggered"` // true until the value moves back past the hysteresis band
+	TriggeredAt time.Time `json:"triggeredAt,omitempty"`
+	LastValue   float64   `json:"lastValue,omitempty"`
+}
+
+// Alert - a triggered rule
+type Alert struct {
+	Rule  AlertRule
+	Value float64
+	Time  time.Time
+}
+
+// Notification - the alert as a notification
+func (a Alert) Notification() Notification {
+	value := fmt.Sprintf("%.3f", a.Value)
+	switch a.Rule.Kind {
+	case AlertDayChange:
+		value = fmt.Sprintf("%.2f%%", a.Value)
+	case AlertPortfolioValue:
+		value = fmt.Sprintf("$%.2f", a.Value)
+	}
+	n := Notification{
+		ID:     fmt.Sprintf("%s:%s:%d", NotifyAlert, a.Rule.ID, a.Time.Unix()),
+		Type:   NotifyAlert,
+		Time:   a.Time,
+		Title:  fmt.Sprintf("Alert: %s", a.Rule),
+		Text:   fmt.Sprintf("%s is now %s.", alertSubject(a.Rule), value),
+		Symbol: a.Rule.Symbol,
+	}
+	// only price rules have a price; the other values are in Text
+	if a.Rule.Kind == AlertPrice {
+		n.Price = a.Value
+	}
+	return n
+}
+
+// alertSubject - what a rule measures, for messages
+func alertSubject(r AlertRule) string {
+	switch r.Kind {
+	case AlertDayChange:
+		return r.Symbol + "'s day change"
+	case AlertPortfolioValue:
+		return "Portfolio value"
+	}
+	return r.Symbol
+}
+
+// alertStateFile - on disk format of the alert rules
+type alertStateFile struct {
+	Rules []AlertState `json:"rules"`
+}
+
+// NewAlertEngine - create an AlertEngine, loading any saved rules from path.
+// c may be nil if the engine is only used to manage rules.
+func NewAlertEngine(c AccountReader, path string) (*AlertEngine, error) {
+	e := AlertEngine{}
+	e.client = c
+	e.Path = path
+	e.rules = make(map[string]*AlertState)
+
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, NewStakeError("alerts", err)
+	}
+	if err == nil {
+		var sf alertStateFile
+		err = json.Unmarshal(b, &sf)
+		if err != nil {
+			return nil, NewStakeError("alerts", err)
+		}
+		for i := range sf.Rules {
+			e.rules[sf.Rules[i].Rule.ID] = &sf.Rules[i]
+		}
+	}
+	return &e, nil
+}
+
+// AlertEngine - evaluates alert rules against positions, quotes and the
+// portfolio value, triggering each rule once until it re-arms
+type AlertEngine struct {
+	Path       string
+	Quotes     PriceFunc // optional, defaults to the position's MktPrice
+	Dispatcher *NotificationDispatcher
+	OnAlert    func(Alert)
+	OnError    func(error)
+
+	client AccountReader
+	mutex  sync.Mutex
+	rules  map[string]*AlertState
+}
+
+// AddRule - add a rule, assigning it an ID if it has none
+func (e *AlertEngine) AddRule(rule AlertRule) (AlertRule, error) {
+	err := rule.Validate()
+	if err != nil {
+		return rule, NewStakeError("alerts", err)
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if rule.ID == "" {
+		// one more than the highest numeric ID, so IDs aren't reused after a removal
+		max := 0
+		for id := range e.rules {
+			if n, err := strconv.Atoi(id); err == nil && n > max {
+				max = n
+			}
+		}
+		rule.ID = strconv.Itoa(max + 1)
+	}
+	e.rules[rule.ID] = &AlertState{Rule: rule}
+	return rule, e.save()
+}
+
+// RemoveRule - delete a rule by ID
+func (e *AlertEngine) RemoveRule(id string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if _, ok := e.rules[id]; !ok {
+		return NewStakeError("alerts", fmt.Errorf("no alert rule '%s'", id))
+	}
+	delete(e.rules, id)
+	return e.save()
+}
+
+// Rules - the state of every rule, sorted by ID
+func (e *AlertEngine) Rules() []AlertState {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	states := make([]AlertState, 0, len(e.rules))
+	for _, s := range e.rules {
+		states = append(states, *s)
+	}
+	sort.Slice(states, func(i, j int) bool { return alertIDLess(states[i].Rule.ID, states[j].Rule.ID) })
+	return states
+}
+
+// alertIDLess - orders rule IDs numerically, with any IDs that aren't
+// numbers after them in string order
+func alertIDLess(a string, b string) bool {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil && x != y:
+		return x < y
+	case errA == nil && errB != nil:
+		return true
+	case errA != nil && errB == nil:
+		return false
+	}
+	return a < b
+}
+
+// save - persist the rules. Must be called with the mutex held.
+func (e *AlertEngine) save() error {
+	sf := alertStateFile{}
+	for _, s := range e.rules {
+		sf.Rules = append(sf.Rules, *s)
+	}
+	sort.Slice(sf.Rules, func(i, j int) bool { return alertIDLess(sf.Rules[i].Rule.ID, sf.Rules[j].Rule.ID) })
+	b, err := json.MarshalIndent(sf, "", "  ")
+	if err != nil {
+		return NewStakeError("alerts", err)
+	}
+	err = WriteFileAtomic(e.Path, b, 0600)
+	if err != nil {
+		return NewStakeError("alerts", err)
+	}
+	return nil
+}
+
+// Check - evaluate every rule once, returning the alerts triggered. Alerts
+// are also passed to OnAlert and sent with the Dispatcher, if set.
+func (e *AlertEngine) Check(ctx context.Context) ([]Alert, error) {
+	p, err := GetPortfolio(ctx, e.client)
+	if err != nil {
+		return nil, NewStakeError("alerts", err)
+	}
+	positions := map[string]EquityPositionItem{}
+	for _, pos := range p.Positions {
+		positions[pos.Symbol] = pos
+	}
+
+	e.mutex.Lock()
+	now := time.Now()
+	alerts := []Alert{}
+	errs := []error{}
+	changed := false
+	for _, s := range e.rules {
+		v, err := e.value(s.Rule, p, positions)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("alert %s (%s): %w", s.Rule.ID, s.Rule, err))
+			continue
+		}
+		s.LastValue = v
+		if e.evaluate(s, v, now) {
+			alerts = append(alerts, Alert{Rule: s.Rule, Value: v, Time: now})
+		}
+		changed = true
+	}
+	if changed {
+		err = e.save()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	e.mutex.Unlock()
+
+	sort.Slice(alerts, func(i, j int) bool { return alertIDLess(alerts[i].Rule.ID, alerts[j].Rule.ID) })
+	for _, a := range alerts {
+		if e.OnAlert != nil {
+			e.OnAlert(a)
+		}
+		if e.Dispatcher != nil {
+			err = e.Dispatcher.Send(ctx, a.Notification())
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return alerts, NewStakeError("alerts", errors.Join(errs...))
+	}
+	return alerts, nil
+}
+
+// evaluate - update a rule's state with the latest value, returning true if
+// it should trigger now
+func (e *AlertEngine) evaluate(s *AlertState, v float64, now time.Time) bool {
+	r := s.Rule
+	hit := (r.Op == AlertAbove && v >= r.Value) || (r.Op == AlertBelow && v <= r.Value)
+	if s.Triggered {
+		rearmed := (r.Op == AlertAbove && v < r.Value-r.Hysteresis) || (r.Op == AlertBelow && v > r.Value+r.Hysteresis)
+		if rearmed {
+			s.Triggered = false
+		}
+		return false
+	}
+	if !hit || (!s.TriggeredAt.IsZero() && now.Sub(s.TriggeredAt) < r.cooldown()) {
+		return false
+	}
+	s.Triggered = true
+	s.TriggeredAt = now
+	return true
+}
+
+// value - the current value a rule compares against
+func (e *AlertEngine) value(r AlertRule, p *Portfolio, positions map[string]EquityPositionItem) (float64, error) {
+	switch r.Kind {
+	case AlertPortfolioValue:
+		return p.TotalEquity, nil
+	case AlertPrice:
+		if e.Quotes != nil {
+			if q, err := e.Quotes(r.Symbol); err == nil && q > 0 {
+				return q, nil
+			}
+		}
+		if pos, ok := positions[r.Symbol]; ok && pos.MktPrice > 0 {
+			return pos.MktPrice, nil
+		}
+		return 0, fmt.Errorf("no quote for %s", r.Symbol)
+	case AlertDayChange:
+		pos, ok := positions[r.Symbol]
+		if !ok || pos.PriorClose <= 0 {
+			return 0, fmt.Errorf("no prior close for %s; day change rules need a held position", r.Symbol)
+		}
+		price := pos.MktPrice
+		if e.Quotes != nil {
+			if q, err := e.Quotes(r.Symbol); err == nil && q > 0 {
+				price = q
+			}
+		}
+		return (price - pos.PriorClose) / pos.PriorClose * 100, nil
+	}
+	return 0, fmt.Errorf("unknown alert kind '%s'", r.Kind)
+}
+
+// Run - check the rules every interval until ctx is done. Errors are passed
+// to OnError.
+func (e *AlertEngine) Run(ctx context.Context, interval time.Duration) error {
+	for {
+		_, err := e.Check(ctx)
+		if err != nil && ctx.Err() == nil && e.OnError != nil {
+			e.OnError(err)
+		}
+		err = sleepCtx(ctx, interval)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package stakego_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mdusher/stakego"
+	"github.com/mdusher/stakego/staketest"
+)
+
+// alertClient - a mock account holding each symbol at the given price,
+// with a prior close of 100
+func alertClient(prices map[string]float64) *staketest.MockClient {
+	return &staketest.MockClient{
+		GetCashFunc: func() (*stakego.Cash, error) {
+			return &stakego.Cash{PostedBalance: 1000}, nil
+		},
+		GetEquityPositionsFunc: func() (*stakego.EquityPositions, error) {
+			p := &stakego.EquityPositions{}
+			for sym, price := range prices {
+				p.EquityPositions = append(p.EquityPositions, stakego.EquityPositionItem{Symbol: sym, OpenQty: 10, MktPrice: price, PriorClose: 100})
+			}
+			return p, nil
+		},
+		GetOrdersFunc: func() (*[]stakego.OrderDetails, error) {
+			return &[]stakego.OrderDetails{}, nil
+		},
+	}
+}
+
+func TestAlertRuleOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	e, err := stakego.NewAlertEngine(alertClient(map[string]float64{"BHP": 50}), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"10", "b", "2", "a", "1"} {
+		if _, err := e.AddRule(stakego.AlertRule{ID: id, Kind: stakego.AlertPrice, Symbol: "BHP", Op: stakego.AlertAbove, Value: 40}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{"1", "2", "10", "a", "b"}
+	check := func(where string, ids []string) {
+		t.Helper()
+		if len(ids) != len(want) {
+			t.Fatalf("%s IDs = %v, want %v", where, ids, want)
+		}
+		for n := range want {
+			if ids[n] != want[n] {
+				t.Errorf("%s IDs = %v, want %v", where, ids, want)
+				return
+			}
+		}
+	}
+
+	ids := []string{}
+	for _, s := range e.Rules() {
+		ids = append(ids, s.Rule.ID)
+	}
+	check("Rules", ids)
+
+	alerts, err := e.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids = []string{}
+	for _, a := range alerts {
+		ids = append(ids, a.Rule.ID)
+	}
+	check("Check", ids)
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		Rules []stakego.AlertState `json:"rules"`
+	}
+	if err := json.Unmarshal(b, &saved); err != nil {
+		t.Fatal(err)
+	}
+	ids = []string{}
+	for _, s := range saved.Rules {
+		ids = append(ids, s.Rule.ID)
+	}
+	check("saved", ids)
+
+	next, err := e.AddRule(stakego.AlertRule{Kind: stakego.AlertPortfolioValue, Op: stakego.AlertAbove, Value: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.ID != "11" {
+		t.Errorf("new rule ID = %s, want 11", next.ID)
+	}
+}
+
+func TestAlertNotification(t *testing.T) {
+	now := time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		rule  stakego.AlertRule
+		value float64
+		price float64
+		text  string
+	}{
+		{stakego.AlertRule{ID: "1", Kind: stakego.AlertPrice, Symbol: "BHP", Op: stakego.AlertBelow, Value: 42}, 41.5, 41.5, "BHP is now 41.500."},
+		{stakego.AlertRule{ID: "2", Kind: stakego.AlertDayChange, Symbol: "VAS", Op: stakego.AlertBelow, Value: -2}, -2.5, 0, "VAS's day change is now -2.50%."},
+		{stakego.AlertRule{ID: "3", Kind: stakego.AlertPortfolioValue, Op: stakego.AlertAbove, Value: 100000}, 100500, 0, "Portfolio value is now $100500.00."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule.Kind, func(t *testing.T) {
+			n := stakego.Alert{Rule: tt.rule, Value: tt.value, Time: now}.Notification()
+			if n.Price != tt.price {
+				t.Errorf("Price = %v, want %v", n.Price, tt.price)
+			}
+			if n.Text != tt.text {
+				t.Errorf("Text = %q, want %q", n.Text, tt.text)
+			}
+			if n.Type != stakego.NotifyAlert || n.Symbol != tt.rule.Symbol {
+				t.Errorf("notification = %+v", n)
+			}
+		})
+	}
+}
+
+func TestAlertHysteresisAndCooldown(t *testing.T) {
+	tests := []struct {
+		name     string
+		cooldown string
+		prices   []float64
+		want     []bool // whether each check triggers
+	}{
+		{
+			name:     "triggers once while below",
+			cooldown: "1ns",
+			prices:   []float64{41, 39.5, 39, 38},
+			want:     []bool{false, true, false, false},
+		},
+		{
+			name:     "re-arms past the hysteresis band",
+			cooldown: "1ns",
+			prices:   []float64{39.5, 40.5, 39.5, 41.5, 39.5},
+			want:     []bool{true, false, false, false, true},
+		},
+		{
+			name:   "cooldown holds back a re-armed rule",
+			prices: []float64{39.5, 41.5, 39.5},
+			want:   []bool{true, false, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := stakego.NewAlertEngine(alertClient(nil), filepath.Join(t.TempDir(), "alerts.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var price float64
+			e.Quotes = func(symbol string) (float64, error) { return price, nil }
+			_, err = e.AddRule(stakego.AlertRule{Kind: stakego.AlertPrice, Symbol: "BHP", Op: stakego.AlertBelow, Value: 40, Hysteresis: 1, Cooldown: tt.cooldown})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for n, p := range tt.prices {
+				price = p
+				time.Sleep(time.Millisecond)
+				alerts, err := e.Check(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if (len(alerts) == 1) != tt.want[n] {
+					t.Errorf("check %d at %.2f: %d alerts, want triggered %v", n, p, len(alerts), tt.want[n])
+				}
+			}
+		})
+	}
+}
+
+func TestAlertCooldownSurvivesRestart(t *testing.T) {
+	tests := []struct {
+		name  string
+		since time.Duration
+		want  bool
+	}{
+		{"within cooldown", 30 * time.Minute, false},
+		{"cooldown passed", 2 * time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "alerts.json")
+			saved := map[string]interface{}{
+				"rules": []stakego.AlertState{{
+					Rule:        stakego.AlertRule{ID: "1", Kind: stakego.AlertPrice, Symbol: "BHP", Op: stakego.AlertBelow, Value: 40},
+					TriggeredAt: time.Now().Add(-tt.since),
+				}},
+			}
+			b, _ := json.Marshal(saved)
+			if err := os.WriteFile(path, b, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			hooks := staketest.NewWebhookServer("")
+			defer hooks.Close()
+			e, err := stakego.NewAlertEngine(alertClient(map[string]float64{"BHP": 39}), path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.Dispatcher = stakego.NewNotificationDispatcher(stakego.NewWebhookNotifier(hooks.URL(), ""))
+			alerts, err := e.Check(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (len(alerts) == 1) != tt.want {
+				t.Fatalf("%d alerts, want triggered %v", len(alerts), tt.want)
+			}
+			got := hooks.Received()
+			if !tt.want {
+				if len(got) != 0 {
+					t.Errorf("%d webhooks sent during the cooldown", len(got))
+				}
+				return
+			}
+			if len(got) != 1 || got[0].Notification.Type != stakego.NotifyAlert || got[0].Notification.Price != 39 {
+				t.Errorf("webhooks = %+v, want one alert at 39", got)
+			}
+		})
+	}
+}
@@ -1,17 +1,22 @@
 package stakego
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"sync"
+	"time"
 )
 
 // NewASXClient - create and initialise an ASXClient
-func NewASXClient() *ASXClient {
+func NewASXClient(opts ...ClientOption) *ASXClient {
 	c := ASXClient{}
 	c.Init()
+	for _, opt := range opts {
+		opt(&c)
+	}
 	return &c
 }
 
@@ -19,10 +24,28 @@ func NewASXClient() *ASXClient {
 type ASXClient struct {
 	apiUrl      string
 	Credentials *Credentials
-	User        *User
-	httpclient  http.Client
-	tokenMutex  sync.Mutex
-	authMutex   sync.Mutex
+	User        *User        // set by Login; use CurrentUser while a keepalive may log in again
+	Session     *UserSession // set by Login; use CurrentSession while a keepalive may log in again
+	Calendar    *CalendarProvider
+	TokenStore  TokenStore
+
+	RiskPolicies []RiskPolicy
+	KillSwitch   *KillSwitch
+	// OnRiskRejection - called when a risk policy or the kill switch rejects an order
+	OnRiskRejection func(*RiskRejection)
+
+	httpclient http.Client
+	tokenMutex sync.Mutex
+	authMutex  sync.Mutex
+
+	sessionIssuedAt  time.Time
+	sessionExpiresAt time.Time
+	reloginCount     int
+	keepaliveCancel  context.CancelFunc
+	keepaliveDone    chan struct{}
+	paper            *PaperLedger
+	limiter          *rateLimiter
+	metrics          *RequestMetrics
 }
 
 // ResponseData - holds http response
@@ -31,37 +54,94 @@ type ResponseData struct {
 	Body       []byte
 }
 
+// IsPaperTrading - checks if orders are being sent to a simulated ledger
+func (c *ASXClient) IsPaperTrading() bool {
+	return c.paper != nil
+}
+
 // Init - initialise the ASX client with defaults
 func (c *ASXClient) Init() {
 	c.apiUrl = "https://global-prd-api.hellostake.com/api/"
 	c.httpclient = NewHTTPClient()
+	c.metrics = NewRequestMetrics()
+	c.Calendar = NewCalendarProvider()
+	c.Calendar.Metrics = c.metrics
 }
 
-// Login - create a user session
+// Login - create a user session. If a TokenStore is set, a saved token
+// is tried first and any newly created token is saved to it. An error
+// reading the store is returned; clear the store to log in again.
 func (c *ASXClient) Login() (err error) {
 	c.authMutex.Lock()
 	defer c.authMutex.Unlock()
 
-	if c.Credentials.GetSessionToken() == "" {
-		u, err := url.JoinPath(c.apiUrl, "sessions/v2/createSession")
+	if c.Credentials.GetSessionToken() == "" && c.TokenStore != nil {
+		// a store that can't be read, e.g. with the wrong passphrase, is an
+		// error rather than a reason to log in with the password
+		ss, err := c.TokenStore.Load()
 		if err != nil {
 			return NewStakeError("login", err)
 		}
+		if ss != nil && (ss.ExpiresAt.IsZero() || time.Now().Before(ss.ExpiresAt)) {
+			c.Credentials.SetSessionToken(ss.Token)
+			u, err := c.GetUser()
+			if err == nil {
+				c.setUser(u)
+				us := ss.Session
+				if us == nil {
+					us = sessionFromUser(u, ss.Token)
+				}
+				c.setSession(us)
+				c.setSessionTimes(ss.IssuedAt, ss.ExpiresAt)
+				return nil
+			}
+			c.Credentials.SetSessionToken("")
+		}
+	}
 
-		req, _ := NewJSONRequest("POST", u, c.Credentials.AsJSON())
-		resp, err := c.httpclient.Do(req)
+	if c.Credentials.GetSessionToken() == "" {
+		rd, err := c.createSession()
 		if err != nil {
 			return NewStakeError("login", err)
 		}
 
-		if resp.StatusCode == 200 {
-			defer resp.Body.Close()
-			rbody, err := io.ReadAll(resp.Body)
+		// Stake may ask for an OTP after the first password attempt
+		if rd.StatusCode != 200 && c.Credentials.OTP == "" && c.Credentials.OTPProvider != nil {
+			if NewAuthErrorFromResponse(rd).Reason == AuthOTPRequired {
+				otp, err := c.Credentials.OTPProvider.GetOTP()
+				if err != nil {
+					return NewStakeError("login", err)
+				}
+				c.Credentials.OTP = otp
+				rd, err = c.createSession()
+				c.Credentials.OTP = ""
+				if err != nil {
+					return NewStakeError("login", err)
+				}
+			}
+		}
+
+		if rd.StatusCode != 200 {
+			return NewStakeError("login", NewAuthErrorFromResponse(rd))
+		}
+
+		us := NewUserSessionFromJSON(rd.Body)
+		if us == nil || us.SessionKey == "" {
+			return NewStakeError("login", ErrInvalidAPIResponse)
+		}
+		c.setSession(us)
+		c.Credentials.SetSessionToken(us.SessionKey)
+		issued := time.Now()
+		expires := time.Time{}
+		if c.Credentials.RememberMeDays > 0 {
+			expires = issued.AddDate(0, 0, c.Credentials.RememberMeDays)
+		}
+		c.setSessionTimes(issued, expires)
+		if c.TokenStore != nil {
+			err = c.TokenStore.Save(StoredSession{Token: us.SessionKey, Session: us, IssuedAt: issued, ExpiresAt: expires})
 			if err != nil {
 				return NewStakeError("login", err)
 			}
-			u := NewUserSessionFromJSON(rbody)
-			c.Credentials.SetSessionToken(u.SessionKey)
 		}
 	}
 
@@ -69,14 +149,85 @@ func (c *ASXClient) Login() (err error) {
 		return NewStakeError("login", ErrSessionTokenMissing)
 	}
 
-	c.User, err = c.GetUser()
+	u, err := c.GetUser()
 	if err != nil {
 		return NewStakeError("login", err)
 	}
+	c.setUser(u)
 
 	return nil
 }
 
+// CurrentUser - the user fetched by the last Login, safe to call while a
+// keepalive is running
+func (c *ASXClient) CurrentUser() *User {
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
+	return c.User
+}
+
+// CurrentSession - the session created by the last Login, safe to call
+// while a keepalive is running
+func (c *ASXClient) CurrentSession() *UserSession {
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
+	return c.Session
+}
+
+// setUser - set User while holding the token mutex
+func (c *ASXClient) setUser(u *User) {
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
+	c.User = u
+}
+
+// setSession - set Session while holding the token mutex
+func (c *ASXClient) setSession(us *UserSession) {
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
+	c.Session = us
+}
+
+// sessionFromUser - the parts of a UserSession that can be recovered from
+// the user, for a resumed session saved without one
+func sessionFromUser(u *User, token string) *UserSession {
+	us := UserSession{}
+	us.UserID = u.UserID
+	us.FirstName = u.FirstName
+	us.LastName = u.LastName
+	us.Email = u.EmailAddress
+	us.SessionKey = token
+	us.MacStatus = u.MacStatus
+	us.TruliooStatus = u.TruliooStatus
+	return &us
+}
+
+// createSession - post the credentials to the createSession API
+func (c *ASXClient) createSession() (*ResponseData, error) {
+	u, err := url.JoinPath(c.apiUrl, "sessions/v2/createSession")
+	if err != nil {
+		return nil, err
+	}
+
+	req, _ := NewJSONRequest("POST", u, c.Credentials.AsJSON())
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var rd ResponseData
+	rd.StatusCode = resp.StatusCode
+
+	defer resp.Body.Close()
+	rbody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	rd.Body = rbody
+
+	return &rd, nil
+}
+
 // Logout - end a user session
 func (c *ASXClient) Logout() (err error) {
 	c.authMutex.Lock()
@@ -92,44 +243,42 @@ func (c *ASXClient) Logout() (err error) {
 	}
 
 	req, _ := NewJSONRequest("DELETE", u, nil)
-	resp, err := c.httpclient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return NewStakeError("logout", err)
 	}
 
 	if resp.StatusCode == 200 {
 		c.Credentials.SetSessionToken("")
+		c.setSessionTimes(time.Time{}, time.Time{})
+		if c.TokenStore != nil {
+			err = c.TokenStore.Clear()
+			if err != nil {
+				return NewStakeError("logout", err)
+			}
+		}
 		return nil
 	}
 
-	return NewStakeError("cash", ErrInvalidAPIResponse)
+	return NewStakeError("logout", ErrInvalidAPIResponse)
 }
 
 // GetMarket - Get the current market status
 func (c *ASXClient) GetMarket() (*Market, error) {
-	u := "https://d2bpoo7jm9cntm.cloudfront.net/_get_location"
-	req, _ := NewJSONRequest("GET", u, nil)
-	resp, err := c.httpclient.Do(req)
+	l, err := c.Calendar.GetLocationData()
 	if err != nil {
 		return nil, NewStakeError("market", err)
 	}
-
-	if resp.StatusCode == 200 {
-		defer resp.Body.Close()
-		rbody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, NewStakeError("market", err)
-		}
-		l := NewLocationFromJSON(rbody)
-		m := NewMarketWithLocationData(l)
-		return m, nil
-	}
-
-	return nil, NewStakeError("location", ErrInvalidAPIResponse)
+	m := NewMarketWithLocationData(l)
+	return m, nil
 }
 
 // GetCash - get the current available cash
 func (c *ASXClient) GetCash() (*Cash, error) {
+	if c.paper != nil {
+		return c.paper.GetCash()
+	}
+
 	u, err := url.JoinPath(c.apiUrl, "asx/cash")
 	if err != nil {
 		return nil, NewStakeError("cash", err)
@@ -149,6 +298,10 @@ func (c *ASXClient) GetCash() (*Cash, error) {
 
 // GetEquityPositions - get the current user's equity positions
 func (c *ASXClient) GetEquityPositions() (*EquityPositions, error) {
+	if c.paper != nil {
+		return c.paper.GetEquityPositions()
+	}
+
 	u, err := url.JoinPath(c.apiUrl, "asx/instrument/equityPositions")
 	if err != nil {
 		return nil, NewStakeError("equity positions", err)
@@ -182,12 +335,19 @@ func (c *ASXClient) GetUser() (*User, error) {
 		user := NewUserFromJSON(rd.Body)
 		return user, nil
 	}
+	if rd.StatusCode == 401 {
+		return nil, NewStakeError("user", ErrSessionTokenMissing)
+	}
 
 	return nil, NewStakeError("user", ErrInvalidAPIResponse)
 }
 
 // GetOrders - get pending orders
 func (c *ASXClient) GetOrders() (*[]OrderDetails, error) {
+	if c.paper != nil {
+		return c.paper.GetOrders()
+	}
+
 	u, err := url.JoinPath(c.apiUrl, "asx/orders")
 	if err != nil {
 		return nil, NewStakeError("orders", err)
@@ -205,8 +365,27 @@ func (c *ASXClient) GetOrders() (*[]OrderDetails, error) {
 	return nil, NewStakeError("orders", ErrInvalidAPIResponse)
 }
 
-// PlaceOrder - place an order
-func (c *ASXClient) PlaceOrder(order Order) (*OrderResponse, error) {
+// PlaceOrder - place an order, after checking it against the kill switch
+// and risk policies
+func (c *ASXClient) PlaceOrder(order Order) (resp *OrderResponse, err error) {
+	err = c.checkRisk(context.Background(), order)
+	if err != nil {
+		return nil, NewStakeError("orders/place", err)
+	}
+	defer func() {
+		if err != nil {
+			c.releaseRisk(order, c.RiskPolicies)
+		}
+	}()
+
+	if c.paper != nil {
+		resp, err = c.paper.PlaceOrder(order)
+		if err != nil {
+			return nil, NewStakeError("orders/place", err)
+		}
+		return resp, nil
+	}
+
 	u, err := url.JoinPath(c.apiUrl, "asx/orders")
 	if err != nil {
 		return nil, NewStakeError("orders/place", err)
@@ -227,6 +406,14 @@ func (c *ASXClient) PlaceOrder(order Order) (*OrderResponse, error) {
 
 // CancelOrder - cancel an order
 func (c *ASXClient) CancelOrder(uuid string) error {
+	if c.paper != nil {
+		err := c.paper.CancelOrder(uuid)
+		if err != nil {
+			return NewStakeError("orders/cancel", err)
+		}
+		return nil
+	}
+
 	u, err := url.JoinPath(c.apiUrl, "asx/orders", uuid, "cancel")
 	if err != nil {
 		return NewStakeError("orders/cancel", err)
@@ -244,7 +431,7 @@ func (c *ASXClient) CancelOrder(uuid string) error {
 	return NewStakeError("orders/cancel", ErrInvalidAPIResponse)
 }
 
-// GetBrokerage - get the current available cash
+// GetBrokerage - get the brokerage charged on an order of the given value
 func (c *ASXClient) GetBrokerage(price float64) (*Brokerage, error) {
 	u, err := url.JoinPath(c.apiUrl, "asx/orders/brokerage")
 	if err != nil {
@@ -254,6 +441,9 @@ func (c *ASXClient) GetBrokerage(price float64) (*Brokerage, error) {
 	u = fmt.Sprintf("%s?orderAmount=%.2f", u, price)
 
 	rd, err := c.AuthedRequest("GET", u, nil)
+	if err != nil {
+		return nil, NewStakeError("brokerage", err)
+	}
 	if rd.StatusCode == 200 {
 		b := NewBrokerageFromJSON(rd.Body)
 		return b, nil
@@ -264,35 +454,62 @@ func (c *ASXClient) GetBrokerage(price float64) (*Brokerage, error) {
 
 // LookupInstrument - get an instrument by symbol
 func (c *ASXClient) LookupInstrument(keyword string) (*Instrument, error) {
-	u, err := url.JoinPath(c.apiUrl, "asx/instrument/search")
+	ir, err := c.SearchInstruments(keyword, 1)
 	if err != nil {
 		return nil, NewStakeError("instrument", err)
 	}
 
-	u = fmt.Sprintf("%s?searchKey=%s&max=1", u, keyword)
+	if len(ir.Instruments) > 0 {
+		return &ir.Instruments[0], nil
+	}
+	return nil, NewStakeError("instrument", fmt.Errorf("No instrument for '%s' found", keyword))
+}
+
+// SearchInstruments - search for up to max instruments matching keyword
+func (c *ASXClient) SearchInstruments(keyword string, max int) (*InstrumentResponse, error) {
+	u, err := url.JoinPath(c.apiUrl, "asx/instrument/search")
+	if err != nil {
+		return nil, NewStakeError("instrument search", err)
+	}
+
+	u = fmt.Sprintf("%s?searchKey=%s&max=%d", u, url.QueryEscape(keyword), max)
 
 	rd, err := c.AuthedRequest("GET", u, nil)
+	if err != nil {
+		return nil, NewStakeError("instrument search", err)
+	}
 	if rd.StatusCode == 200 {
 		ir := NewInstrumentResponseFromJSON(rd.Body)
-
-		if len(ir.Instruments) > 0 {
-			return &ir.Instruments[0], nil
+		if ir != nil {
+			return ir, nil
 		}
-		return nil, NewStakeError("instrument", fmt.Errorf("No instrument for '%s' found", keyword))
 	}
 
-	return nil, NewStakeError("instrument", ErrInvalidAPIResponse)
+	return nil, NewStakeError("instrument search", ErrInvalidAPIResponse)
 }
 
 // AuthedRequest - perform a http request and send auth token
 func (c *ASXClient) AuthedRequest(method string, fullurl string, jsonBody []byte) (*ResponseData, error) {
+	return c.AuthedRequestContext(context.Background(), method, fullurl, jsonBody)
+}
+
+// AuthedRequestContext - perform a http request bound to ctx and send auth token
+func (c *ASXClient) AuthedRequestContext(ctx context.Context, method string, fullurl string, jsonBody []byte) (*ResponseData, error) {
 	if c.Credentials.GetSessionToken() == "" {
 		return nil, ErrSessionTokenMissing
 	}
 
-	req, _ := NewJSONRequest(method, fullurl, jsonBody)
+	if c.limiter != nil {
+		c.limiter.wait()
+	}
+
+	req, err := NewJSONRequest(method, fullurl, jsonBody)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
 	req.Header.Set("Stake-Session-Token", c.Credentials.GetSessionToken())
-	resp, err := c.httpclient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,95 @@
+package stakego
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AuthFailureReason - why a login attempt was rejected
+type AuthFailureReason string
+
+const AuthInvalidCredentials AuthFailureReason = "invalid credentials"
+const AuthOTPRequired AuthFailureReason = "OTP required"
+const AuthOTPInvalid AuthFailureReason = "OTP invalid"
+const AuthAccountLocked AuthFailureReason = "account locked"
+const AuthMFANotEnabled AuthFailureReason = "MFA not enabled"
+const AuthUnknown AuthFailureReason = "unknown"
+
+// AuthError - returned by Login when Stake rejects the createSession request
+type AuthError struct {
+	Reason     AuthFailureReason
+	StatusCode int
+	Message    string
+}
+
+// Error - error compatible message
+func (e *AuthError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("authentication failed: %s (status %d)", e.Reason, e.StatusCode)
+	}
+	return fmt.Sprintf("authentication failed: %s: %s", e.Reason, e.Message)
+}
+
+// Is - allows errors.Is(err, ErrOTPRequired) to match an OTP required AuthError
+func (e *AuthError) Is(target error) bool {
+	return e.Reason == AuthOTPRequired && target == ErrOTPRequired
+}
+
+// authErrorBody - fields Stake uses to describe a failed request
+type authErrorBody struct {
+	Message   string      `json:"message"`
+	Error     string      `json:"error"`
+	ErrorCode interface{} `json:"errorCode"`
+	Code      interface{} `json:"code"`
+	Status    interface{} `json:"status"`
+}
+
+// NewAuthErrorFromResponse - decode a failed createSession response into an AuthError
+func NewAuthErrorFromResponse(rd *ResponseData) *AuthError {
+	e := AuthError{}
+	e.StatusCode = rd.StatusCode
+	e.Reason = AuthUnknown
+
+	var b authErrorBody
+	if json.Unmarshal(rd.Body, &b) == nil {
+		e.Message = b.Message
+		if e.Message == "" {
+			e.Message = b.Error
+		}
+	} else {
+		e.Message = strings.TrimSpace(string(rd.Body))
+	}
+
+	text := strings.ToLower(fmt.Sprintf("%s %s %v %v %v", b.Message, b.Error, b.ErrorCode, b.Code, b.Status))
+	if e.Message == "" || len(e.Message) > 200 {
+		text = strings.ToLower(string(rd.Body))
+	}
+	has := func(words ...string) bool {
+		for _, w := range words {
+			if strings.Contains(text, w) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// MFA first, "two factor authentication is disabled" isn't a locked account
+	switch {
+	case has("mfa", "two factor", "2fa") && has("not enabled", "not set up", "not configured", "disabled"):
+		e.Reason = AuthMFANotEnabled
+	case has("locked", "too many", "suspended", "disabled"):
+		e.Reason = AuthAccountLocked
+	case has("otp", "mfa", "two factor", "2fa", "verification code"):
+		if has("invalid", "incorrect", "wrong", "expired", "mismatch") {
+			e.Reason = AuthOTPInvalid
+		} else {
+			e.Reason = AuthOTPRequired
+		}
+	case has("password", "credential", "username", "unauthori"),
+		rd.StatusCode == http.StatusUnauthorized, rd.StatusCode == http.StatusForbidden:
+		e.Reason = AuthInvalidCredentials
+	}
+	return &e
+}
@@ -0,0 +1,46 @@
+package stakego_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mdusher/stakego"
+)
+
+func TestNewAuthErrorFromResponse(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   stakego.AuthFailureReason
+	}{
+		{401, `{"message": "Invalid username or password"}`, stakego.AuthInvalidCredentials},
+		{401, ``, stakego.AuthInvalidCredentials},
+		{403, `{"error": "Forbidden"}`, stakego.AuthInvalidCredentials},
+		{400, `{"message": "OTP is required"}`, stakego.AuthOTPRequired},
+		{400, `{"message": "Please enter your verification code"}`, stakego.AuthOTPRequired},
+		{400, `{"message": "Invalid OTP"}`, stakego.AuthOTPInvalid},
+		{400, `{"message": "The two factor code has expired"}`, stakego.AuthOTPInvalid},
+		{423, `{"message": "Account locked after too many attempts"}`, stakego.AuthAccountLocked},
+		{403, `{"message": "This account has been suspended"}`, stakego.AuthAccountLocked},
+		{403, `{"message": "Your account is disabled"}`, stakego.AuthAccountLocked},
+		{400, `{"message": "MFA is not enabled for this user"}`, stakego.AuthMFANotEnabled},
+		{400, `{"message": "two factor authentication is disabled for this account"}`, stakego.AuthMFANotEnabled},
+		{400, `{"message": "2FA not set up"}`, stakego.AuthMFANotEnabled},
+		{500, `{"message": "Internal error"}`, stakego.AuthUnknown},
+		{500, `Bad Gateway`, stakego.AuthUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			e := stakego.NewAuthErrorFromResponse(&stakego.ResponseData{StatusCode: tt.status, Body: []byte(tt.body)})
+			if e.Reason != tt.want {
+				t.Errorf("Reason = %q, want %q", e.Reason, tt.want)
+			}
+			if e.StatusCode != tt.status {
+				t.Errorf("StatusCode = %d, want %d", e.StatusCode, tt.status)
+			}
+			if errors.Is(e, stakego.ErrOTPRequired) != (tt.want == stakego.AuthOTPRequired) {
+				t.Errorf("errors.Is(ErrOTPRequired) = %v for %q", errors.Is(e, stakego.ErrOTPRequired), tt.want)
+			}
+		})
+	}
+}
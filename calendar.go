@@ -0,0 +1,176 @@
+package stakego
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// LocationDataURL - where the location and trading calendar data is served from
+var LocationDataURL = "https://d2bpoo7jm9cntm.cloudfront.net/_get_location"
+
+// CalendarDefaultTTL - how long fetched location data is considered fresh
+var CalendarDefaultTTL = 6 * time.Hour
+
+// NewCalendarProvider - create a CalendarProvider with defaults
+func NewCalendarProvider() *CalendarProvider {
+	p := CalendarProvider{}
+	p.URL = LocationDataURL
+	p.TTL = CalendarDefaultTTL
+	p.httpclient = NewHTTPClient()
+	return &p
+}
+
+// CalendarProvider - fetches and caches LocationData in memory and
+// optionally on disk, serving the last known calendar when offline
+type CalendarProvider struct {
+	URL       string
+	TTL       time.Duration
+	CachePath string          // optional, disk cache is disabled when empty
+	Metrics   *RequestMetrics // optional, records fetches under the "location" endpoint
+
+	httpclient http.Client
+	mutex      sync.Mutex
+	data       *LocationData
+	raw        []byte
+	etag       string
+	fetchedAt  time.Time
+}
+
+// calendarCacheFile - on disk representation of the cached location data
+type calendarCacheFile struct {
+	ETag      string          `json:"etag"`
+	FetchedAt time.Time       `json:"fetchedAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// SetHTTPClient - replace the http.Client used to fetch location data
+func (p *CalendarProvider) SetHTTPClient(hc http.Client) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.httpclient = hc
+}
+
+// FetchedAt - when the cached location data was last fetched or revalidated
+func (p *CalendarProvider) FetchedAt() time.Time {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.fetchedAt
+}
+
+// GetLocationData - returns the cached location data, refreshing it if the
+// TTL has expired. If a refresh fails, the last known data is returned.
+func (p *CalendarProvider) GetLocationData() (*LocationData, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.data == nil && p.CachePath != "" {
+		p.loadFromDisk()
+	}
+
+	if p.data != nil && time.Since(p.fetchedAt) < p.TTL {
+		return p.data, nil
+	}
+
+	err := p.refresh()
+	if err != nil {
+		if p.data != nil {
+			return p.data, nil
+		}
+		return nil, NewStakeError("calendar", err)
+	}
+	return p.data, nil
+}
+
+// Refresh - force a refresh of the location data, ignoring the TTL
+func (p *CalendarProvider) Refresh() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	err := p.refresh()
+	if err != nil {
+		return NewStakeError("calendar", err)
+	}
+	return nil
+}
+
+// refresh - fetch the location data, revalidating with the ETag if we have one.
+// Must be called with the mutex held.
+func (p *CalendarProvider) refresh() error {
+	req, _ := NewJSONRequest("GET", p.URL, nil)
+	if p.etag != "" && p.data != nil {
+		req.Header.Set("If-None-Match", p.etag)
+	}
+	start := time.Now()
+	resp, err := p.httpclient.Do(req)
+	if p.Metrics != nil {
+		p.Metrics.Observe(req.Method, "location", time.Since(start), classifyRequest(resp, err))
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		p.fetchedAt = time.Now()
+		p.saveToDisk()
+		return nil
+	}
+
+	if resp.StatusCode == 200 {
+		rbody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		l := NewLocationFromJSON(rbody)
+		if l == nil {
+			return ErrInvalidAPIResponse
+		}
+		p.data = l
+		p.raw = rbody
+		p.etag = resp.Header.Get("ETag")
+		p.fetchedAt = time.Now()
+		p.saveToDisk()
+		return nil
+	}
+
+	return ErrInvalidAPIResponse
+}
+
+// loadFromDisk - populate the in-memory cache from CachePath.
+// Must be called with the mutex held.
+func (p *CalendarProvider) loadFromDisk() {
+	b, err := os.ReadFile(p.CachePath)
+	if err != nil {
+		return
+	}
+	var cf calendarCacheFile
+	if json.Unmarshal(b, &cf) != nil {
+		return
+	}
+	l := NewLocationFromJSON(cf.Data)
+	if l == nil {
+		return
+	}
+	p.data = l
+	p.raw = cf.Data
+	p.etag = cf.ETag
+	p.fetchedAt = cf.FetchedAt
+}
+
+// saveToDisk - write the in-memory cache to CachePath, if set.
+// Must be called with the mutex held.
+func (p *CalendarProvider) saveToDisk() {
+	if p.CachePath == "" || p.raw == nil {
+		return
+	}
+	cf := calendarCacheFile{ETag: p.etag, FetchedAt: p.fetchedAt, Data: p.raw}
+	b, err := json.Marshal(cf)
+	if err != nil {
+		return
+	}
+	_ = WriteFileAtomic(p.CachePath, b, 0644)
+}
@@ -0,0 +1,46 @@
+package stakego
+
+// AccountReader - reads account information
+type AccountReader interface {
+	GetUser() (*User, error)
+	GetCash() (*Cash, error)
+	GetEquityPositions() (*EquityPositions, error)
+	GetOrders() (*[]OrderDetails, error)
+}
+
+// OrderPlacer - places and cancels orders
+type OrderPlacer interface {
+	PlaceOrder(order Order) (*OrderResponse, error)
+	CancelOrder(uuid string) error
+	GetBrokerage(price float64) (*Brokerage, error)
+}
+
+// MarketDataReader - reads market status and instrument data
+type MarketDataReader interface {
+	GetMarket() (*Market, error)
+	LookupInstrument(keyword string) (*Instrument, error)
+}
+
+// Client - everything the higher level helpers need from a Stake client.
+// ASXClient satisfies it; fakes, caching layers and decorators can too.
+type Client interface {
+	AccountReader
+	OrderPlacer
+	MarketDataReader
+}
+
+var _ Client = (*ASXClient)(nil)
+
+// brokerageFrom - a BrokerageFunc backed by an OrderPlacer's GetBrokerage
+func brokerageFrom(c OrderPlacer) BrokerageFunc {
+	return func(amount float64) (float64, error) {
+		b, err := c.GetBrokerage(amount)
+		if err != nil {
+			return 0, err
+		}
+		if b == nil {
+			return 0, ErrInvalidAPIResponse
+		}
+		return b.BrokerageFee, nil
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mdusher/stakego"
+)
+
+func init() {
+	register("alert", "add RULE | list | rm ID | run",
+		`manage price alerts, e.g. alert add "BHP below 42.00"`, runAlert)
+}
+
+// alertsPath - where alert rules are saved, unless STAKE_ALERTS_FILE is set
+func alertsPath() string {
+	return stakego.GetEnv("STAKE_ALERTS_FILE", filepath.Join(stakego.DefaultConfigDir(), "alerts.json"))
+}
+
+func runAlert(a *app, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "add":
+		return runAlertAdd(a, args[1:])
+	case "list", "ls":
+		return runAlertList(a, args[1:])
+	case "rm", "remove":
+		return runAlertRemove(a, args[1:])
+	case "run":
+		return runAlertRun(a, args[1:])
+	}
+	return errUsage
+}
+
+// takeOption - remove "--name VALUE" or "--name=VALUE" from args. Rules
+// contain negative numbers, so the flag package can't be used.
+func takeOption(args []string, name string) (string, []string, error) {
+	rest := []string{}
+	value := ""
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--"+name:
+			if i+1 >= len(args) {
+				return "", nil, fmt.Errorf("flag --%s needs a value", name)
+			}
+			value = args[i+1]
+			i++
+		case strings.HasPrefix(args[i], "--"+name+"="):
+			value = strings.TrimPrefix(args[i], "--"+name+"=")
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	return value, rest, nil
+}
+
+// runAlertAdd - alert add RULE [--hysteresis N] [--cooldown DURATION]
+func runAlertAdd(a *app, args []string) error {
+	hysteresis, args, err := takeOption(args, "hysteresis")
+	if err != nil {
+		return err
+	}
+	cooldown, args, err := takeOption(args, "cooldown")
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	rule, err := stakego.ParseAlertRule(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	if hysteresis != "" {
+		rule.Hysteresis, err = strconv.ParseFloat(strings.TrimSuffix(hysteresis, "%"), 64)
+		if err != nil {
+			return fmt.Errorf("invalid hysteresis '%s'", hysteresis)
+		}
+	}
+	rule.Cooldown = cooldown
+
+	e, err := stakego.NewAlertEngine(nil, alertsPath())
+	if err != nil {
+		return err
+	}
+	added, err := e.AddRule(*rule)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Added alert %s: %s\n", added.ID, added)
+	return nil
+}
+
+func runAlertList(a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	e, err := stakego.NewAlertEngine(nil, alertsPath())
+	if err != nil {
+		return err
+	}
+	rules := e.Rules()
+	t := table{value: rules, headers: []string{"ID", "RULE", "HYSTERESIS", "COOLDOWN", "TRIGGERED", "LAST TRIGGERED", "LAST VALUE"}}
+	for _, s := range rules {
+		last := ""
+		if !s.TriggeredAt.IsZero() {
+			last = s.TriggeredAt.Local().Format("2006-01-02 15:04")
+		}
+		t.addRow(s.Rule.ID, s.Rule.String(), s.Rule.Hysteresis, s.Rule.Cooldown, s.Triggered, last, s.LastValue)
+	}
+	return a.print(&t)
+}
+
+func runAlertRemove(a *app, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	e, err := stakego.NewAlertEngine(nil, alertsPath())
+	if err != nil {
+		return err
+	}
+	err = e.RemoveRule(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Removed alert %s\n", args[0])
+	return nil
+}
+
+// runAlertRun - check the rules every --interval, printing alerts and
+// sending them to any configured outputs
+func runAlertRun(a *app, args []string) error {
+	fs := flag.NewFlagSet("alert run", flag.ContinueOnError)
+	webhook := fs.String("webhook", "", "post alerts to this URL, signed with STAKE_WEBHOOK_SECRET")
+	slack := fs.String("slack", "", "post alerts to this Slack incoming webhook URL")
+	command := fs.String("command", "", "run this shell command for each alert")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		return errUsage
+	}
+
+	c, err := a.login()
+	if err != nil {
+		return err
+	}
+	e, err := stakego.NewAlertEngine(c, alertsPath())
+	if err != nil {
+		return err
+	}
+	if len(e.Rules()) == 0 {
+		return fmt.Errorf("no alert rules, add one with: stake alert add RULE")
+	}
+
+	notifiers := []stakego.Notifier{}
+	if *webhook != "" {
+		notifiers = append(notifiers, stakego.NewWebhookNotifier(*webhook, stakego.GetEnv("STAKE_WEBHOOK_SECRET", "")))
+	}
+	if *slack != "" {
+		notifiers = append(notifiers, stakego.NewSlackNotifier(*slack))
+	}
+	if *command != "" {
+		notifiers = append(notifiers, stakego.NewCommandNotifier("sh", "-c", *command))
+	}
+	if len(notifiers) > 0 {
+		e.Dispatcher = stakego.NewNotificationDispatcher(notifiers...)
+		e.Dispatcher.OnError = func(n stakego.Notification, err error) {
+			log.Printf("alert %s not delivered: %v", n.ID, err)
+		}
+	}
+	e.OnAlert = func(al stakego.Alert) {
+		fmt.Printf("%s  %s\n", al.Time.Local().Format("2006-01-02 15:04:05"), al.Notification().Text)
+	}
+	e.OnError = func(err error) {
+		log.Print(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	log.Printf("checking %d alert rules every %s", len(e.Rules()), a.interval)
+	err = e.Run(ctx, a.interval)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
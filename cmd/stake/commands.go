@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mdusher/stakego"
+)
+
+func init() {
+	register("login", "", "log in and save the session token", runLogin)
+	register("logout", "", "end the session and remove the saved token", runLogout)
+	register("whoami", "", "show the logged in user", runWhoami)
+	register("cash", "", "show cash balances", runCash)
+	register("positions", "", "show equity positions", runPositions)
+	register("orders", "", "show pending orders", runOrders)
+	register("buy", "SYMBOL UNITS @PRICE", "place a limit buy order", runTrade(stakego.OrderBUY))
+	register("sell", "SYMBOL UNITS @PRICE", "place a limit sell order", runTrade(stakego.OrderSELL))
+	register("cancel", "ID", "cancel a pending order", runCancel)
+	register("brokerage", "AMOUNT", "estimate brokerage for an order value", runBrokerage)
+	register("search", "QUERY", "search for instruments", runSearch)
+	register("market", "", "show the market phase and today's session", runMarket)
+}
+
+// errCancelled - returned when a confirmation prompt is declined
+var errCancelled = errors.New("cancelled")
+
+// confirm - ask a yes/no question on the terminal, unless --yes was given
+func (a *app) confirm(prompt string) error {
+	if a.yes {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", prompt)
+	line, _ := a.stdin.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return nil
+	}
+	return errCancelled
+}
+
+func runLogin(a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	c, err := a.login()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Logged in as %s %s (%s)\n", c.User.FirstName, c.User.LastName, c.User.EmailAddress)
+	return nil
+}
+
+func runLogout(a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	c, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	if c.Credentials.GetSessionToken() == "" {
+		ss, err := c.TokenStore.Load()
+		if err != nil {
+			return err
+		}
+		if ss == nil {
+			return fmt.Errorf("not logged in")
+		}
+		c.Credentials.SetSessionToken(ss.Token)
+	}
+	err = c.Logout()
+	if err != nil {
+		// the session may already have expired, forget it anyway
+		_ = c.TokenStore.Clear()
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Logged out")
+	return nil
+}
+
+func runWhoami(a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	c, err := a.login()
+	if err != nil {
+		return err
+	}
+	u := c.User
+	t := table{value: u, headers: []string{"USER ID", "NAME", "EMAIL", "ACCOUNT TYPE", "STATUS"}}
+	t.addRow(u.UserID, u.FirstName+" "+u.LastName, u.EmailAddress, u.AccountType, u.AccountStatus)
+	return a.print(&t)
+}
+
+func runCash(a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	c, err := a.login()
+	if err != nil {
+		return err
+	}
+	cash, err := c.GetCash()
+	if err != nil {
+		return err
+	}
+	t := table{value: cash, headers: []string{"BALANCE", "AMOUNT"}}
+	t.addRow("Buying power", cash.BuyingPower)
+	t.addRow("Settled cash", cash.SettledCash)
+	t.addRow("Posted balance", cash.PostedBalance)
+	t.addRow("Trade settlement", cash.TradeSettlement)
+	t.addRow("Pending buys", cash.PendingBuys)
+	t.addRow("Pending withdrawals", cash.PendingWithdrawals)
+	t.addRow("Available for withdrawal", cash.CashAvailableForWithdrawal)
+	return a.print(&t)
+}
+
+func runPositions(a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	c, err := a.login()
+	if err != nil {
+		return err
+	}
+	p, err := c.GetEquityPositions()
+	if err != nil {
+		return err
+	}
+	t := table{value: p.EquityPositions, headers: []string{"SYMBOL", "UNITS", "AVG PRICE", "PRICE", "VALUE", "DAY P&L", "DAY %", "P&L", "P&L %"}}
+	for _, ep := range p.EquityPositions {
+		t.addRow(ep.Symbol, ep.OpenQty, price(ep.AveragePrice), price(ep.MktPrice), ep.MarketValue,
+			ep.UnrealizedDayPL, percent(ep.UnrealizedDayPLPercent), ep.UnrealizedPL, percent(ep.UnrealizedPLPercent))
+	}
+	return a.print(&t)
+}
+
+func runOrders(a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	c, err := a.login()
+	if err != nil {
+		return err
+	}
+	orders, err := c.GetOrders()
+	if err != nil {
+		return err
+	}
+	t := table{value: orders, headers: []string{"ID", "SIDE", "SYMBOL", "UNITS", "FILLED", "LIMIT", "STATUS", "PLACED", "EXPIRES"}}
+	for _, o := range *orders {
+		t.addRow(o.ID, o.Side, o.InstrumentCode, o.UnitsRequested, o.FilledUnits, price(o.LimitPrice), o.OrderStatus, o.PlacedTimestamp, o.ExpiresAt)
+	}
+	return a.print(&t)
+}
+
+// parseTradeArgs - parse SYMBOL UNITS @PRICE
+func parseTradeArgs(args []string) (string, int, float64, error) {
+	if len(args) != 3 {
+		return "", 0, 0, errUsage
+	}
+	symbol := strings.ToUpper(args[0])
+	units, err := strconv.Atoi(args[1])
+	if err != nil || units <= 0 {
+		return "", 0, 0, fmt.Errorf("invalid units '%s'", args[1])
+	}
+	p, err := strconv.ParseFloat(strings.TrimPrefix(args[2], "@"), 64)
+	if err != nil || p <= 0 {
+		return "", 0, 0, fmt.Errorf("invalid price '%s'", args[2])
+	}
+	return symbol, units, p, nil
+}
+
+// runTrade - place a buy or sell order after confirmation
+func runTrade(side string) func(a *app, args []string) error {
+	return func(a *app, args []string) error {
+		symbol, units, limit, err := parseTradeArgs(args)
+		if err != nil {
+			return err
+		}
+		c, err := a.login()
+		if err != nil {
+			return err
+		}
+
+		o := stakego.NewBuyOrder()
+		if side == stakego.OrderSELL {
+			o = stakego.NewSellOrder()
+		}
+		o.InstrumentCode = symbol
+		o.Units = units
+		o.Price = limit
+
+		value := float64(units) * limit
+		b, err := c.GetBrokerage(value)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s %d %s @ $%.3f = $%.2f, estimated brokerage $%.2f, valid until %s\n",
+			side, units, symbol, limit, value, b.BrokerageFee, o.ValidityDate)
+		err = a.confirm("Place this order?")
+		if err != nil {
+			return err
+		}
+
+		resp, err := c.PlaceOrder(*o)
+		if err != nil {
+			return err
+		}
+		od := resp.Order
+		t := table{value: resp, headers: []string{"ID", "SIDE", "SYMBOL", "UNITS", "LIMIT", "STATUS", "EST. BROKERAGE"}}
+		t.addRow(od.ID, od.Side, od.InstrumentCode, od.UnitsRequested, price(od.LimitPrice), od.OrderStatus, od.EstimatedBrokerage)
+		return a.print(&t)
+	}
+}
+
+func runCancel(a *app, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	c, err := a.login()
+	if err != nil {
+		return err
+	}
+	err = a.confirm(fmt.Sprintf("Cancel order %s?", args[0]))
+	if err != nil {
+		return err
+	}
+	err = c.CancelOrder(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Cancelled %s\n", args[0])
+	return nil
+}
+
+func runBrokerage(a *app, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	amount, err := strconv.ParseFloat(strings.TrimPrefix(args[0], "$"), 64)
+	if err != nil {
+		return fmt.Errorf("invalid amount '%s'", args[0])
+	}
+	c, err := a.login()
+	if err != nil {
+		return err
+	}
+	b, err := c.GetBrokerage(amount)
+	if err != nil {
+		return err
+	}
+	t := table{value: b, headers: []string{"ORDER VALUE", "BROKERAGE", "DISCOUNT", "FIXED FEE", "VARIABLE %", "VARIABLE LIMIT"}}
+	t.addRow(amount, b.BrokerageFee, b.BrokerageDiscount, b.FixedFee, percent(b.VariableFeePercentage), b.VariableLimit)
+	return a.print(&t)
+}
+
+func runSearch(a *app, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	c, err := a.login()
+	if err != nil {
+		return err
+	}
+	ir, err := c.SearchInstruments(strings.Join(args, " "), 20)
+	if err != nil {
+		return err
+	}
+	t := table{value: ir.Instruments, headers: []string{"SYMBOL", "NAME", "TYPE", "MARKET CAP", "SENSITIVE", "ANNOUNCEMENT"}}
+	for _, i := range ir.Instruments {
+		t.addRow(i.Symbol, i.Name, i.Type, i.MarketCap, i.Sensitive, i.RecentAnnouncement)
+	}
+	return a.print(&t)
+}
+
+func runMarket(a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	c, err := a.newClient()
+	if err != nil {
+		return err
+	}
+	m, err := c.GetMarket()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	s := m.NextSession(now)
+	if s != nil && !now.Before(s.CSPA) {
+		s = m.NextSession(s.Date.AddDate(0, 0, 1))
+	}
+	value := map[string]interface{}{"phase": m.PhaseAt(now), "session": s}
+	t := table{value: value, headers: []string{"PHASE", "SESSION", "OPEN", "CLOSE", "CSPA", "EARLY CLOSE"}}
+	if s == nil {
+		t.addRow(m.PhaseAt(now), "", "", "", "", "")
+	} else {
+		t.addRow(m.PhaseAt(now), s.Date.Format("Mon 2006-01-02"), s.Open.Format("15:04"), s.Close.Format("15:04"), s.CSPA.Format("15:04"), s.EarlyClose)
+	}
+	return a.print(&t)
+}
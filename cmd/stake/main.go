@@ -0,0 +1,203 @@
+// Command stake is a command line client for the Stake ASX trading platform.
+//
+// Usage:
+//
+//	stake [flags] <command> [arguments]
+//
+// Run "stake help" for the list of commands.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mdusher/stakego"
+)
+
+// command - a stake subcommand
+type command struct {
+	usage string
+	help  string
+	run   func(app *app, args []string) error
+}
+
+// commands - every subcommand, by name
+var commands = map[string]command{}
+
+// register - add a subcommand
+func register(name string, usage string, help string, run func(app *app, args []string) error) {
+	commands[name] = command{usage: usage, help: help, run: run}
+}
+
+// errUsage - returned by commands given the wrong arguments
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	app, rest, err := parseGlobalFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stake: %v\n", err)
+		os.Exit(2)
+	}
+	if len(rest) == 0 || rest[0] == "help" || rest[0] == "-h" || rest[0] == "--help" {
+		usage()
+		return
+	}
+
+	cmd, ok := commands[rest[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "stake: unknown command '%s'\n", rest[0])
+		usage()
+		os.Exit(2)
+	}
+	defer app.close()
+	err = cmd.run(app, rest[1:])
+	if errors.Is(err, errUsage) {
+		fmt.Fprintf(os.Stderr, "usage: stake %s %s\n", rest[0], cmd.usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stake: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// usage - print the list of commands
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: stake [flags] <command> [arguments]\n\nflags:\n")
+	fmt.Fprintf(os.Stderr, "  -o, --output FORMAT  table, json or csv (default table)\n")
+	fmt.Fprintf(os.Stderr, "  -p, --profile NAME   credentials profile (default \"default\")\n")
+	fmt.Fprintf(os.Stderr, "  -y, --yes            don't ask for confirmation before trading\n")
+	fmt.Fprintf(os.Stderr, "  -i, --interval DUR   refresh interval for watch (default 10s)\n")
+	fmt.Fprintf(os.Stderr, "\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		fmt.Fprintf(os.Stderr, "  %-36s %s\n", strings.TrimSpace(n+" "+commands[n].usage), commands[n].help)
+	}
+}
+
+// requestsPerSecond - limit on authenticated API requests made by the CLI
+const requestsPerSecond = 4
+
+// app - state shared by commands
+type app struct {
+	output   string
+	profile  string
+	yes      bool
+	interval time.Duration
+
+	client *stakego.ASXClient
+	stdin  *bufio.Reader // shared by every terminal prompt
+}
+
+// parseGlobalFlags - pull flags out of args, wherever they appear, leaving
+// the command and its positional arguments
+func parseGlobalFlags(args []string) (*app, []string, error) {
+	a := app{output: "table", profile: "default", interval: 10 * time.Second, stdin: bufio.NewReader(os.Stdin)}
+	rest := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			rest = append(rest, arg)
+			continue
+		}
+		if arg == "--" {
+			rest = append(rest, args[i+1:]...)
+			break
+		}
+		next := func() (string, error) {
+			if hasValue {
+				return value, nil
+			}
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("flag %s needs a value", arg)
+			}
+			i++
+			return args[i], nil
+		}
+		var err error
+		switch name {
+		case "o", "output":
+			a.output, err = next()
+		case "p", "profile":
+			a.profile, err = next()
+		case "y", "yes":
+			a.yes = true
+		case "i", "interval":
+			var v string
+			v, err = next()
+			if err == nil {
+				a.interval, err = time.ParseDuration(v)
+			}
+		case "h", "help":
+			rest = append([]string{"help"}, rest...)
+		default:
+			// leave it for the command, e.g. a negative number
+			rest = append(rest, arg)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	switch a.output {
+	case "table", "json", "csv":
+	default:
+		return nil, nil, fmt.Errorf("unknown output format '%s'", a.output)
+	}
+	return &a, rest, nil
+}
+
+// newClient - create a client for the profile, without logging in
+func (a *app) newClient() (*stakego.ASXClient, error) {
+	if a.client != nil {
+		return a.client, nil
+	}
+	creds, _, err := stakego.LoadCredentials(a.profile)
+	if err != nil {
+		return nil, err
+	}
+	prompt := stakego.NewPromptOTPProvider()
+	prompt.In = a.stdin
+	creds.OTPProvider = prompt
+	opts := []stakego.ClientOption{stakego.WithRateLimit(requestsPerSecond)}
+	if u := stakego.GetEnv("STAKE_API_URL", ""); u != "" {
+		opts = append(opts, stakego.WithBaseURL(u))
+	}
+	if u := stakego.GetEnv("STAKE_LOCATION_URL", ""); u != "" {
+		opts = append(opts, stakego.WithLocationURL(u))
+	}
+	c := stakego.NewASXClient(opts...)
+	c.Credentials = creds
+	c.TokenStore = stakego.NewFileTokenStore(stakego.DefaultTokenPath(a.profile))
+	a.client = c
+	return c, nil
+}
+
+// login - create a client and log in with the saved token or credentials
+func (a *app) login() (*stakego.ASXClient, error) {
+	c, err := a.newClient()
+	if err != nil {
+		return nil, err
+	}
+	err = c.Login()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// close - release the client
+func (a *app) close() {
+	if a.client != nil {
+		a.client.Close()
+	}
+}
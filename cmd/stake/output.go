@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// table - rows of output, along with the value to print as JSON
+type table struct {
+	value   interface{}
+	headers []string
+	rows    [][]string
+}
+
+// addRow - append a row, formatting each value
+func (t *table) addRow(values ...interface{}) {
+	row := make([]string, len(values))
+	for i, v := range values {
+		row[i] = formatValue(v)
+	}
+	t.rows = append(t.rows, row)
+}
+
+// formatValue - format a value for table and CSV output
+func formatValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', 2, 64)
+	case price:
+		return strconv.FormatFloat(float64(val), 'f', 3, 64)
+	case percent:
+		return strconv.FormatFloat(float64(val), 'f', 2, 64) + "%"
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// price - a float formatted with 3 decimal places
+type price float64
+
+// percent - a float formatted as a percentage
+type percent float64
+
+// print - write the table in the app's output format
+func (a *app) print(t *table) error {
+	return writeTable(os.Stdout, a.output, t)
+}
+
+// writeTable - write a table as table, json or csv
+func writeTable(w io.Writer, format string, t *table) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(t.value)
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write(t.headers)
+		_ = cw.WriteAll(t.rows)
+		return cw.Error()
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(t.headers, "\t"))
+	for _, r := range t.rows {
+		fmt.Fprintln(tw, strings.Join(r, "\t"))
+	}
+	return tw.Flush()
+}
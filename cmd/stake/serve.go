@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/mdusher/stakego"
+	"github.com/mdusher/stakego/gateway"
+)
+
+func init() {
+	register("serve", "[--listen ADDR] [--enable LIST] [--disable LIST]",
+		"serve a local HTTP API over one logged in session", runServe)
+}
+
+// defaultListenAddr - the gateway only listens on loopback unless told otherwise
+const defaultListenAddr = "127.0.0.1:8787"
+
+// runServe - log in once and serve the gateway until interrupted
+func runServe(a *app, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	listen := fs.String("listen", defaultListenAddr, "address to listen on")
+	enable := fs.String("enable", "", "comma separated endpoints to enable, e.g. place,cancel")
+	disable := fs.String("disable", "", "comma separated endpoints to disable")
+	tokenFile := fs.String("token-file", "", "file containing the bearer token")
+	tradingTokenFile := fs.String("trading-token-file", "", "file containing a separate bearer token for trading endpoints")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		return errUsage
+	}
+
+	cfg := gateway.Config{Enabled: map[string]bool{}}
+	for _, list := range []struct {
+		names string
+		on    bool
+	}{{*enable, true}, {*disable, false}} {
+		for _, name := range strings.Split(list.names, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if !isEndpoint(name) {
+				return fmt.Errorf("unknown endpoint '%s'", name)
+			}
+			cfg.Enabled[name] = list.on
+		}
+	}
+
+	var err error
+	cfg.Token, err = readToken("STAKE_GATEWAY_TOKEN", *tokenFile)
+	if err != nil {
+		return err
+	}
+	if cfg.Token == "" {
+		cfg.Token, err = randomToken()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "no STAKE_GATEWAY_TOKEN set, using generated token: %s\n", cfg.Token)
+	}
+	cfg.TradingToken, err = readToken("STAKE_GATEWAY_TRADING_TOKEN", *tradingTokenFile)
+	if err != nil {
+		return err
+	}
+
+	c, err := a.login()
+	if err != nil {
+		return err
+	}
+	g, err := gateway.New(c, cfg)
+	if err != nil {
+		return err
+	}
+
+	// nobody is at the terminal to answer an OTP prompt, so log in again
+	// without one; accounts with MFA need an OTP secret to be renewed
+	c.Credentials.OTPProvider = nil
+	opts := stakego.DefaultKeepaliveOptions
+	opts.Relogin = true
+	if c.Credentials.OTPSecret == "" {
+		log.Printf("no OTP secret set, the session can't be renewed if Stake asks for an OTP")
+	}
+	events := c.StartKeepalive(opts)
+	go func() {
+		for ev := range events {
+			if ev.Err != nil {
+				log.Printf("session %s: %v", ev.Type, ev.Err)
+			} else {
+				log.Printf("session %s", ev.Type)
+			}
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	srv := &http.Server{Addr: *listen, Handler: g, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+	}()
+
+	log.Printf("serving %s on http://%s", strings.Join(g.EnabledEndpoints(), ", "), *listen)
+	err = srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// isEndpoint - checks name is a gateway endpoint
+func isEndpoint(name string) bool {
+	for _, e := range append(append([]string{}, gateway.ReadEndpoints...), gateway.TradingEndpoints...) {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readToken - read a token from a file if given, otherwise the environment
+func readToken(env string, path string) (string, error) {
+	if path == "" {
+		return stakego.GetEnv(env, ""), nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// randomToken - a random 32 byte hex token
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
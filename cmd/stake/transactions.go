@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mdusher/stakego"
+)
+
+func init() {
+	register("transactions", "[--from DATE] [--to DATE] [--type KIND,...]",
+		"show cash transactions, 30 days by default", runTransactions)
+	register("reconcile", "[--from DATE | --all] [FILE]",
+		"rebuild the cash balance from transactions, fetched or from a JSON file, and compare it with the posted balance", runReconcile)
+}
+
+// parseDate - parse a YYYY-MM-DD date in local time, or return def if empty
+func parseDate(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return t, fmt.Errorf("invalid date '%s', expected YYYY-MM-DD", s)
+	}
+	return t, nil
+}
+
+func runTransactions(a *app, args []string) error {
+	fs := flag.NewFlagSet("transactions", flag.ContinueOnError)
+	fromFlag := fs.String("from", "", "first day, YYYY-MM-DD")
+	toFlag := fs.String("to", "", "last day, YYYY-MM-DD")
+	typeFlag := fs.String("type", "", "comma separated kinds, e.g. deposit,dividend")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 0 {
+		return errUsage
+	}
+	now := time.Now()
+	from, err := parseDate(*fromFlag, now.AddDate(0, 0, -30))
+	if err != nil {
+		return err
+	}
+	to, err := parseDate(*toFlag, now)
+	if err != nil {
+		return err
+	}
+	if *toFlag != "" {
+		to = to.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	kinds := []string{}
+	for _, k := range strings.Split(*typeFlag, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			kinds = append(kinds, strings.ToUpper(k))
+		}
+	}
+
+	c, err := a.login()
+	if err != nil {
+		return err
+	}
+	txs, err := c.GetTransactions(context.Background(), from, to, kinds...)
+	if err != nil {
+		return err
+	}
+	t := table{value: txs, headers: []string{"DATE", "KIND", "DESCRIPTION", "SYMBOL", "AMOUNT", "BALANCE"}}
+	for _, tx := range txs {
+		var balance interface{}
+		if tx.Balance != nil {
+			balance = *tx.Balance
+		}
+		t.addRow(tx.Time.Local().Format("2006-01-02 15:04"), tx.Kind(), tx.Description, tx.Symbol, tx.Amount, balance)
+	}
+	return a.print(&t)
+}
+
+func runReconcile(a *app, args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	fromFlag := fs.String("from", "", "first day, YYYY-MM-DD (default 90 days ago)")
+	all := fs.Bool("all", false, "the transactions are the account's whole history, starting from a zero balance")
+	if err := fs.Parse(args); err != nil || fs.NArg() > 1 || (*all && *fromFlag != "") {
+		return errUsage
+	}
+	from, err := parseDate(*fromFlag, time.Now().AddDate(0, 0, -90))
+	if err != nil {
+		return err
+	}
+	if *all {
+		from = time.Time{}
+	}
+
+	c, err := a.login()
+	if err != nil {
+		return err
+	}
+	var r *stakego.Reconciliation
+	if fs.NArg() == 1 {
+		b, err := os.ReadFile(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		txs, err := stakego.NewTransactionsFromJSON(b)
+		if err != nil {
+			return err
+		}
+		cash, err := c.GetCash()
+		if err != nil {
+			return err
+		}
+		if *all {
+			r = stakego.ReconcileFromOpening(txs, 0, cash)
+		} else {
+			r = stakego.ReconcileTransactions(txs, cash)
+		}
+	} else {
+		r, err = c.Reconcile(context.Background(), from)
+		if err != nil {
+			return err
+		}
+	}
+
+	t := table{value: r, headers: []string{"DATE", "KIND", "DESCRIPTION", "AMOUNT", "BALANCE", "REPORTED"}}
+	for _, e := range r.Entries {
+		var reported interface{}
+		if e.Transaction.Balance != nil {
+			reported = *e.Transaction.Balance
+		}
+		t.addRow(e.Transaction.Time.Local().Format("2006-01-02 15:04"), e.Transaction.Kind(), e.Transaction.Description, e.Transaction.Amount, e.Balance, reported)
+	}
+	err = a.print(&t)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "\nOpening %.2f, closing %.2f, posted %.2f, difference %.2f\n", r.Opening, r.Closing, r.Posted, r.Difference)
+	switch r.Status() {
+	case stakego.ReconcileUnverified:
+		return fmt.Errorf("can't verify the ledger: no transaction records a balance to start from, use --all if this is the whole history")
+	case stakego.ReconcileUnbalanced:
+		return fmt.Errorf("ledger does not reconcile: difference %.2f, %d recorded balance mismatches", r.Difference, len(r.Mismatches))
+	}
+	fmt.Fprintf(os.Stderr, "Balanced\n")
+	return nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/mdusher/stakego"
+)
+
+func init() {
+	register("watch", "", "full screen dashboard, refreshed every --interval", runWatch)
+}
+
+// ANSI escape sequences used by the dashboard
+const (
+	ansiClear   = "\x1b[H\x1b[2J"
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiReverse = "\x1b[7m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiYellow  = "\x1b[33m"
+	ansiDim     = "\x1b[2m"
+	ansiHide    = "\x1b[?25l"
+	ansiShow    = "\x1b[?25h"
+)
+
+// watchState - what the dashboard is showing
+type watchState struct {
+	prev      *stakego.Portfolio
+	cur       *stakego.Portfolio
+	phase     string
+	refreshed time.Time
+	selected  int
+	confirm   string // order ID awaiting cancel confirmation
+	status    string
+	err       error
+}
+
+// rawTerminal - put the terminal in raw mode, returning a function to restore it
+func rawTerminal() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	_, err = stty("raw", "-echo")
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		_, _ = stty(strings.TrimSpace(saved))
+	}, nil
+}
+
+// stty - run stty against the terminal on stdin
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// readKeys - send key presses from stdin, translating arrow keys to k/j
+func readKeys(keys chan<- byte) {
+	buf := make([]byte, 8)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		b := buf[:n]
+		switch {
+		case n >= 3 && b[0] == 0x1b && b[1] == '[' && b[2] == 'A':
+			keys <- 'k'
+		case n >= 3 && b[0] == 0x1b && b[1] == '[' && b[2] == 'B':
+			keys <- 'j'
+		default:
+			for _, k := range b {
+				keys <- k
+			}
+		}
+	}
+}
+
+func runWatch(a *app, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	c, err := a.login()
+	if err != nil {
+		return err
+	}
+
+	restore, err := rawTerminal()
+	if err != nil {
+		return fmt.Errorf("watch needs an interactive terminal: %v", err)
+	}
+	fmt.Print(ansiHide)
+	defer func() {
+		fmt.Print(ansiShow + ansiClear)
+		restore()
+	}()
+
+	keys := make(chan byte)
+	go readKeys(keys)
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	st := watchState{}
+	refresh := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), a.interval)
+		defer cancel()
+		p, err := c.GetPortfolio(ctx)
+		st.err = err
+		if err == nil {
+			st.prev = st.cur
+			st.cur = p
+			st.refreshed = p.AsOf
+		}
+		if m, err := c.GetMarket(); err == nil {
+			st.phase = m.GetPhase()
+		}
+		if st.cur != nil && st.selected >= len(st.cur.Orders) {
+			st.selected = len(st.cur.Orders) - 1
+		}
+		if st.selected < 0 {
+			st.selected = 0
+		}
+	}
+
+	refresh()
+	for {
+		fmt.Print(renderWatch(&st, a.interval))
+		select {
+		case <-ticker.C:
+			refresh()
+		case k, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			if st.confirm != "" {
+				id := st.confirm
+				st.confirm = ""
+				if k == 'y' || k == 'Y' {
+					err := c.CancelOrder(id)
+					if err != nil {
+						st.status = fmt.Sprintf("cancel failed: %v", err)
+					} else {
+						st.status = "cancelled " + id
+						refresh()
+					}
+				} else {
+					st.status = "cancel aborted"
+				}
+				continue
+			}
+			switch k {
+			case 'q', 3: // q or ctrl-c
+				return nil
+			case 'r':
+				st.status = ""
+				refresh()
+			case 'j':
+				if st.cur != nil && st.selected < len(st.cur.Orders)-1 {
+					st.selected++
+				}
+			case 'k':
+				if st.selected > 0 {
+					st.selected--
+				}
+			case 'c':
+				if st.cur != nil && st.selected < len(st.cur.Orders) {
+					st.confirm = st.cur.Orders[st.selected].ID
+				}
+			}
+		}
+	}
+}
+
+// changed - wrap s in a highlight if the value has changed since the last refresh
+func changed(s string, now float64, before float64, hadBefore bool) string {
+	if !hadBefore || now == before {
+		return s
+	}
+	if now > before {
+		return ansiBold + ansiGreen + s + ansiReset
+	}
+	return ansiBold + ansiRed + s + ansiReset
+}
+
+// signed - colour a P&L value by its sign
+func signed(s string, v float64) string {
+	switch {
+	case v > 0:
+		return ansiGreen + s + ansiReset
+	case v < 0:
+		return ansiRed + s + ansiReset
+	}
+	return s
+}
+
+// renderWatch - draw the dashboard
+func renderWatch(st *watchState, interval time.Duration) string {
+	var sb strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&sb, format+"\r\n", args...)
+	}
+	sb.WriteString(ansiClear)
+
+	phase := st.phase
+	if phase == stakego.MarketPhaseOpen {
+		phase = ansiGreen + phase + ansiReset
+	}
+	line("%sstake watch%s  market %s  refreshed %s (every %s)", ansiBold, ansiReset, phase, st.refreshed.Format("15:04:05"), interval)
+	if st.err != nil {
+		line("%serror: %v%s", ansiRed, st.err, ansiReset)
+	}
+	if st.cur == nil {
+		return sb.String()
+	}
+	p := st.cur
+	prev := st.prev
+	had := prev != nil
+	pv := func(f func(*stakego.Portfolio) float64) float64 {
+		if prev == nil {
+			return 0
+		}
+		return f(prev)
+	}
+
+	line("")
+	line("equity %s  cash %s  buying power %s  day P&L %s  P&L %s",
+		changed(fmt.Sprintf("$%.2f", p.TotalEquity), p.TotalEquity, pv(func(x *stakego.Portfolio) float64 { return x.TotalEquity }), had),
+		changed(fmt.Sprintf("$%.2f", p.CashBalance), p.CashBalance, pv(func(x *stakego.Portfolio) float64 { return x.CashBalance }), had),
+		changed(fmt.Sprintf("$%.2f", p.Cash.BuyingPower), p.Cash.BuyingPower, pv(func(x *stakego.Portfolio) float64 { return x.Cash.BuyingPower }), had),
+		signed(fmt.Sprintf("$%.2f", p.DayPL), p.DayPL),
+		signed(fmt.Sprintf("$%.2f", p.UnrealisedPL), p.UnrealisedPL))
+
+	line("")
+	line("%s%-8s %8s %10s %12s %10s %8s %8s%s", ansiBold, "SYMBOL", "UNITS", "PRICE", "VALUE", "DAY P&L", "DAY %", "WEIGHT", ansiReset)
+	for _, h := range p.Holdings {
+		var old stakego.Holding
+		found := false
+		if prev != nil {
+			if o, ok := prev.GetHolding(h.Symbol); ok {
+				old, found = *o, true
+			}
+		}
+		symbol := fmt.Sprintf("%-8s", h.Symbol)
+		if had && !found {
+			symbol = ansiYellow + symbol + ansiReset
+		}
+		line("%s %s %s %s %s %s %7.1f%%",
+			symbol,
+			changed(fmt.Sprintf("%8d", h.Units), float64(h.Units), float64(old.Units), found),
+			changed(fmt.Sprintf("%10.3f", h.Price), h.Price, old.Price, found),
+			changed(fmt.Sprintf("%12.2f", h.MarketValue), h.MarketValue, old.MarketValue, found),
+			signed(fmt.Sprintf("%10.2f", h.DayPL), h.DayPL),
+			signed(fmt.Sprintf("%7.2f%%", h.DayPLPercent), h.DayPLPercent),
+			h.Weight*100)
+	}
+
+	line("")
+	line("%s%-4s %-8s %8s %8s %10s %-12s %-36s%s", ansiBold, "SIDE", "SYMBOL", "UNITS", "FILLED", "LIMIT", "STATUS", "ID", ansiReset)
+	if len(p.Orders) == 0 {
+		line("%sno pending orders%s", ansiDim, ansiReset)
+	}
+	prevOrders := make(map[string]stakego.OrderDetails)
+	if prev != nil {
+		for _, o := range prev.Orders {
+			prevOrders[o.ID] = o
+		}
+	}
+	for i, o := range p.Orders {
+		row := fmt.Sprintf("%-4s %-8s %8d %8d %10.3f %-12s %-36s", o.Side, o.InstrumentCode, o.UnitsRequested, o.FilledUnits, o.LimitPrice, o.OrderStatus, o.ID)
+		old, existed := prevOrders[o.ID]
+		switch {
+		case i == st.selected:
+			row = ansiReverse + row + ansiReset
+		case had && !existed:
+			row = ansiYellow + row + ansiReset
+		case existed && (old.FilledUnits != o.FilledUnits || old.OrderStatus != o.OrderStatus):
+			row = ansiBold + row + ansiReset
+		}
+		line("%s", row)
+	}
+
+	line("")
+	switch {
+	case st.confirm != "":
+		line("%scancel order %s? (y/n)%s", ansiBold+ansiYellow, st.confirm, ansiReset)
+	case st.status != "":
+		line("%s", st.status)
+	}
+	line("%sj/k or arrows select order  c cancel order  r refresh  q quit%s", ansiDim, ansiReset)
+	return sb.String()
+}
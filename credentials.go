@@ -7,6 +7,9 @@ import (
   "github.com/pquerna/otp/totp"
 )
 
+// DefaultPlatformType - the platformType sent by Stake's web login page
+const DefaultPlatformType = "WEB_f5K2x3"
+
 // NewCredentials - creates a new Credentials and tries to populate
 // it from environment variables
 func NewCredentials() *Credentials {
@@ -15,12 +18,19 @@ func NewCredentials() *Credentials {
   return &r
 }
 
+// LoadCredentials - loads Credentials for a profile using the default
+// source chain, reporting which source supplied each field
+func LoadCredentials(profile string) (*Credentials, CredentialReport, error) {
+  return DefaultCredentialLoader(profile).Load()
+}
+
 // Credentials - holds info for creating a UserSession
 type Credentials struct {
   Username string `json:"username"`
   Password string `json:"password"`
   OTPSecret string `json:"-"`
   OTP string `json:"otp,omitempty"`
+  OTPProvider OTPProvider `json:"-"`
   RememberMeDays int `json:"rememberMeDays"`
   PlatformType string `json:"platformType"`
   StakeSessionToken string `json:"-"`
@@ -29,12 +39,18 @@ type Credentials struct {
 
 // FromEnv - retrieves login details from the environment
 func (r *Credentials) FromEnv() {
-  r.Username = GetEnv("STAKE_USERNAME", "")
-  r.Password = GetEnv("STAKE_PASSWORD", "")
-  r.OTPSecret = GetEnv("STAKE_OTP_SECRET", "")
-  r.StakeSessionToken = GetEnv("STAKE_SESSION_TOKEN", "")
-  r.RememberMeDays = GetEnvInt("STAKE_REMEMBER_ME_DAYS", 30)
-  r.PlatformType = GetEnv("STAKE_PLATFORM_TYPE", "WEB_f5K2x3") // note: find this in the login page
+  r.FromEnvPrefix("STAKE_")
+}
+
+// FromEnvPrefix - retrieves login details from environment variables
+// starting with prefix, e.g. STAKE_SMSF_ for STAKE_SMSF_USERNAME
+func (r *Credentials) FromEnvPrefix(prefix string) {
+  r.Username = GetEnv(prefix+"USERNAME", "")
+  r.Password = GetEnv(prefix+"PASSWORD", "")
+  r.OTPSecret = GetEnv(prefix+"OTP_SECRET", "")
+  r.StakeSessionToken = GetEnv(prefix+"SESSION_TOKEN", "")
+  r.RememberMeDays = GetEnvInt(prefix+"REMEMBER_ME_DAYS", 30)
+  r.PlatformType = GetEnv(prefix+"PLATFORM_TYPE", DefaultPlatformType)
 }
 
 // AsJSON - returns properties as JSON byte slice
@@ -0,0 +1,556 @@
+package stakego
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Credential fields that can be supplied by a CredentialSource
+const CredentialUsername = "USERNAME"
+const CredentialPassword = "PASSWORD"
+const CredentialOTPSecret = "OTP_SECRET"
+const CredentialSessionToken = "SESSION_TOKEN"
+const CredentialRememberMeDays = "REMEMBER_ME_DAYS"
+const CredentialPlatformType = "PLATFORM_TYPE"
+
+// CredentialFields - all fields, in the order they are loaded
+var CredentialFields = []string{
+	CredentialUsername,
+	CredentialPassword,
+	CredentialOTPSecret,
+	CredentialSessionToken,
+	CredentialRememberMeDays,
+	CredentialPlatformType,
+}
+
+// CredentialSourceDefault - reported for fields that fell back to a default value
+const CredentialSourceDefault = "default"
+
+// StakeHost - the host used to look up credentials in .netrc and credential helpers
+const StakeHost = "hellostake.com"
+
+// CredentialSource - somewhere credentials can be loaded from
+type CredentialSource interface {
+	// Name - describes the source, e.g. "env" or "file:/path/to/profile.yaml"
+	Name() string
+	// Lookup - returns the value of a field and whether the source has it
+	Lookup(field string) (string, bool, error)
+}
+
+// CredentialReport - maps each loaded field to the name of the source that supplied it
+type CredentialReport map[string]string
+
+// NewCredentialLoader - create a CredentialLoader from sources, in order of precedence
+func NewCredentialLoader(sources ...CredentialSource) *CredentialLoader {
+	l := CredentialLoader{}
+	l.Sources = sources
+	return &l
+}
+
+// DefaultCredentialLoader - create a CredentialLoader with the default source chain
+// for a profile. In order of precedence:
+//   - environment variables (STAKE_USERNAME, ...)
+//   - _FILE suffixed environment variables (STAKE_PASSWORD_FILE, ...)
+//   - the command in STAKE_CREDENTIAL_HELPER, if set
+//   - the profile in ~/.config/stakego/credentials.{yaml,yml,json,toml}
+//   - ~/.netrc, for the default profile only as it has no notion of profiles
+func DefaultCredentialLoader(profile string) *CredentialLoader {
+	prefix := "STAKE_"
+	if !isDefaultProfile(profile) {
+		prefix = fmt.Sprintf("STAKE_%s_", strings.ToUpper(profile))
+	}
+	sources := []CredentialSource{
+		EnvCredentialSource{Prefix: prefix},
+		EnvFileCredentialSource{Prefix: prefix},
+	}
+	helper := GetEnv(prefix+"CREDENTIAL_HELPER", "")
+	if helper != "" {
+		sources = append(sources, NewExecCredentialSource(profile, "sh", "-c", helper))
+	}
+	for _, ext := range []string{"yaml", "yml", "json", "toml"} {
+		p := filepath.Join(DefaultConfigDir(), "credentials."+ext)
+		if _, err := os.Stat(p); err == nil {
+			sources = append(sources, NewProfileFileCredentialSource(p, profile))
+			break
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err == nil && isDefaultProfile(profile) {
+		sources = append(sources, NewNetrcCredentialSource(filepath.Join(home, ".netrc"), StakeHost))
+	}
+	return NewCredentialLoader(sources...)
+}
+
+// isDefaultProfile - checks if profile names the default profile
+func isDefaultProfile(profile string) bool {
+	return profile == "" || profile == "default"
+}
+
+// CredentialLoader - loads Credentials from a chain of sources. The first
+// source that has a field supplies it.
+type CredentialLoader struct {
+	Sources []CredentialSource
+}
+
+// Load - build Credentials from the sources
+func (l *CredentialLoader) Load() (*Credentials, CredentialReport, error) {
+	r := Credentials{}
+	report := CredentialReport{}
+	values := map[string]string{}
+
+	for _, field := range CredentialFields {
+		for _, s := range l.Sources {
+			v, ok, err := s.Lookup(field)
+			if err != nil {
+				return nil, report, NewStakeError("credentials", fmt.Errorf("%s: %w", s.Name(), err))
+			}
+			if ok {
+				values[field] = v
+				report[field] = s.Name()
+				break
+			}
+		}
+	}
+
+	r.Username = values[CredentialUsername]
+	r.Password = values[CredentialPassword]
+	r.OTPSecret = values[CredentialOTPSecret]
+	r.StakeSessionToken = values[CredentialSessionToken]
+
+	r.RememberMeDays = 30
+	if v, ok := values[CredentialRememberMeDays]; ok {
+		days, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, report, NewStakeError("credentials", fmt.Errorf("%s: invalid %s '%s'", report[CredentialRememberMeDays], CredentialRememberMeDays, v))
+		}
+		r.RememberMeDays = days
+	} else {
+		report[CredentialRememberMeDays] = CredentialSourceDefault
+	}
+
+	r.PlatformType = DefaultPlatformType
+	if v, ok := values[CredentialPlatformType]; ok {
+		r.PlatformType = v
+	} else {
+		report[CredentialPlatformType] = CredentialSourceDefault
+	}
+
+	return &r, report, nil
+}
+
+// EnvCredentialSource - reads fields from environment variables, e.g. STAKE_USERNAME
+type EnvCredentialSource struct {
+	Prefix string
+}
+
+// Name - describes the source
+func (s EnvCredentialSource) Name() string {
+	return "env"
+}
+
+// Lookup - read <Prefix><field> from the environment
+func (s EnvCredentialSource) Lookup(field string) (string, bool, error) {
+	v, exists := os.LookupEnv(s.Prefix + field)
+	return v, exists, nil
+}
+
+// EnvFileCredentialSource - reads fields from files named by _FILE suffixed
+// environment variables, e.g. STAKE_PASSWORD_FILE=/run/secrets/stake_password
+type EnvFileCredentialSource struct {
+	Prefix string
+}
+
+// Name - describes the source
+func (s EnvFileCredentialSource) Name() string {
+	return "env-file"
+}
+
+// Lookup - read the file named by <Prefix><field>_FILE
+func (s EnvFileCredentialSource) Lookup(field string) (string, bool, error) {
+	path, exists := os.LookupEnv(s.Prefix + field + "_FILE")
+	if !exists || path == "" {
+		return "", false, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimRight(string(b), "\r\n"), true, nil
+}
+
+// NewProfileFileCredentialSource - create a source reading a named profile from a
+// YAML, JSON or TOML file. The format is picked from the file extension.
+func NewProfileFileCredentialSource(path string, profile string) *ProfileFileCredentialSource {
+	s := ProfileFileCredentialSource{}
+	s.Path = path
+	s.Profile = profile
+	if s.Profile == "" {
+		s.Profile = "default"
+	}
+	return &s
+}
+
+// ProfileFileCredentialSource - reads fields from a profile in a config file, e.g.
+//
+//	default:
+//	  username: me@example.com
+//	  remember_me_days: 30
+//	smsf:
+//	  username: smsf@example.com
+type ProfileFileCredentialSource struct {
+	Path    string
+	Profile string
+
+	once   sync.Once
+	fields map[string]string
+	err    error
+}
+
+// Name - describes the source
+func (s *ProfileFileCredentialSource) Name() string {
+	return fmt.Sprintf("file:%s[%s]", s.Path, s.Profile)
+}
+
+// Lookup - read the field from the profile
+func (s *ProfileFileCredentialSource) Lookup(field string) (string, bool, error) {
+	s.once.Do(s.load)
+	if s.err != nil {
+		return "", false, s.err
+	}
+	v, ok := s.fields[normaliseCredentialKey(field)]
+	return v, ok, nil
+}
+
+// load - parse the profile file
+func (s *ProfileFileCredentialSource) load() {
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	var profiles map[string]map[string]string
+	switch strings.ToLower(filepath.Ext(s.Path)) {
+	case ".json":
+		profiles, s.err = parseJSONProfiles(b)
+	case ".toml":
+		profiles, s.err = parseTOMLProfiles(b)
+	default:
+		profiles, s.err = parseYAMLProfiles(b)
+	}
+	if s.err != nil {
+		return
+	}
+	s.fields = profiles[s.Profile]
+}
+
+// normaliseCredentialKey - compare keys case insensitively and ignoring - and _
+func normaliseCredentialKey(k string) string {
+	k = strings.ToLower(strings.TrimSpace(k))
+	k = strings.ReplaceAll(k, "_", "")
+	return strings.ReplaceAll(k, "-", "")
+}
+
+// parseJSONProfiles - parse {"profile": {"key": value}}
+func parseJSONProfiles(b []byte) (map[string]map[string]string, error) {
+	var raw map[string]map[string]interface{}
+	err := json.Unmarshal(b, &raw)
+	if err != nil {
+		return nil, err
+	}
+	profiles := map[string]map[string]string{}
+	for name, fields := range raw {
+		profiles[name] = map[string]string{}
+		for k, v := range fields {
+			profiles[name][normaliseCredentialKey(k)] = fmt.Sprintf("%v", v)
+		}
+	}
+	return profiles, nil
+}
+
+// parseYAMLProfiles - parse the subset of YAML used by profile files: top
+// level profile names, each with indented "key: value" pairs. Flow syntax,
+// lists and nested maps aren't supported and return an error.
+func parseYAMLProfiles(b []byte) (map[string]map[string]string, error) {
+	profiles := map[string]map[string]string{}
+	var current map[string]string
+	indent := ""
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := stripComment(scanner.Text())
+		if strings.TrimSpace(line) == "" || strings.TrimSpace(line) == "---" {
+			continue
+		}
+		k, v, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected 'key: value'", n)
+		}
+		if err := checkScalar(v); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			if strings.TrimSpace(v) != "" {
+				return nil, fmt.Errorf("line %d: expected a profile name followed by indented fields", n)
+			}
+			current = map[string]string{}
+			profiles[strings.TrimSpace(k)] = current
+			indent = ""
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: field outside of a profile", n)
+		}
+		lineIndent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if indent == "" {
+			indent = lineIndent
+		}
+		if lineIndent != indent || strings.HasPrefix(strings.TrimSpace(k), "-") {
+			return nil, fmt.Errorf("line %d: nested maps and lists are not supported", n)
+		}
+		current[normaliseCredentialKey(k)] = unquote(v)
+	}
+	return profiles, scanner.Err()
+}
+
+// parseTOMLProfiles - parse the subset of TOML used by profile files:
+// [profile] tables with key = value pairs. Inline tables, arrays, dotted
+// keys and nested tables aren't supported and return an error.
+func parseTOMLProfiles(b []byte) (map[string]map[string]string, error) {
+	profiles := map[string]map[string]string{}
+	var current map[string]string
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := line[1 : len(line)-1]
+			if strings.HasPrefix(name, "[") || (strings.Contains(name, ".") && unquote(name) == strings.TrimSpace(name)) {
+				return nil, fmt.Errorf("line %d: nested tables are not supported", n)
+			}
+			current = map[string]string{}
+			profiles[unquote(name)] = current
+			continue
+		}
+		k, v, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected 'key = value'", n)
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: field outside of a profile", n)
+		}
+		if strings.Contains(k, ".") {
+			return nil, fmt.Errorf("line %d: dotted keys are not supported", n)
+		}
+		if err := checkScalar(v); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		current[normaliseCredentialKey(k)] = unquote(v)
+	}
+	return profiles, scanner.Err()
+}
+
+// checkScalar - returns an error if a value uses flow syntax, i.e. an
+// inline map or list, or a YAML block scalar
+func checkScalar(v string) error {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil
+	}
+	switch v[0] {
+	case '{', '[':
+		return fmt.Errorf("inline maps and lists are not supported")
+	case '|', '>', '&', '*', '!':
+		return fmt.Errorf("unsupported syntax '%c'", v[0])
+	}
+	return nil
+}
+
+// stripComment - remove a trailing # comment that isn't inside quotes. A #
+// only starts a comment at the start of the line or after whitespace.
+func stripComment(line string) string {
+	quote := rune(0)
+	prev := ' '
+	for i, ch := range line {
+		switch {
+		case quote != 0 && ch == quote:
+			quote = 0
+		case quote == 0 && (ch == '"' || ch == '\''):
+			quote = ch
+		case quote == 0 && ch == '#' && (prev == ' ' || prev == '\t'):
+			return strings.TrimRight(line[:i], " \t")
+		}
+		prev = ch
+	}
+	return strings.TrimRight(line, " \t\r")
+}
+
+// unquote - trim whitespace and surrounding quotes from a value
+func unquote(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		if u, err := strconv.Unquote(v); err == nil && v[0] == '"' {
+			return u
+		}
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// NewNetrcCredentialSource - create a source reading the login and password
+// for machine from a .netrc file
+func NewNetrcCredentialSource(path string, machine string) *NetrcCredentialSource {
+	s := NetrcCredentialSource{}
+	s.Path = path
+	s.Machine = machine
+	return &s
+}
+
+// NetrcCredentialSource - supplies USERNAME and PASSWORD from a .netrc file
+type NetrcCredentialSource struct {
+	Path    string
+	Machine string
+
+	once   sync.Once
+	fields map[string]string
+	err    error
+}
+
+// Name - describes the source
+func (s *NetrcCredentialSource) Name() string {
+	return "netrc:" + s.Path
+}
+
+// Lookup - returns the login or password for the machine
+func (s *NetrcCredentialSource) Lookup(field string) (string, bool, error) {
+	s.once.Do(s.load)
+	if s.err != nil {
+		return "", false, s.err
+	}
+	v, ok := s.fields[field]
+	return v, ok, nil
+}
+
+// load - parse the .netrc file
+func (s *NetrcCredentialSource) load() {
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	tokens := strings.Fields(string(b))
+	var machine string
+	found := map[string]map[string]string{}
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "machine":
+			if i+1 < len(tokens) {
+				i++
+				machine = tokens[i]
+				found[machine] = map[string]string{}
+			}
+		case "default":
+			machine = ""
+			found[machine] = map[string]string{}
+		case "login", "password":
+			if i+1 < len(tokens) && found[machine] != nil {
+				found[machine][tokens[i]] = tokens[i+1]
+				i++
+			}
+		}
+	}
+
+	m, ok := found[s.Machine]
+	if !ok {
+		m, ok = found[""]
+	}
+	if !ok {
+		return
+	}
+	s.fields = map[string]string{}
+	if v, ok := m["login"]; ok {
+		s.fields[CredentialUsername] = v
+	}
+	if v, ok := m["password"]; ok {
+		s.fields[CredentialPassword] = v
+	}
+}
+
+// NewExecCredentialSource - create a source that runs a credential helper
+// command, similar to git's credential helpers
+func NewExecCredentialSource(profile string, command string, args ...string) *ExecCredentialSource {
+	s := ExecCredentialSource{}
+	s.Profile = profile
+	s.Command = command
+	s.Args = args
+	return &s
+}
+
+// ExecCredentialSource - runs a command that prints key=value lines (e.g.
+// username=..., password=...) to stdout. The command is passed
+// "host=hellostake.com" and "profile=<name>" lines on stdin.
+type ExecCredentialSource struct {
+	Profile string
+	Command string
+	Args    []string
+
+	once   sync.Once
+	fields map[string]string
+	err    error
+}
+
+// Name - describes the source
+func (s *ExecCredentialSource) Name() string {
+	return "exec:" + s.Command
+}
+
+// Lookup - returns a field printed by the command
+func (s *ExecCredentialSource) Lookup(field string) (string, bool, error) {
+	s.once.Do(s.load)
+	if s.err != nil {
+		return "", false, s.err
+	}
+	v, ok := s.fields[normaliseCredentialKey(field)]
+	return v, ok, nil
+}
+
+// load - run the command and parse its output
+func (s *ExecCredentialSource) load() {
+	cmd := exec.Command(s.Command, s.Args...)
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("host=%s\nprofile=%s\n\n", StakeHost, s.Profile))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		s.err = err
+		return
+	}
+	s.fields = map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		k, v, found := strings.Cut(scanner.Text(), "=")
+		if found {
+			s.fields[normaliseCredentialKey(k)] = v
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package stakego_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mdusher/stakego"
+)
+
+// lookupAll - look up every field in want, failing on errors or differences
+func lookupAll(t *testing.T, s stakego.CredentialSource, want map[string]string) {
+	t.Helper()
+	for field, v := range want {
+		got, ok, err := s.Lookup(field)
+		if err != nil {
+			t.Fatalf("Lookup(%s): %v", field, err)
+		}
+		if v == "" {
+			if ok {
+				t.Errorf("Lookup(%s) = %q, want no value", field, got)
+			}
+			continue
+		}
+		if !ok || got != v {
+			t.Errorf("Lookup(%s) = %q, %v, want %q", field, got, ok, v)
+		}
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProfileFileCredentialSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		profile string
+		want    map[string]string
+		err     string
+	}{
+		{
+			name: "yaml",
+			file: "credentials.yaml",
+			content: `---
+# personal account
+default:
+  username: me@example.com   # trailing comment
+  password: "pa#ss word"
+  otp-secret: 'JBSWY3DP#EHPK3PXP'
+  remember_me_days: 30
+smsf:
+  username: smsf@example.com
+`,
+			want: map[string]string{
+				stakego.CredentialUsername:       "me@example.com",
+				stakego.CredentialPassword:       "pa#ss word",
+				stakego.CredentialOTPSecret:      "JBSWY3DP#EHPK3PXP",
+				stakego.CredentialRememberMeDays: "30",
+				stakego.CredentialSessionToken:   "",
+			},
+		},
+		{
+			name:    "yaml profile",
+			file:    "credentials.yml",
+			content: "default:\n  username: me@example.com\nsmsf:\n\tusername: smsf@example.com\n\tpassword: \"tab\\tpass\"\n",
+			profile: "smsf",
+			want: map[string]string{
+				stakego.CredentialUsername: "smsf@example.com",
+				stakego.CredentialPassword: "tab\tpass",
+			},
+		},
+		{
+			name:    "yaml hash without space is not a comment",
+			file:    "credentials.yaml",
+			content: "default:\n  password: pass#word\n",
+			want:    map[string]string{stakego.CredentialPassword: "pass#word"},
+		},
+		{
+			name:    "yaml missing profile",
+			file:    "credentials.yaml",
+			content: "default:\n  username: me@example.com\n",
+			profile: "trust",
+			want:    map[string]string{stakego.CredentialUsername: ""},
+		},
+		{
+			name:    "yaml flow map",
+			file:    "credentials.yaml",
+			content: "default: {username: me@example.com}\n",
+			err:     "line 1",
+		},
+		{
+			name:    "yaml nested map",
+			file:    "credentials.yaml",
+			content: "default:\n  username: me@example.com\n    password: secret\n",
+			err:     "nested",
+		},
+		{
+			name:    "yaml field outside profile",
+			file:    "credentials.yaml",
+			content: "  username: me@example.com\n",
+			err:     "outside",
+		},
+		{
+			name: "toml",
+			file: "credentials.toml",
+			content: `# credentials
+[default]
+username = "me@example.com" # trailing comment
+password = 'single # quoted'
+remember_me_days = 30
+
+["smsf"]
+username = "smsf@example.com"
+`,
+			want: map[string]string{
+				stakego.CredentialUsername:       "me@example.com",
+				stakego.CredentialPassword:       "single # quoted",
+				stakego.CredentialRememberMeDays: "30",
+			},
+		},
+		{
+			name:    "toml quoted profile",
+			file:    "credentials.toml",
+			content: "[\"smsf\"]\nusername = \"smsf@example.com\"\npassword = \"quote\\\"d\"\n",
+			profile: "smsf",
+			want: map[string]string{
+				stakego.CredentialUsername: "smsf@example.com",
+				stakego.CredentialPassword: `quote"d`,
+			},
+		},
+		{
+			name:    "toml nested table",
+			file:    "credentials.toml",
+			content: "[default.extra]\nusername = \"me@example.com\"\n",
+			err:     "nested tables",
+		},
+		{
+			name:    "toml dotted key",
+			file:    "credentials.toml",
+			content: "[default]\nsecret.otp = \"x\"\n",
+			err:     "dotted keys",
+		},
+		{
+			name:    "toml array",
+			file:    "credentials.toml",
+			content: "[default]\nusername = [\"a\", \"b\"]\n",
+			err:     "inline",
+		},
+		{
+			name:    "json",
+			file:    "credentials.json",
+			content: `{"default": {"username": "me@example.com", "remember_me_days": 30}}`,
+			want: map[string]string{
+				stakego.CredentialUsername:       "me@example.com",
+				stakego.CredentialRememberMeDays: "30",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := stakego.NewProfileFileCredentialSource(writeFile(t, tt.file, tt.content), tt.profile)
+			if tt.err != "" {
+				_, _, err := s.Lookup(stakego.CredentialUsername)
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("Lookup = %v, want an error containing %q", err, tt.err)
+				}
+				return
+			}
+			lookupAll(t, s, tt.want)
+		})
+	}
+}
+
+func TestProfileFileCredentialSourceMissingFile(t *testing.T) {
+	s := stakego.NewProfileFileCredentialSource(filepath.Join(t.TempDir(), "credentials.yaml"), "")
+	lookupAll(t, s, map[string]string{stakego.CredentialUsername: ""})
+}
+
+func TestNetrcCredentialSource(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+	}{
+		{
+			name:    "machine",
+			content: "machine example.com login other password x\nmachine hellostake.com\n  login me@example.com\n  password secret\n",
+			want:    map[string]string{stakego.CredentialUsername: "me@example.com", stakego.CredentialPassword: "secret"},
+		},
+		{
+			name:    "one line",
+			content: "machine hellostake.com login me@example.com password secret",
+			want:    map[string]string{stakego.CredentialUsername: "me@example.com", stakego.CredentialPassword: "secret"},
+		},
+		{
+			name:    "default",
+			content: "machine example.com login other password x\ndefault login anon@example.com password anon\n",
+			want:    map[string]string{stakego.CredentialUsername: "anon@example.com", stakego.CredentialPassword: "anon"},
+		},
+		{
+			name:    "machine before default",
+			content: "default login anon@example.com password anon\nmachine hellostake.com login me@example.com password secret\n",
+			want:    map[string]string{stakego.CredentialUsername: "me@example.com", stakego.CredentialPassword: "secret"},
+		},
+		{
+			name:    "login only",
+			content: "machine hellostake.com login me@example.com\n",
+			want:    map[string]string{stakego.CredentialUsername: "me@example.com", stakego.CredentialPassword: ""},
+		},
+		{
+			name:    "no match",
+			content: "machine example.com login other password x\n",
+			want:    map[string]string{stakego.CredentialUsername: "", stakego.CredentialPassword: ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := stakego.NewNetrcCredentialSource(writeFile(t, ".netrc", tt.content), stakego.StakeHost)
+			lookupAll(t, s, tt.want)
+		})
+	}
+}
+
+func TestExecCredentialSource(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+	// echoes the profile it was asked for back as the username
+	s := stakego.NewExecCredentialSource("smsf", "sh", "-c", `sed -n 's/^profile=\(.*\)/username=\1@example.com/p'; echo "password=pa=ss"; echo "OTP-Secret=JBSWY3DPEHPK3PXP"; echo ignored`)
+	lookupAll(t, s, map[string]string{
+		stakego.CredentialUsername:     "smsf@example.com",
+		stakego.CredentialPassword:     "pa=ss",
+		stakego.CredentialOTPSecret:    "JBSWY3DPEHPK3PXP",
+		stakego.CredentialSessionToken: "",
+	})
+
+	s = stakego.NewExecCredentialSource("", "sh", "-c", "echo 'helper failed' >&2; exit 1")
+	if _, _, err := s.Lookup(stakego.CredentialUsername); err == nil || !strings.Contains(err.Error(), "helper failed") {
+		t.Errorf("Lookup = %v, want the helper's stderr", err)
+	}
+}
+
+func TestDefaultConfigDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	if got, want := stakego.DefaultConfigDir(), filepath.Join(home, ".config", "stakego"); got != want {
+		t.Errorf("DefaultConfigDir = %s, want %s", got, want)
+	}
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "xdg"))
+	if got, want := stakego.DefaultConfigDir(), filepath.Join(home, "xdg", "stakego"); got != want {
+		t.Errorf("DefaultConfigDir = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,410 @@
+package stakego
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DCA schedule frequencies
+const DCAWeekly = "WEEKLY"
+const DCAFortnightly = "FORTNIGHTLY"
+const DCAMonthly = "MONTHLY"
+
+// DCA run statuses recorded in the journal
+const DCARunPending = "PENDING"
+const DCARunCompleted = "COMPLETED"
+const DCARunSkipped = "SKIPPED"
+const DCARunFailed = "FAILED"
+
+// PriceFunc - returns the current price of a symbol
+type PriceFunc func(symbol string) (float64, error)
+
+// DCAPlan - how much to invest, in what, and when
+type DCAPlan struct {
+	Amount      float64            // AUD to invest each run
+	Allocations map[string]float64 // fraction of Amount per symbol, equal split if values are zero
+	Frequency   string             // DCAWeekly, DCAFortnightly or DCAMonthly
+	Start       time.Time          // first scheduled date, later runs are relative to it
+	At          string             // session time to invest at, e.g. "open+15m"
+}
+
+// DCAOrder - an order placed during a DCA run
+type DCAOrder struct {
+	Symbol    string  `json:"symbol"`
+	Units     int     `json:"units"`
+	Price     float64 `json:"price"`
+	Brokerage float64 `json:"brokerage"`
+	OrderID   string  `json:"orderId,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// DCARun - a journal entry for one scheduled run
+type DCARun struct {
+	ID      string             `json:"id"` // scheduled date, YYYY-MM-DD
+	Time    time.Time          `json:"time"`
+	Status  string             `json:"status"`
+	Reason  string             `json:"reason,omitempty"`
+	Orders  []DCAOrder         `json:"orders,omitempty"`
+	CarryIn map[string]float64 `json:"carryIn,omitempty"`
+	Carry   map[string]float64 `json:"carry,omitempty"` // uninvested cash carried to the next run
+}
+
+// LoadDCAJournal - load a journal from path, or create an empty one if it doesn't exist
+func LoadDCAJournal(path string) (*DCAJournal, error) {
+	j := DCAJournal{}
+	j.Path = path
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &j, nil
+	}
+	if err != nil {
+		return nil, NewStakeError("dca journal", err)
+	}
+	err = json.Unmarshal(b, &j.Runs)
+	if err != nil {
+		return nil, NewStakeError("dca journal", err)
+	}
+	return &j, nil
+}
+
+// DCAJournal - persistent record of DCA runs
+type DCAJournal struct {
+	Path string
+	Runs []DCARun
+
+	mutex sync.Mutex
+}
+
+// Get - find a run by ID
+func (j *DCAJournal) Get(id string) (DCARun, bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	for _, r := range j.Runs {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return DCARun{}, false
+}
+
+// Carry - returns the cash carried forward from the latest finished run.
+// Failed runs count, their carry holds the budget of each symbol that
+// didn't buy as well as the leftovers of those that did.
+func (j *DCAJournal) Carry() map[string]float64 {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	for i := len(j.Runs) - 1; i >= 0; i-- {
+		r := j.Runs[i]
+		if r.Status == DCARunCompleted || r.Status == DCARunSkipped || r.Status == DCARunFailed {
+			carry := make(map[string]float64)
+			for s, v := range r.Carry {
+				carry[s] = v
+			}
+			return carry
+		}
+	}
+	return make(map[string]float64)
+}
+
+// Record - add or replace a run and save the journal
+func (j *DCAJournal) Record(run DCARun) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	replaced := false
+	for i := range j.Runs {
+		if j.Runs[i].ID == run.ID {
+			j.Runs[i] = run
+			replaced = true
+		}
+	}
+	if !replaced {
+		j.Runs = append(j.Runs, run)
+	}
+	b, err := json.MarshalIndent(j.Runs, "", "  ")
+	if err != nil {
+		return NewStakeError("dca journal", err)
+	}
+	err = WriteFileAtomic(j.Path, b, 0600)
+	if err != nil {
+		return NewStakeError("dca journal", err)
+	}
+	return nil
+}
+
+// NewDCAEngine - create a DCAEngine for a plan
+func NewDCAEngine(c Client, plan DCAPlan, prices PriceFunc, journal *DCAJournal) *DCAEngine {
+	e := DCAEngine{}
+	e.client = c
+	e.Plan = plan
+	e.Prices = prices
+	e.Journal = journal
+	e.Brokerage = brokerageFrom(c)
+	return &e
+}
+
+// DCAEngine - invests a fixed amount on a schedule, on trading days only
+type DCAEngine struct {
+	Plan      DCAPlan
+	Prices    PriceFunc
+	Journal   *DCAJournal
+	Brokerage BrokerageFunc
+	OnRun     func(run *DCARun, err error) // optional, called after each scheduled run attempt
+
+	client Client
+}
+
+// scheduledDate - the nth scheduled date of the plan
+func (e *DCAEngine) scheduledDate(n int) time.Time {
+	switch e.Plan.Frequency {
+	case DCAWeekly:
+		return e.Plan.Start.AddDate(0, 0, 7*n)
+	case DCAFortnightly:
+		return e.Plan.Start.AddDate(0, 0, 14*n)
+	}
+	return addMonthsClamped(e.Plan.Start, n)
+}
+
+// addMonthsClamped - t plus n months, on the same day of the month or the
+// last day of a shorter month, so a plan starting on the 31st runs on
+// 28/29 Feb rather than spilling into March
+func addMonthsClamped(t time.Time, n int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(n), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// DueRun - returns the ID of the run due on the trading day of t, if any. A
+// scheduled date that isn't a trading day moves to the next trading day.
+func (e *DCAEngine) DueRun(m *Market, t time.Time) (string, bool) {
+	loc, err := GetAULocation()
+	if err != nil {
+		return "", false
+	}
+	if e.Plan.Start.IsZero() {
+		return "", false
+	}
+	today := t.In(loc).Format(LocationDataDateFormat)
+	for n := 0; ; n++ {
+		d := e.scheduledDate(n).In(loc)
+		if d.Format(LocationDataDateFormat) > today {
+			return "", false
+		}
+		s := m.NextSession(d)
+		if s != nil && s.Date.Format(LocationDataDateFormat) == today {
+			return d.Format(LocationDataDateFormat), true
+		}
+	}
+}
+
+// allocations - the fraction of Amount for each symbol
+func (e *DCAEngine) allocations() map[string]float64 {
+	total := 0.0
+	for _, w := range e.Plan.Allocations {
+		total += w
+	}
+	a := make(map[string]float64)
+	for s, w := range e.Plan.Allocations {
+		if total == 0 {
+			a[s] = 1 / float64(len(e.Plan.Allocations))
+		} else {
+			a[s] = w / total
+		}
+	}
+	return a
+}
+
+// RunDue - invest if a run is due today and hasn't already been recorded,
+// or retry it if it failed
+func (e *DCAEngine) RunDue(ctx context.Context) (*DCARun, error) {
+	m, err := e.client.GetMarket()
+	if err != nil {
+		return nil, NewStakeError("dca", err)
+	}
+	id, due := e.DueRun(m, time.Now())
+	if !due {
+		return nil, nil
+	}
+	if r, exists := e.Journal.Get(id); exists {
+		if r.Status == DCARunPending {
+			return &r, NewStakeError("dca", fmt.Errorf("run %s was interrupted, check orders before retrying", id))
+		}
+		if r.Status != DCARunFailed {
+			return nil, nil
+		}
+	}
+	return e.RunOnce(ctx, id)
+}
+
+// RunOnce - invest the plan amount now, recording the run as id. If run id
+// failed, only the symbols that failed are retried, with the budget carried
+// by the failed run.
+//
+// A skipped run doesn't carry its Amount forward, only the carry it was
+// given; the next run invests the plan amount plus that carry.
+func (e *DCAEngine) RunOnce(ctx context.Context, id string) (*DCARun, error) {
+	alloc := e.allocations()
+	budgets := make(map[string]float64)
+
+	run := DCARun{ID: id, Time: time.Now()}
+	if prev, exists := e.Journal.Get(id); exists && prev.Status == DCARunFailed {
+		run.CarryIn = prev.CarryIn
+		for _, o := range prev.Orders {
+			if o.Error == "" {
+				run.Orders = append(run.Orders, o)
+			} else {
+				budgets[o.Symbol] = prev.Carry[o.Symbol]
+			}
+		}
+		run.Carry = make(map[string]float64)
+		for s, v := range prev.Carry {
+			run.Carry[s] = v
+		}
+	} else {
+		run.CarryIn = e.Journal.Carry()
+		run.Carry = run.CarryIn
+		for s := range alloc {
+			budgets[s] = e.Plan.Amount*alloc[s] + run.CarryIn[s]
+		}
+	}
+
+	required := 0.0
+	for _, b := range budgets {
+		required += b
+	}
+
+	cash, err := e.client.GetCash()
+	if err != nil {
+		return nil, NewStakeError("dca", err)
+	}
+	if cash.BuyingPower < required {
+		run.Status = DCARunSkipped
+		run.Reason = fmt.Sprintf("buying power $%.2f is less than $%.2f", cash.BuyingPower, required)
+		return &run, e.Journal.Record(run)
+	}
+
+	// record the run before placing orders, so a restart won't buy twice
+	run.Status = DCARunPending
+	err = e.Journal.Record(run)
+	if err != nil {
+		return nil, err
+	}
+
+	symbols := make([]string, 0, len(budgets))
+	for s := range budgets {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+
+	carry := make(map[string]float64)
+	for s, v := range run.Carry {
+		carry[s] = v
+	}
+	run.Carry = carry
+	failed := false
+	for _, s := range symbols {
+		budget := budgets[s]
+		run.Carry[s] = budget
+		if ctx.Err() != nil {
+			run.Orders = append(run.Orders, DCAOrder{Symbol: s, Error: ctx.Err().Error()})
+			failed = true
+			continue
+		}
+
+		dcaOrder, err := e.buy(s, budget)
+		if err != nil {
+			dcaOrder.Error = err.Error()
+			failed = true
+		} else {
+			run.Carry[s] = math.Round((budget-float64(dcaOrder.Units)*dcaOrder.Price-dcaOrder.Brokerage)*100) / 100
+		}
+		if dcaOrder.Units > 0 || dcaOrder.Error != "" {
+			run.Orders = append(run.Orders, dcaOrder)
+		}
+	}
+
+	run.Status = DCARunCompleted
+	if failed {
+		run.Status = DCARunFailed
+	}
+	err = e.Journal.Record(run)
+	if err != nil {
+		return &run, err
+	}
+	if failed {
+		return &run, NewStakeError("dca", fmt.Errorf("run %s had failed orders", id))
+	}
+	return &run, nil
+}
+
+// buy - place a buy order for as many whole units of symbol as budget allows
+func (e *DCAEngine) buy(symbol string, budget float64) (DCAOrder, error) {
+	d := DCAOrder{Symbol: symbol}
+	price, err := e.Prices(symbol)
+	if err != nil {
+		return d, err
+	}
+	d.Price = RoundToTick(price, OrderBUY)
+	units := int(math.Floor(budget / d.Price))
+	for units > 0 {
+		if e.Brokerage != nil {
+			d.Brokerage, err = e.Brokerage(float64(units) * d.Price)
+			if err != nil {
+				return d, err
+			}
+		}
+		if float64(units)*d.Price+d.Brokerage <= budget {
+			break
+		}
+		units = int(math.Floor((budget - d.Brokerage) / d.Price))
+	}
+	if units <= 0 {
+		d.Brokerage = 0
+		return d, nil
+	}
+	d.Units = units
+
+	o := NewBuyOrder()
+	o.InstrumentCode = symbol
+	o.Units = units
+	o.Price = d.Price
+	o.Validity = OrderValidityGoodForDay
+	o.ValidityDate = ""
+	resp, err := e.client.PlaceOrder(*o)
+	if err != nil {
+		return d, err
+	}
+	if resp != nil {
+		d.OrderID = resp.Order.ID
+	}
+	return d, nil
+}
+
+// Run - run the plan at its session time on due trading days until ctx is cancelled
+func (e *DCAEngine) Run(ctx context.Context) error {
+	at := e.Plan.At
+	if at == "" {
+		at = "open+15m"
+	}
+	s := NewSessionScheduler(e.client)
+	err := s.Add(at, func(ctx context.Context, session TradingSession) {
+		run, err := e.RunDue(ctx)
+		if e.OnRun != nil && (run != nil || err != nil) {
+			e.OnRun(run, err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return s.Run(ctx)
+}
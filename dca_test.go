@@ -0,0 +1,162 @@
+package stakego_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mdusher/stakego"
+	"github.com/mdusher/stakego/staketest"
+)
+
+// dcaClient - a mock client with buyingPower to spend, $3 brokerage and
+// orders for the symbols in fail rejected
+func dcaClient(buyingPower float64, fail map[string]bool) *staketest.MockClient {
+	m := flatBrokerage()
+	m.GetCashFunc = func() (*stakego.Cash, error) {
+		return &stakego.Cash{BuyingPower: buyingPower}, nil
+	}
+	m.PlaceOrderFunc = func(order stakego.Order) (*stakego.OrderResponse, error) {
+		if fail[order.InstrumentCode] {
+			return nil, errors.New("order rejected")
+		}
+		resp := &stakego.OrderResponse{}
+		resp.Order.ID = "order-" + order.InstrumentCode
+		return resp, nil
+	}
+	return m
+}
+
+func dcaPrices(symbol string) (float64, error) {
+	return map[string]float64{"BHP": 45, "VAS": 100}[symbol], nil
+}
+
+func newDCAEngine(t *testing.T, m *staketest.MockClient, plan stakego.DCAPlan) *stakego.DCAEngine {
+	t.Helper()
+	j, err := stakego.LoadDCAJournal(filepath.Join(t.TempDir(), "dca.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stakego.NewDCAEngine(m, plan, dcaPrices, j)
+}
+
+func TestDCACarryOver(t *testing.T) {
+	e := newDCAEngine(t, dcaClient(10000, nil), stakego.DCAPlan{Amount: 1000, Allocations: map[string]float64{"BHP": 1}})
+
+	// each run buys 22 BHP at $45 with $3 brokerage, carrying $7 more each time
+	for n, want := range []struct {
+		carryIn float64
+		units   int
+		carry   float64
+	}{
+		{0, 22, 7},
+		{7, 22, 14},
+		{14, 22, 21},
+	} {
+		run, err := e.RunOnce(context.Background(), time.Date(2024, 7, 1+n, 0, 0, 0, 0, time.UTC).Format("2006-01-02"))
+		if err != nil {
+			t.Fatalf("run %d: %v", n, err)
+		}
+		if run.Status != stakego.DCARunCompleted || run.CarryIn["BHP"] != want.carryIn || run.Carry["BHP"] != want.carry {
+			t.Errorf("run %d: status %s, carry in %.2f, carry %.2f, want %.2f, %.2f", n, run.Status, run.CarryIn["BHP"], run.Carry["BHP"], want.carryIn, want.carry)
+		}
+		if len(run.Orders) != 1 || run.Orders[0].Units != want.units || run.Orders[0].Brokerage != 3 {
+			t.Errorf("run %d: orders %+v, want %d units", n, run.Orders, want.units)
+		}
+	}
+}
+
+func TestDCASkippedKeepsCarry(t *testing.T) {
+	m := dcaClient(10000, nil)
+	e := newDCAEngine(t, m, stakego.DCAPlan{Amount: 1000, Allocations: map[string]float64{"BHP": 1}})
+	if _, err := e.RunOnce(context.Background(), "2024-07-01"); err != nil {
+		t.Fatal(err)
+	}
+
+	m.GetCashFunc = func() (*stakego.Cash, error) {
+		return &stakego.Cash{BuyingPower: 500}, nil
+	}
+	run, err := e.RunOnce(context.Background(), "2024-07-08")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != stakego.DCARunSkipped || run.Carry["BHP"] != 7 || len(run.Orders) != 0 {
+		t.Errorf("skipped run = %+v, want skipped carrying 7", run)
+	}
+	if got := e.Journal.Carry()["BHP"]; got != 7 {
+		t.Errorf("journal carry = %.2f, want 7", got)
+	}
+}
+
+func TestDCARetryFailedRun(t *testing.T) {
+	fail := map[string]bool{"VAS": true}
+	m := dcaClient(10000, fail)
+	e := newDCAEngine(t, m, stakego.DCAPlan{Amount: 2000, Allocations: map[string]float64{"BHP": 1, "VAS": 1}})
+
+	run, err := e.RunOnce(context.Background(), "2024-07-01")
+	if err == nil || run.Status != stakego.DCARunFailed {
+		t.Fatalf("first attempt = %+v, %v, want failed", run, err)
+	}
+	if run.Carry["VAS"] != 1000 || run.Carry["BHP"] != 7 {
+		t.Errorf("failed run carry = %v, want VAS 1000 and BHP 7", run.Carry)
+	}
+
+	fail["VAS"] = false
+	run, err = e.RunOnce(context.Background(), "2024-07-01")
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if run.Status != stakego.DCARunCompleted || len(run.Orders) != 2 {
+		t.Fatalf("retry = %+v, want completed with both orders", run)
+	}
+	for _, o := range run.Orders {
+		if o.Error != "" {
+			t.Errorf("order %+v still has an error", o)
+		}
+	}
+	// VAS retries with its own $1000, 9 units at $100 and $3 brokerage
+	if run.Carry["VAS"] != 97 || run.Carry["BHP"] != 7 {
+		t.Errorf("retry carry = %v, want VAS 97 and BHP 7", run.Carry)
+	}
+	// BHP was only bought by the first attempt
+	if got := m.Calls("PlaceOrder"); got != 3 {
+		t.Errorf("PlaceOrder called %d times, want 3", got)
+	}
+	if len(e.Journal.Runs) != 1 {
+		t.Errorf("journal has %d runs, want the retry to replace the failed one", len(e.Journal.Runs))
+	}
+}
+
+func TestDCADueRunClampsMonthEnd(t *testing.T) {
+	loc, err := stakego.GetAULocation()
+	if err != nil {
+		t.Skip(err)
+	}
+	e := newDCAEngine(t, dcaClient(0, nil), stakego.DCAPlan{
+		Amount:    1000,
+		Frequency: stakego.DCAMonthly,
+		Start:     time.Date(2024, 1, 31, 0, 0, 0, 0, loc),
+	})
+	m := &stakego.Market{}
+	tests := []struct {
+		day  string
+		want string
+	}{
+		{"2024-01-31", "2024-01-31"},
+		{"2024-02-29", "2024-02-29"},
+		{"2024-03-01", ""},
+		{"2024-04-01", "2024-03-31"}, // a Sunday, moved to the next trading day
+		{"2024-04-30", "2024-04-30"},
+		{"2024-05-01", ""},
+		{"2024-05-31", "2024-05-31"},
+	}
+	for _, tt := range tests {
+		day, _ := time.ParseInLocation("2006-01-02", tt.day, loc)
+		id, due := e.DueRun(m, day.Add(12*time.Hour))
+		if id != tt.want || due != (tt.want != "") {
+			t.Errorf("DueRun(%s) = %q, %v, want %q", tt.day, id, due, tt.want)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Package gateway exposes a stakego client over a local HTTP JSON API, so
+// that tools written in other languages can share one Stake session.
+package gateway
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mdusher/stakego"
+)
+
+// Endpoint names, used to enable and disable endpoints
+const EndpointCash = "cash"
+const EndpointPositions = "positions"
+const EndpointOrders = "orders"
+const EndpointMarket = "market"
+const EndpointPortfolio = "portfolio"
+const EndpointBrokerage = "brokerage"
+const EndpointMetrics = "metrics"
+const EndpointPlaceOrder = "place"
+const EndpointCancelOrder = "cancel"
+
+// ReadEndpoints - endpoints enabled by default
+var ReadEndpoints = []string{EndpointCash, EndpointPositions, EndpointOrders, EndpointMarket, EndpointPortfolio, EndpointBrokerage, EndpointMetrics}
+
+// TradingEndpoints - endpoints that change the account, disabled by default
+var TradingEndpoints = []string{EndpointPlaceOrder, EndpointCancelOrder}
+
+// Config - configures the gateway
+type Config struct {
+	// Token - bearer token required on every API request
+	Token string
+	// TradingToken - if set, trading endpoints require this token instead of Token
+	TradingToken string
+	// Enabled - endpoint name -> enabled. Endpoints not listed use their
+	// default: read endpoints on, trading endpoints off.
+	Enabled map[string]bool
+}
+
+// New - create a gateway for client
+func New(client stakego.Client, cfg Config) (*Gateway, error) {
+	if cfg.Token == "" {
+		return nil, errors.New("gateway: a bearer token is required")
+	}
+	g := Gateway{}
+	g.client = client
+	g.cfg = cfg
+	g.mux = http.NewServeMux()
+
+	g.handle("GET /v1/cash", EndpointCash, g.getCash)
+	g.handle("GET /v1/positions", EndpointPositions, g.getPositions)
+	g.handle("GET /v1/orders", EndpointOrders, g.getOrders)
+	g.handle("GET /v1/market", EndpointMarket, g.getMarket)
+	g.handle("GET /v1/portfolio", EndpointPortfolio, g.getPortfolio)
+	g.handle("GET /v1/brokerage", EndpointBrokerage, g.getBrokerage)
+	g.handle("GET /metrics", EndpointMetrics, stakego.NewMetricsHandler(client).ServeHTTP)
+	g.handle("POST /v1/orders", EndpointPlaceOrder, g.placeOrder)
+	g.handle("POST /v1/orders/{id}/cancel", EndpointCancelOrder, g.cancelOrder)
+	g.mux.HandleFunc("GET /openapi.json", g.getOpenAPI)
+	g.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	return &g, nil
+}
+
+// Gateway - an http.Handler serving the API
+type Gateway struct {
+	client stakego.Client
+	cfg    Config
+	mux    *http.ServeMux
+}
+
+// ServeHTTP - serve an API request
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+// Handle - mount another handler on the gateway, e.g. a metrics exporter.
+// It is served without authentication.
+func (g *Gateway) Handle(pattern string, h http.Handler) {
+	g.mux.Handle(pattern, h)
+}
+
+// IsEnabled - checks if an endpoint is enabled
+func (g *Gateway) IsEnabled(endpoint string) bool {
+	if v, ok := g.cfg.Enabled[endpoint]; ok {
+		return v
+	}
+	for _, e := range TradingEndpoints {
+		if e == endpoint {
+			return false
+		}
+	}
+	return true
+}
+
+// isTrading - checks if an endpoint changes the account
+func isTrading(endpoint string) bool {
+	for _, e := range TradingEndpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// handle - register an endpoint behind the enabled check and bearer token auth
+func (g *Gateway) handle(pattern string, endpoint string, h http.HandlerFunc) {
+	g.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		if !g.IsEnabled(endpoint) {
+			writeError(w, http.StatusNotFound, fmt.Errorf("endpoint '%s' is disabled", endpoint))
+			return
+		}
+		token := g.cfg.Token
+		if isTrading(endpoint) && g.cfg.TradingToken != "" {
+			token = g.cfg.TradingToken
+		}
+		given, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="stakego"`)
+			writeError(w, http.StatusUnauthorized, errors.New("invalid or missing bearer token"))
+			return
+		}
+		h(w, r)
+	})
+}
+
+// writeJSON - write v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// errorResponse - the body of every error response
+type errorResponse struct {
+	Error string `json:"error"`
+	Rule  string `json:"rule,omitempty"`
+}
+
+// writeError - write an error response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// writeClientError - write an error from the Stake client, picking a status
+func writeClientError(w http.ResponseWriter, err error) {
+	if r, ok := stakego.IsRiskRejection(err); ok {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: r.Error(), Rule: r.Rule})
+		return
+	}
+	if errors.Is(err, stakego.ErrSessionTokenMissing) {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeError(w, http.StatusBadGateway, err)
+}
+
+func (g *Gateway) getCash(w http.ResponseWriter, r *http.Request) {
+	cash, err := g.client.GetCash()
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cash)
+}
+
+func (g *Gateway) getPositions(w http.ResponseWriter, r *http.Request) {
+	p, err := g.client.GetEquityPositions()
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (g *Gateway) getOrders(w http.ResponseWriter, r *http.Request) {
+	orders, err := g.client.GetOrders()
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, orders)
+}
+
+// marketResponse - the market phase and next session
+type marketResponse struct {
+	Status  string                  `json:"status"`
+	Phase   string                  `json:"phase"`
+	Session *stakego.TradingSession `json:"session"`
+}
+
+func (g *Gateway) getMarket(w http.ResponseWriter, r *http.Request) {
+	m, err := g.client.GetMarket()
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	now := time.Now()
+	s := m.NextSession(now)
+	if s != nil && !now.Before(s.CSPA) {
+		s = m.NextSession(s.Date.AddDate(0, 0, 1))
+	}
+	writeJSON(w, http.StatusOK, marketResponse{Status: m.GetStatus(), Phase: m.PhaseAt(now), Session: s})
+}
+
+func (g *Gateway) getPortfolio(w http.ResponseWriter, r *http.Request) {
+	p, err := stakego.GetPortfolio(r.Context(), g.client)
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (g *Gateway) getBrokerage(w http.ResponseWriter, r *http.Request) {
+	amount, err := strconv.ParseFloat(r.URL.Query().Get("amount"), 64)
+	if err != nil || amount <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("amount must be a positive number"))
+		return
+	}
+	b, err := g.client.GetBrokerage(amount)
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+// OrderRequest - the body of a place order request
+type OrderRequest struct {
+	Side         string  `json:"side"`
+	Symbol       string  `json:"symbol"`
+	Units        int     `json:"units"`
+	Price        float64 `json:"price"`
+	Validity     string  `json:"validity,omitempty"`
+	ValidityDate string  `json:"validityDate,omitempty"`
+}
+
+func (g *Gateway) placeOrder(w http.ResponseWriter, r *http.Request) {
+	var req OrderRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid order: %v", err))
+		return
+	}
+
+	var o *stakego.Order
+	switch strings.ToUpper(req.Side) {
+	case stakego.OrderBUY:
+		o = stakego.NewBuyOrder()
+	case stakego.OrderSELL:
+		o = stakego.NewSellOrder()
+	default:
+		writeError(w, http.StatusBadRequest, errors.New("side must be BUY or SELL"))
+		return
+	}
+	if req.Symbol == "" || req.Units <= 0 || req.Price <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("symbol, units and price are required"))
+		return
+	}
+	o.InstrumentCode = strings.ToUpper(req.Symbol)
+	o.Units = req.Units
+	o.Price = req.Price
+	if req.Validity != "" {
+		o.Validity = req.Validity
+		o.ValidityDate = req.ValidityDate
+	}
+
+	resp, err := g.client.PlaceOrder(*o)
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (g *Gateway) cancelOrder(w http.ResponseWriter, r *http.Request) {
+	err := g.client.CancelOrder(r.PathValue("id"))
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"cancelled": r.PathValue("id")})
+}
+
+// EnabledEndpoints - the names of the enabled endpoints, sorted
+func (g *Gateway) EnabledEndpoints() []string {
+	names := []string{}
+	for _, e := range append(append([]string{}, ReadEndpoints...), TradingEndpoints...) {
+		if g.IsEnabled(e) {
+			names = append(names, e)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,17 @@
+package gateway
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// OpenAPISpec - the OpenAPI 3 description of the gateway API
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
+
+// getOpenAPI - serve the spec, without authentication
+func (g *Gateway) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(OpenAPISpec)
+}
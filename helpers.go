@@ -2,6 +2,7 @@ package stakego
 
 import (
 	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 )
@@ -32,3 +33,34 @@ func DatesEqual(t1 time.Time, t2 time.Time) bool {
 	truncToDate := 24 * time.Hour
 	return (t1.Truncate(truncToDate) == t2.Truncate(truncToDate))
 }
+
+// WriteFileAtomic - write data to a temporary file and rename it into place
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	cerr := f.Close()
+	if err != nil {
+		return err
+	}
+	if cerr != nil {
+		return cerr
+	}
+	return os.Rename(tmp, path)
+}
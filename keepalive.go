@@ -0,0 +1,169 @@
+package stakego
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Session event types emitted by the keepalive
+const SessionEventValidated = "VALIDATED"
+const SessionEventExpiring = "EXPIRING"
+const SessionEventExpired = "EXPIRED"
+const SessionEventInvalid = "INVALID"
+const SessionEventRelogin = "RELOGIN"
+const SessionEventError = "ERROR"
+
+// SessionEvent - emitted by the keepalive goroutine
+type SessionEvent struct {
+	Type      string
+	Time      time.Time
+	ExpiresAt time.Time // zero if unknown
+	Err       error
+}
+
+// KeepaliveOptions - configures the session keepalive
+type KeepaliveOptions struct {
+	Interval   time.Duration // how often to validate the token
+	WarnBefore time.Duration // emit SessionEventExpiring this long before expiry
+	Relogin    bool          // log in again if the token is rejected or expires
+}
+
+// DefaultKeepaliveOptions - validate every 15 minutes and warn a day before expiry
+var DefaultKeepaliveOptions = KeepaliveOptions{
+	Interval:   15 * time.Minute,
+	WarnBefore: 24 * time.Hour,
+	Relogin:    false,
+}
+
+// sessionEventBuffer - events are dropped if the consumer falls this far behind
+const sessionEventBuffer = 16
+
+// SessionIssuedAt - when the current session was created, zero if unknown
+// (e.g. the token came from the environment or a TokenStore)
+func (c *ASXClient) SessionIssuedAt() time.Time {
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
+	return c.sessionIssuedAt
+}
+
+// SessionExpiresAt - when the current session is expected to expire, zero if unknown
+func (c *ASXClient) SessionExpiresAt() time.Time {
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
+	return c.sessionExpiresAt
+}
+
+// setSessionTimes - record when the session was issued and will expire
+func (c *ASXClient) setSessionTimes(issued time.Time, expires time.Time) {
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
+	c.sessionIssuedAt = issued
+	c.sessionExpiresAt = expires
+}
+
+// ReloginCount - how many times the keepalive has logged in again
+func (c *ASXClient) ReloginCount() int {
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
+	return c.reloginCount
+}
+
+// StartKeepalive - start a background goroutine that periodically validates
+// the session token and emits SessionEvents. Stop it with Close.
+func (c *ASXClient) StartKeepalive(opts KeepaliveOptions) <-chan SessionEvent {
+	c.Close()
+
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultKeepaliveOptions.Interval
+	}
+
+	events := make(chan SessionEvent, sessionEventBuffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	c.tokenMutex.Lock()
+	c.keepaliveCancel = cancel
+	c.keepaliveDone = done
+	c.tokenMutex.Unlock()
+
+	go func() {
+		defer close(done)
+		defer close(events)
+		warned := time.Time{}
+		for {
+			c.keepaliveCheck(opts, events, &warned)
+			if sleepCtx(ctx, opts.Interval) != nil {
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// keepaliveCheck - validate the token once, emitting events as needed
+func (c *ASXClient) keepaliveCheck(opts KeepaliveOptions, events chan SessionEvent, warned *time.Time) {
+	emit := func(t string, err error) {
+		select {
+		case events <- SessionEvent{Type: t, Time: time.Now(), ExpiresAt: c.SessionExpiresAt(), Err: err}:
+		default:
+		}
+	}
+	relogin := func() {
+		c.Credentials.SetSessionToken("")
+		err := c.Login()
+		if err != nil {
+			emit(SessionEventError, err)
+			return
+		}
+		c.tokenMutex.Lock()
+		c.reloginCount++
+		c.tokenMutex.Unlock()
+		emit(SessionEventRelogin, nil)
+	}
+
+	expires := c.SessionExpiresAt()
+	if !expires.IsZero() {
+		if time.Now().After(expires) {
+			emit(SessionEventExpired, nil)
+			if opts.Relogin {
+				relogin()
+			}
+			return
+		}
+		if opts.WarnBefore > 0 && time.Until(expires) < opts.WarnBefore && !warned.Equal(expires) {
+			*warned = expires
+			emit(SessionEventExpiring, nil)
+		}
+	}
+
+	_, err := c.GetUser()
+	if err == nil {
+		emit(SessionEventValidated, nil)
+		return
+	}
+	if errors.Is(err, ErrSessionTokenMissing) {
+		emit(SessionEventInvalid, err)
+		if opts.Relogin {
+			relogin()
+		}
+		return
+	}
+	emit(SessionEventError, err)
+}
+
+// Close - stop the keepalive goroutine, if running, and wait for it to exit
+func (c *ASXClient) Close() error {
+	c.tokenMutex.Lock()
+	cancel := c.keepaliveCancel
+	done := c.keepaliveDone
+	c.keepaliveCancel = nil
+	c.keepaliveDone = nil
+	c.tokenMutex.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+	return nil
+}
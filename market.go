@@ -85,6 +85,15 @@ func GetAUTime() (*time.Time, error) {
 	return &now, err
 }
 
+// auToday - the current date in Sydney, in LocationDataDateFormat
+func auToday() string {
+	now, err := GetAUTime()
+	if err != nil {
+		return time.Now().Format(LocationDataDateFormat)
+	}
+	return now.Format(LocationDataDateFormat)
+}
+
 // IsNormalHours - checks to see if it is currently within "normal" hours for the market
 func (m *Market) IsNormalHours() bool {
 	if m.Market == MarketAU {
@@ -0,0 +1,367 @@
+package stakego
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request error classes counted by RequestMetrics
+const RequestErrorNetwork = "network"
+const RequestErrorTimeout = "timeout"
+const RequestErrorAuth = "auth"
+const RequestErrorRateLimited = "rate_limited"
+const RequestErrorClient = "client"
+const RequestErrorServer = "server"
+
+// DefaultLatencyBuckets - histogram bucket upper bounds, in seconds
+var DefaultLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// NewRequestMetrics - create an empty set of request metrics
+func NewRequestMetrics() *RequestMetrics {
+	m := RequestMetrics{}
+	m.Buckets = DefaultLatencyBuckets
+	m.latency = map[requestKey]*latencyHistogram{}
+	m.errors = map[string]int{}
+	return &m
+}
+
+// RequestMetrics - latency and error counts for requests made by a client
+type RequestMetrics struct {
+	Buckets []float64
+
+	mutex   sync.Mutex
+	latency map[requestKey]*latencyHistogram
+	errors  map[string]int
+}
+
+// requestKey - the labels of a latency histogram
+type requestKey struct {
+	method   string
+	endpoint string
+}
+
+// latencyHistogram - cumulative latency counts for one endpoint
+type latencyHistogram struct {
+	counts []int // per bucket, not cumulative
+	count  int
+	sum    float64
+}
+
+// Observe - record a request to endpoint that took d. class is empty for
+// a successful request, otherwise one of the RequestError constants.
+func (m *RequestMetrics) Observe(method string, endpoint string, d time.Duration, class string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	k := requestKey{method: method, endpoint: endpoint}
+	h, ok := m.latency[k]
+	if !ok {
+		h = &latencyHistogram{counts: make([]int, len(m.Buckets))}
+		m.latency[k] = h
+	}
+	s := d.Seconds()
+	for i, b := range m.Buckets {
+		if s <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += s
+
+	if class != "" {
+		m.errors[class]++
+	}
+}
+
+// Errors - error counts by class
+func (m *RequestMetrics) Errors() map[string]int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	errs := map[string]int{}
+	for k, v := range m.errors {
+		errs[k] = v
+	}
+	return errs
+}
+
+// WriteMetrics - write the metrics in the Prometheus text exposition format
+func (m *RequestMetrics) WriteMetrics(w io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	keys := make([]requestKey, 0, len(m.latency))
+	for k := range m.latency {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].endpoint != keys[j].endpoint {
+			return keys[i].endpoint < keys[j].endpoint
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	writeMetricHeader(w, "stake_client_request_duration_seconds", "histogram", "Latency of requests to the Stake API.")
+	for _, k := range keys {
+		h := m.latency[k]
+		labels := fmt.Sprintf(`method="%s",endpoint="%s"`, escapeLabel(k.method), escapeLabel(k.endpoint))
+		cumulative := 0
+		for i, b := range m.Buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "stake_client_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatMetric(b), cumulative)
+		}
+		fmt.Fprintf(w, "stake_client_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "stake_client_request_duration_seconds_sum{%s} %s\n", labels, formatMetric(h.sum))
+		fmt.Fprintf(w, "stake_client_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	writeMetricHeader(w, "stake_client_request_errors_total", "counter", "Failed requests to the Stake API, by class.")
+	for _, class := range []string{RequestErrorNetwork, RequestErrorTimeout, RequestErrorAuth, RequestErrorRateLimited, RequestErrorClient, RequestErrorServer} {
+		fmt.Fprintf(w, "stake_client_request_errors_total{class=\"%s\"} %d\n", class, m.errors[class])
+	}
+}
+
+// Metrics - the request metrics recorded by the client
+func (c *ASXClient) Metrics() *RequestMetrics {
+	return c.metrics
+}
+
+// do - send a request, recording its latency and outcome
+func (c *ASXClient) do(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := c.httpclient.Do(req)
+	if c.metrics != nil {
+		c.metrics.Observe(req.Method, endpointLabel(c.apiUrl, req.URL.Path), time.Since(start), classifyRequest(resp, err))
+	}
+	return resp, err
+}
+
+// classifyRequest - the error class of a request, or "" if it succeeded
+func classifyRequest(resp *http.Response, err error) string {
+	if err != nil {
+		var ne net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+			return RequestErrorTimeout
+		}
+		return RequestErrorNetwork
+	}
+	switch {
+	case resp.StatusCode == 401 || resp.StatusCode == 403:
+		return RequestErrorAuth
+	case resp.StatusCode == 429:
+		return RequestErrorRateLimited
+	case resp.StatusCode >= 500:
+		return RequestErrorServer
+	case resp.StatusCode >= 400:
+		return RequestErrorClient
+	}
+	return ""
+}
+
+// endpointLabel - the API path of a request, with IDs and tokens replaced
+// so that the label has a small, fixed set of values
+func endpointLabel(apiURL string, path string) string {
+	if u, err := url.Parse(apiURL); err == nil {
+		path = strings.TrimPrefix(path, u.Path)
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, p := range parts {
+		if len(p) >= 16 || (i > 0 && parts[i-1] == "userauth") {
+			parts[i] = "{id}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// writeMetricHeader - write the HELP and TYPE lines of a metric
+func writeMetricHeader(w io.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// formatMetric - format a sample value
+func formatMetric(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeLabel - escape a label value for the text exposition format
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// NewMetricsHandler - create a handler serving portfolio, market and client
+// health metrics for Prometheus. The account is fetched when scraped, at
+// most once every CacheFor.
+func NewMetricsHandler(c Client) *MetricsHandler {
+	h := MetricsHandler{}
+	h.client = c
+	h.CacheFor = 10 * time.Second
+	h.Timeout = 20 * time.Second
+	return &h
+}
+
+// MetricsHandler - an http.Handler serving metrics in the Prometheus text format
+type MetricsHandler struct {
+	CacheFor time.Duration
+	Timeout  time.Duration
+
+	client    Client
+	mutex     sync.Mutex
+	portfolio *Portfolio
+	market    *Market
+	fetchedAt time.Time
+	fetchErr  error
+}
+
+// ServeHTTP - write the current metrics
+func (h *MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	h.WriteMetrics(r.Context(), w)
+}
+
+// refresh - fetch the portfolio and market if the cached copy is too old
+func (h *MetricsHandler) refresh(ctx context.Context) {
+	if !h.fetchedAt.IsZero() && time.Since(h.fetchedAt) < h.CacheFor {
+		return
+	}
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+	h.fetchedAt = time.Now()
+	h.fetchErr = nil
+	p, err := GetPortfolio(ctx, h.client)
+	if err != nil {
+		h.fetchErr = err
+	} else {
+		h.portfolio = p
+	}
+	m, err := h.client.GetMarket()
+	if err != nil {
+		h.fetchErr = err
+	} else {
+		h.market = m
+	}
+}
+
+// WriteMetrics - write the metrics in the Prometheus text exposition format
+func (h *MetricsHandler) WriteMetrics(ctx context.Context, w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.refresh(ctx)
+
+	writeMetricHeader(w, "stake_scrape_success", "gauge", "Whether the last fetch of the account succeeded.")
+	if h.fetchErr != nil {
+		fmt.Fprintf(w, "stake_scrape_success 0\n")
+	} else {
+		fmt.Fprintf(w, "stake_scrape_success 1\n")
+	}
+
+	if p := h.portfolio; p != nil {
+		writeMetricHeader(w, "stake_portfolio_updated_timestamp_seconds", "gauge", "When the portfolio was last fetched.")
+		fmt.Fprintf(w, "stake_portfolio_updated_timestamp_seconds %d\n", p.AsOf.Unix())
+
+		perSymbol := []struct {
+			name  string
+			help  string
+			value func(Holding) float64
+		}{
+			{"stake_position_units", "Units held.", func(x Holding) float64 { return float64(x.Units) }},
+			{"stake_position_market_value", "Market value of the position.", func(x Holding) float64 { return x.MarketValue }},
+			{"stake_position_unrealised_pl", "Unrealised profit or loss of the position.", func(x Holding) float64 { return x.UnrealisedPL }},
+			{"stake_position_day_pl", "Profit or loss of the position today.", func(x Holding) float64 { return x.DayPL }},
+			{"stake_position_weight", "Fraction of total equity in the position.", func(x Holding) float64 { return x.Weight }},
+		}
+		for _, m := range perSymbol {
+			writeMetricHeader(w, m.name, "gauge", m.help)
+			for _, x := range p.Holdings {
+				fmt.Fprintf(w, "%s{symbol=\"%s\"} %s\n", m.name, escapeLabel(x.Symbol), formatMetric(m.value(x)))
+			}
+		}
+
+		totals := []struct {
+			name  string
+			help  string
+			value float64
+		}{
+			{"stake_portfolio_market_value", "Total market value of positions.", p.MarketValue},
+			{"stake_portfolio_total_equity", "Market value plus cash balance.", p.TotalEquity},
+			{"stake_portfolio_unrealised_pl", "Total unrealised profit or loss.", p.UnrealisedPL},
+			{"stake_portfolio_day_pl", "Total profit or loss today.", p.DayPL},
+			{"stake_pending_buy_cash", "Cash committed to pending buy orders.", p.PendingBuyCash},
+		}
+		for _, t := range totals {
+			writeMetricHeader(w, t.name, "gauge", t.help)
+			fmt.Fprintf(w, "%s %s\n", t.name, formatMetric(t.value))
+		}
+
+		cash := []struct {
+			kind  string
+			value float64
+		}{
+			{"settled", p.Cash.SettledCash},
+			{"posted", p.Cash.PostedBalance},
+			{"trade_settlement", p.Cash.TradeSettlement},
+			{"buying_power", p.Cash.BuyingPower},
+			{"pending_buys", p.Cash.PendingBuys},
+			{"pending_withdrawals", p.Cash.PendingWithdrawals},
+			{"settlement_hold", p.Cash.SettlementHold},
+			{"available_for_withdrawal", p.Cash.CashAvailableForWithdrawal},
+			{"available_for_transfer", p.Cash.CashAvailableForTransfer},
+			{"clearing", p.Cash.ClearingCash},
+		}
+		writeMetricHeader(w, "stake_cash", "gauge", "Cash balances, by type.")
+		for _, x := range cash {
+			fmt.Fprintf(w, "stake_cash{type=\"%s\"} %s\n", x.kind, formatMetric(x.value))
+		}
+
+		pending := map[string]int{OrderBUY: 0, OrderSELL: 0}
+		for _, o := range p.Orders {
+			pending[o.Side]++
+		}
+		writeMetricHeader(w, "stake_pending_orders", "gauge", "Pending orders, by side.")
+		for _, side := range []string{OrderBUY, OrderSELL} {
+			fmt.Fprintf(w, "stake_pending_orders{side=\"%s\"} %d\n", strings.ToLower(side), pending[side])
+		}
+	}
+
+	if m := h.market; m != nil {
+		phase := m.PhaseAt(time.Now())
+		open := 0
+		if phase == MarketPhaseOpen {
+			open = 1
+		}
+		writeMetricHeader(w, "stake_market_open", "gauge", "Whether the ASX is open for continuous trading.")
+		fmt.Fprintf(w, "stake_market_open %d\n", open)
+		writeMetricHeader(w, "stake_market_phase", "gauge", "The current market phase.")
+		for _, ph := range []string{MarketPhasePreOpen, MarketPhaseOpen, MarketPhaseCSPA, MarketPhaseClosed} {
+			v := 0
+			if ph == phase {
+				v = 1
+			}
+			fmt.Fprintf(w, "stake_market_phase{phase=\"%s\"} %d\n", strings.ToLower(ph), v)
+		}
+	}
+
+	if c, ok := h.client.(*ASXClient); ok {
+		writeMetricHeader(w, "stake_client_relogins_total", "counter", "Times the keepalive has logged in again.")
+		fmt.Fprintf(w, "stake_client_relogins_total %d\n", c.ReloginCount())
+		if exp := c.SessionExpiresAt(); !exp.IsZero() {
+			writeMetricHeader(w, "stake_session_expiry_timestamp_seconds", "gauge", "When the session token expires.")
+			fmt.Fprintf(w, "stake_session_expiry_timestamp_seconds %d\n", exp.Unix())
+		}
+		if c.metrics != nil {
+			c.metrics.WriteMetrics(w)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package stakego
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers sent with each webhook
+const WebhookSignatureHeader = "X-Stake-Signature"
+const WebhookTimestampHeader = "X-Stake-Timestamp"
+const WebhookEventHeader = "X-Stake-Event"
+const WebhookDeliveryHeader = "X-Stake-Delivery"
+
+// SignWebhook - the signature of a webhook body: "sha256=" followed by the
+// hex HMAC-SHA256 of "<timestamp>.<body>" keyed with secret
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook - checks a webhook's signature, for receivers
+func VerifyWebhook(secret string, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhook(secret, timestamp, body)), []byte(signature))
+}
+
+// postJSON - post body, treating 4xx responses other than 408 and 429 as
+// permanent failures
+func postJSON(ctx context.Context, hc http.Client, url string, body []byte, header http.Header) error {
+	req, err := NewJSONRequest("POST", url, body)
+	if err != nil {
+		return &permanentError{err}
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != 408 && resp.StatusCode != 429 {
+		return &permanentError{err}
+	}
+	return err
+}
+
+// NewWebhookNotifier - create a notifier posting each notification as JSON
+// to url. If secret is set, the body is signed; see SignWebhook.
+func NewWebhookNotifier(url string, secret string) *WebhookNotifier {
+	w := WebhookNotifier{}
+	w.URL = url
+	w.Secret = secret
+	w.httpclient = NewHTTPClient()
+	return &w
+}
+
+// WebhookNotifier - posts notifications as JSON to a URL
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+	Header http.Header // extra headers, e.g. Authorization
+
+	httpclient http.Client
+}
+
+// SetHTTPClient - use hc for webhook requests
+func (w *WebhookNotifier) SetHTTPClient(hc http.Client) {
+	w.httpclient = hc
+}
+
+// Notify - post the notification
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return &permanentError{err}
+	}
+	header := http.Header{}
+	for k, v := range w.Header {
+		header[k] = v
+	}
+	header.Set(WebhookEventHeader, n.Type)
+	header.Set(WebhookDeliveryHeader, n.ID)
+	if w.Secret != "" {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		header.Set(WebhookTimestampHeader, ts)
+		header.Set(WebhookSignatureHeader, SignWebhook(w.Secret, ts, body))
+	}
+	return postJSON(ctx, w.httpclient, w.URL, body, header)
+}
+
+// NewSlackNotifier - create a notifier posting to a Slack compatible
+// incoming webhook URL
+func NewSlackNotifier(url string) *SlackNotifier {
+	s := SlackNotifier{}
+	s.URL = url
+	s.httpclient = NewHTTPClient()
+	return &s
+}
+
+// SlackNotifier - posts notifications to a Slack compatible incoming webhook
+type SlackNotifier struct {
+	URL string
+
+	httpclient http.Client
+}
+
+// SetHTTPClient - use hc for webhook requests
+func (s *SlackNotifier) SetHTTPClient(hc http.Client) {
+	s.httpclient = hc
+}
+
+// slackMessage - an incoming webhook payload
+type slackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks,omitempty"`
+}
+
+// slackBlock - a section block with markdown text
+type slackBlock struct {
+	Type string    `json:"type"`
+	Text slackText `json:"text"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Notify - post the notification
+func (s *SlackNotifier) Notify(ctx context.Context, n Notification) error {
+	msg := slackMessage{
+		Text: n.Title,
+		Blocks: []slackBlock{
+			{Type: "section", Text: slackText{Type: "mrkdwn", Text: fmt.Sprintf("*%s*\n%s", n.Title, n.Text)}},
+		},
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return &permanentError{err}
+	}
+	return postJSON(ctx, s.httpclient, s.URL, body, nil)
+}
+
+// NewEmailNotifier - create a notifier sending email through the SMTP
+// server at addr ("host:port"). If username is set, PLAIN auth is used.
+func NewEmailNotifier(addr string, username string, password string, from string, to ...string) *EmailNotifier {
+	e := EmailNotifier{}
+	e.Addr = addr
+	e.From = from
+	e.To = to
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		e.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &e
+}
+
+// EmailNotifier - sends notifications as plain text email
+type EmailNotifier struct {
+	Addr          string
+	Auth          smtp.Auth
+	From          string
+	To            []string
+	SubjectPrefix string
+}
+
+// Notify - send the notification. The context is not used by net/smtp.
+func (e *EmailNotifier) Notify(ctx context.Context, n Notification) error {
+	if len(e.To) == 0 {
+		return &permanentError{fmt.Errorf("email notifier has no recipients")}
+	}
+	subject := strings.TrimSpace(headerValue(e.SubjectPrefix + " " + n.Title))
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", headerValue(e.From))
+	fmt.Fprintf(&msg, "To: %s\r\n", headerValue(strings.Join(e.To, ", ")))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", n.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&msg, "\r\n%s\r\n", strings.ReplaceAll(n.Text, "\n", "\r\n"))
+	return smtp.SendMail(e.Addr, e.Auth, e.From, e.To, msg.Bytes())
+}
+
+// headerValue - replace line breaks so a value can't add headers to an email
+func headerValue(v string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(v)
+}
+
+// NewCommandNotifier - create a notifier running a command for each
+// notification, with the notification as JSON on stdin
+func NewCommandNotifier(name string, args ...string) *CommandNotifier {
+	c := CommandNotifier{}
+	c.Name = name
+	c.Args = args
+	c.Timeout = 30 * time.Second
+	return &c
+}
+
+// CommandNotifier - runs a command for each notification. The command gets
+// the notification as JSON on stdin and as STAKE_NOTIFY_* environment variables.
+type CommandNotifier struct {
+	Name    string
+	Args    []string
+	Timeout time.Duration
+}
+
+// Notify - run the command
+func (c *CommandNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return &permanentError{err}
+	}
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"STAKE_NOTIFY_ID="+n.ID,
+		"STAKE_NOTIFY_TYPE="+n.Type,
+		"STAKE_NOTIFY_TITLE="+n.Title,
+		"STAKE_NOTIFY_TEXT="+n.Text,
+		"STAKE_NOTIFY_SYMBOL="+n.Symbol,
+		"STAKE_NOTIFY_ORDER_ID="+n.OrderID,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", c.Name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package stakego
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Notification types that aren't watch events
+const NotifyRiskRejected = "RISK_REJECTED"
+const NotifySessionExpiring = "SESSION_EXPIRING"
+const NotifySessionExpired = "SESSION_EXPIRED"
+const NotifySessionInvalid = "SESSION_INVALID"
+const NotifySessionError = "SESSION_ERROR"
+
+// Notification - a message for the notifiers, built from a trading event
+type Notification struct {
+	ID      string    `json:"id"` // identifies the event, for deduplication
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Title   string    `json:"title"`
+	Text    string    `json:"text"`
+	Symbol  string    `json:"symbol,omitempty"`
+	OrderID string    `json:"orderId,omitempty"`
+	Side    string    `json:"side,omitempty"`
+	Units   int       `json:"units,omitempty"`
+	Price   float64   `json:"price,omitempty"`
+}
+
+// Notifier - delivers notifications somewhere
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// NotifierFunc - adapts a function to a Notifier
+type NotifierFunc func(ctx context.Context, n Notification) error
+
+// Notify - call f
+func (f NotifierFunc) Notify(ctx context.Context, n Notification) error {
+	return f(ctx, n)
+}
+
+// permanentError - a notifier error that retrying won't fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// NotificationFromWatchEvent - build a notification for fills and
+// cancellations. Other events return false.
+func NotificationFromWatchEvent(ev WatchEvent) (Notification, bool) {
+	n := Notification{Type: ev.Type, Time: ev.Time, Symbol: ev.Symbol, OrderID: ev.OrderID, Side: ev.Side, Units: ev.Units, Price: ev.Price}
+	filled := 0
+	if ev.Order != nil {
+		filled = ev.Order.FilledUnits
+	}
+	n.ID = fmt.Sprintf("%s:%s:%d:%d", ev.Type, ev.OrderID, filled, ev.Units)
+
+	switch ev.Type {
+	case EventOrderFilled:
+		n.Title = fmt.Sprintf("Order filled: %s %d %s @ %.3f", ev.Side, ev.Units, ev.Symbol, ev.Price)
+		n.Text = fmt.Sprintf("%d units of %s were %s at $%.3f (order %s).", ev.Units, ev.Symbol, pastTense(ev.Side), ev.Price, ev.OrderID)
+	case EventOrderCancelled:
+		n.Title = fmt.Sprintf("Order cancelled: %s %d %s @ %.3f", ev.Side, ev.Units, ev.Symbol, ev.Price)
+		n.Text = fmt.Sprintf("The order to %s %d units of %s at $%.3f is no longer pending and was not filled (order %s).", ev.Side, ev.Units, ev.Symbol, ev.Price, ev.OrderID)
+	default:
+		return n, false
+	}
+	return n, true
+}
+
+// pastTense - "bought" or "sold"
+func pastTense(side string) string {
+	if side == OrderSELL {
+		return "sold"
+	}
+	return "bought"
+}
+
+// NotificationFromRiskRejection - build a notification for a rejected order
+func NotificationFromRiskRejection(r *RiskRejection) Notification {
+	o := r.Order
+	return Notification{
+		ID:     fmt.Sprintf("%s:%s:%s:%s:%d:%g", NotifyRiskRejected, r.Rule, o.Side, o.InstrumentCode, o.Units, o.Price),
+		Type:   NotifyRiskRejected,
+		Time:   time.Now(),
+		Title:  fmt.Sprintf("Order rejected by %s: %s %d %s @ %.3f", r.Rule, o.Side, o.Units, o.InstrumentCode, o.Price),
+		Text:   r.Reason,
+		Symbol: o.InstrumentCode,
+		Side:   o.Side,
+		Units:  o.Units,
+		Price:  o.Price,
+	}
+}
+
+// NotificationFromSessionEvent - build a notification for an expiring,
+// expired or rejected session. Other events return false.
+func NotificationFromSessionEvent(ev SessionEvent) (Notification, bool) {
+	n := Notification{Time: ev.Time}
+	expires := "an unknown time"
+	if !ev.ExpiresAt.IsZero() {
+		expires = ev.ExpiresAt.Format(time.RFC1123)
+	}
+	switch ev.Type {
+	case SessionEventExpiring:
+		n.Type = NotifySessionExpiring
+		n.Title = "Stake session expiring"
+		n.Text = fmt.Sprintf("The session token expires at %s.", expires)
+	case SessionEventExpired:
+		n.Type = NotifySessionExpired
+		n.Title = "Stake session expired"
+		n.Text = fmt.Sprintf("The session token expired at %s.", expires)
+	case SessionEventInvalid:
+		n.Type = NotifySessionInvalid
+		n.Title = "Stake session rejected"
+		n.Text = "Stake no longer accepts the session token."
+	case SessionEventError:
+		n.Type = NotifySessionError
+		n.Title = "Stake session check failed"
+		if ev.Err != nil {
+			n.Text = ev.Err.Error()
+		}
+	default:
+		return n, false
+	}
+	n.ID = fmt.Sprintf("%s:%d", n.Type, ev.ExpiresAt.Unix())
+	if ev.Type == SessionEventError || ev.Type == SessionEventInvalid {
+		n.ID = fmt.Sprintf("%s:%d", n.Type, ev.Time.Unix())
+	}
+	return n, true
+}
+
+// NewNotificationDispatcher - create a dispatcher sending to notifiers,
+// retrying each up to 3 times and dropping repeats within an hour
+func NewNotificationDispatcher(notifiers ...Notifier) *NotificationDispatcher {
+	d := NotificationDispatcher{}
+	d.Notifiers = notifiers
+	d.Retries = 3
+	d.Backoff = 2 * time.Second
+	d.DedupWindow = time.Hour
+	d.sent = map[string]time.Time{}
+	return &d
+}
+
+// NotificationDispatcher - sends notifications to every notifier, with
+// retries and deduplication
+type NotificationDispatcher struct {
+	Notifiers   []Notifier
+	Retries     int           // attempts after the first
+	Backoff     time.Duration // delay before the first retry, doubled for each one after
+	DedupWindow time.Duration // notifications with the same ID within this window are dropped
+	OnError     func(n Notification, err error)
+
+	mutex sync.Mutex
+	sent  map[string]time.Time
+}
+
+// claim - mark an ID as sent, returning false if it already was
+func (d *NotificationDispatcher) claim(id string, now time.Time) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for k, t := range d.sent {
+		if now.Sub(t) >= d.DedupWindow {
+			delete(d.sent, k)
+		}
+	}
+	if _, ok := d.sent[id]; ok {
+		return false
+	}
+	d.sent[id] = now
+	return true
+}
+
+// unclaim - forget an ID so that a repeat is sent
+func (d *NotificationDispatcher) unclaim(id string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.sent, id)
+}
+
+// Send - send a notification to every notifier concurrently. A notification
+// with the same ID as one sent within DedupWindow is dropped. If every
+// notifier fails, the ID is forgotten so that a repeat is sent.
+func (d *NotificationDispatcher) Send(ctx context.Context, n Notification) error {
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	if n.ID != "" && d.DedupWindow > 0 && !d.claim(n.ID, time.Now()) {
+		return nil
+	}
+
+	errs := make([]error, len(d.Notifiers))
+	var wg sync.WaitGroup
+	for i, notifier := range d.Notifiers {
+		wg.Add(1)
+		go func(i int, notifier Notifier) {
+			defer wg.Done()
+			errs[i] = d.sendWithRetry(ctx, notifier, n)
+		}(i, notifier)
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, err := range errs {
+		if err != nil {
+			failed++
+			if d.OnError != nil {
+				d.OnError(n, err)
+			}
+		}
+	}
+	if failed > 0 && failed == len(d.Notifiers) && n.ID != "" {
+		d.unclaim(n.ID)
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		return NewStakeError("notify", err)
+	}
+	return nil
+}
+
+// sendWithRetry - send to one notifier, retrying with exponential backoff
+func (d *NotificationDispatcher) sendWithRetry(ctx context.Context, notifier Notifier, n Notification) error {
+	backoff := d.Backoff
+	var err error
+	for attempt := 0; attempt <= d.Retries; attempt++ {
+		if attempt > 0 {
+			if sleepCtx(ctx, backoff) != nil {
+				return err
+			}
+			backoff *= 2
+		}
+		err = notifier.Notify(ctx, n)
+		var perm *permanentError
+		if err == nil || errors.As(err, &perm) {
+			return err
+		}
+	}
+	return err
+}
+
+// WatchEvents - send a notification for each fill and cancellation on
+// events, until it is closed or ctx is done
+func (d *NotificationDispatcher) WatchEvents(ctx context.Context, events <-chan WatchEvent) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if n, ok := NotificationFromWatchEvent(ev); ok {
+				_ = d.Send(ctx, n)
+			}
+		}
+	}
+}
+
+// SessionEvents - send a notification for session expiry and errors from
+// a keepalive, until events is closed or ctx is done
+func (d *NotificationDispatcher) SessionEvents(ctx context.Context, events <-chan SessionEvent) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if n, ok := NotificationFromSessionEvent(ev); ok {
+				_ = d.Send(ctx, n)
+			}
+		}
+	}
+}
+
+// RiskRejected - send a notification for a rejected order in the
+// background. Assign it to ASXClient.OnRiskRejection.
+func (d *NotificationDispatcher) RiskRejected(r *RiskRejection) {
+	n := NotificationFromRiskRejection(r)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		_ = d.Send(ctx, n)
+	}()
+}
@@ -0,0 +1,226 @@
+package stakego_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mdusher/stakego"
+	"github.com/mdusher/stakego/staketest"
+)
+
+// countingNotifier - wraps n, counting attempts
+func countingNotifier(n stakego.Notifier, attempts *int32) stakego.Notifier {
+	return stakego.NotifierFunc(func(ctx context.Context, note stakego.Notification) error {
+		atomic.AddInt32(attempts, 1)
+		return n.Notify(ctx, note)
+	})
+}
+
+// fastDispatcher - a dispatcher with short backoff, for tests
+func fastDispatcher(notifiers ...stakego.Notifier) *stakego.NotificationDispatcher {
+	d := stakego.NewNotificationDispatcher(notifiers...)
+	d.Backoff = time.Millisecond
+	return d
+}
+
+func TestNotificationDispatcherRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		failures int
+		attempts int32
+		err      bool
+	}{
+		{"delivered first time", 0, 0, 1, false},
+		{"server errors retried", http.StatusInternalServerError, 2, 3, false},
+		{"rate limit retried", http.StatusTooManyRequests, 1, 2, false},
+		{"timeout retried", http.StatusRequestTimeout, 1, 2, false},
+		{"retries run out", http.StatusBadGateway, 4, 4, true},
+		{"client errors are permanent", http.StatusBadRequest, 1, 1, true},
+		{"unauthorised is permanent", http.StatusUnauthorized, 1, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hooks := staketest.NewWebhookServer("")
+			defer hooks.Close()
+			hooks.Fail(tt.status, tt.failures)
+			var attempts int32
+			d := fastDispatcher(countingNotifier(stakego.NewWebhookNotifier(hooks.URL(), ""), &attempts))
+			var reported error
+			d.OnError = func(n stakego.Notification, err error) { reported = err }
+
+			n := stakego.Notification{ID: "n-1", Type: stakego.NotifyAlert, Title: "test"}
+			err := d.Send(context.Background(), n)
+			if (err != nil) != tt.err || (reported != nil) != tt.err {
+				t.Fatalf("Send = %v, OnError %v, want error %v", err, reported, tt.err)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("%d attempts, want %d", attempts, tt.attempts)
+			}
+			want := 1
+			if tt.err {
+				want = 0
+			}
+			if got := len(hooks.Received()); got != want {
+				t.Fatalf("%d webhooks received, want %d", got, want)
+			}
+
+			// a notification that failed everywhere isn't remembered, so a
+			// repeat is sent once the receiver recovers
+			if err := d.Send(context.Background(), n); err != nil {
+				t.Fatalf("repeat Send: %v", err)
+			}
+			if got := len(hooks.Received()); got != 1 {
+				t.Errorf("%d webhooks received after the repeat, want 1", got)
+			}
+		})
+	}
+}
+
+func TestNotificationDispatcherRetryStopsOnCancel(t *testing.T) {
+	var attempts int32
+	failing := stakego.NotifierFunc(func(ctx context.Context, n stakego.Notification) error {
+		atomic.AddInt32(&attempts, 1)
+		return errors.New("unavailable")
+	})
+	d := stakego.NewNotificationDispatcher(failing)
+	d.Backoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := d.Send(ctx, stakego.Notification{ID: "n-1"}); err == nil {
+		t.Fatal("Send succeeded")
+	}
+	if attempts != 1 || time.Since(start) > time.Second {
+		t.Errorf("%d attempts in %v, want 1 and no wait for the backoff", attempts, time.Since(start))
+	}
+}
+
+func TestNotificationDispatcherDedup(t *testing.T) {
+	tests := []struct {
+		name   string
+		window time.Duration
+		ids    []string
+		want   int
+	}{
+		{"repeat dropped", time.Hour, []string{"a", "a", "a"}, 1},
+		{"different ids", time.Hour, []string{"a", "b", "a"}, 2},
+		{"no id is never deduplicated", time.Hour, []string{"", ""}, 2},
+		{"window disabled", 0, []string{"a", "a"}, 2},
+		{"window passed", time.Nanosecond, []string{"a", "a"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hooks := staketest.NewWebhookServer("")
+			defer hooks.Close()
+			d := fastDispatcher(stakego.NewWebhookNotifier(hooks.URL(), ""))
+			d.DedupWindow = tt.window
+			for _, id := range tt.ids {
+				if err := d.Send(context.Background(), stakego.Notification{ID: id, Type: stakego.NotifyAlert}); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(time.Millisecond)
+			}
+			if got := len(hooks.Received()); got != tt.want {
+				t.Errorf("%d webhooks received, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotificationDispatcherPartialFailure(t *testing.T) {
+	hooks := staketest.NewWebhookServer("")
+	defer hooks.Close()
+	var failures int32
+	failing := stakego.NotifierFunc(func(ctx context.Context, n stakego.Notification) error {
+		atomic.AddInt32(&failures, 1)
+		return errors.New("unavailable")
+	})
+	d := fastDispatcher(stakego.NewWebhookNotifier(hooks.URL(), ""), failing)
+	d.Retries = 0
+
+	n := stakego.Notification{ID: "n-1", Type: stakego.NotifyAlert}
+	if err := d.Send(context.Background(), n); err == nil {
+		t.Fatal("Send didn't report the failed notifier")
+	}
+	// delivered once, so the repeat is dropped rather than sent again
+	if err := d.Send(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(hooks.Received()); got != 1 || failures != 1 {
+		t.Errorf("%d webhooks received, %d failed attempts, want 1 each", got, failures)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"id":"n-1"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := stakego.SignWebhook("secret", "1700000000", body); got != want {
+		t.Errorf("SignWebhook = %s, want %s", got, want)
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      string
+		valid     bool
+	}{
+		{"valid", "secret", "1700000000", `{"id":"n-1"}`, true},
+		{"wrong secret", "other", "1700000000", `{"id":"n-1"}`, false},
+		{"replayed timestamp", "secret", "1700000001", `{"id":"n-1"}`, false},
+		{"changed body", "secret", "1700000000", `{"id":"n-2"}`, false},
+	}
+	for _, tt := range tests {
+		if got := stakego.VerifyWebhook(tt.secret, tt.timestamp, []byte(tt.body), want); got != tt.valid {
+			t.Errorf("%s: VerifyWebhook = %v, want %v", tt.name, got, tt.valid)
+		}
+	}
+}
+
+func TestWebhookNotifierSigns(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		signed bool
+		valid  bool
+	}{
+		{"signed", "secret", true, true},
+		{"wrong secret", "other", true, false},
+		{"unsigned", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hooks := staketest.NewWebhookServer("secret")
+			defer hooks.Close()
+			w := stakego.NewWebhookNotifier(hooks.URL(), tt.secret)
+			n := stakego.Notification{ID: "n-1", Type: stakego.NotifyAlert, Title: "BHP below 42", Symbol: "BHP", Price: 41.5}
+			if err := w.Notify(context.Background(), n); err != nil {
+				t.Fatal(err)
+			}
+			got := hooks.Received()
+			if len(got) != 1 {
+				t.Fatalf("%d webhooks received, want 1", len(got))
+			}
+			h := got[0]
+			if h.SignatureValid != tt.valid || (h.Header.Get(stakego.WebhookSignatureHeader) != "") != tt.signed {
+				t.Errorf("signature %q valid %v, want signed %v valid %v", h.Header.Get(stakego.WebhookSignatureHeader), h.SignatureValid, tt.signed, tt.valid)
+			}
+			if h.Header.Get(stakego.WebhookEventHeader) != stakego.NotifyAlert || h.Header.Get(stakego.WebhookDeliveryHeader) != "n-1" {
+				t.Errorf("headers = %v", h.Header)
+			}
+			if h.Notification.Symbol != "BHP" || h.Notification.Price != 41.5 {
+				t.Errorf("notification = %+v", h.Notification)
+			}
+		})
+	}
+}
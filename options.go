@@ -0,0 +1,38 @@
+package stakego
+
+import (
+	"net/http"
+)
+
+// ClientOption - configures an ASXClient created with NewASXClient
+type ClientOption func(c *ASXClient)
+
+// WithPaperTrading - serve orders, cash and positions from a simulated
+// ledger instead of sending them to Stake. Read only endpoints still use Stake.
+func WithPaperTrading(ledger *PaperLedger) ClientOption {
+	return func(c *ASXClient) {
+		c.paper = ledger
+	}
+}
+
+// WithBaseURL - send API requests to apiURL instead of Stake's production API
+func WithBaseURL(apiURL string) ClientOption {
+	return func(c *ASXClient) {
+		c.apiUrl = apiURL
+	}
+}
+
+// WithLocationURL - fetch the location and calendar data from locationURL
+func WithLocationURL(locationURL string) ClientOption {
+	return func(c *ASXClient) {
+		c.Calendar.URL = locationURL
+	}
+}
+
+// WithHTTPClient - use hc for all requests, including location data
+func WithHTTPClient(hc http.Client) ClientOption {
+	return func(c *ASXClient) {
+		c.httpclient = hc
+		c.Calendar.SetHTTPClient(hc)
+	}
+}
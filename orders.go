@@ -12,6 +12,12 @@ const OrderTypeSTOP = "STOP"
 const OrderValidityGoodTilDate = "GTD"
 const OrderValidityGoodForDay = "GFD"
 
+// Order statuses
+const OrderStatusOpen = "OPEN"
+const OrderStatusFilled = "FILLED"
+const OrderStatusCancelled = "CANCELLED"
+const OrderStatusExpired = "EXPIRED"
+
 // NewOrderListFromJSON - creates a slice of OrderDetails from a JSON string
 func NewOrderListFromJSON(jsonStr []byte) *[]OrderDetails {
 	var o []OrderDetails
@@ -0,0 +1,81 @@
+package stakego
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+)
+
+// OTPProvider - supplies a one time password when Stake asks for one during login
+type OTPProvider interface {
+	GetOTP() (string, error)
+}
+
+// StaticOTPProvider - returns a fixed, pre-obtained OTP
+type StaticOTPProvider struct {
+	Code string
+}
+
+// GetOTP - returns the static code
+func (p StaticOTPProvider) GetOTP() (string, error) {
+	if p.Code == "" {
+		return "", ErrOTPRequired
+	}
+	return p.Code, nil
+}
+
+// TOTPProvider - generates an OTP from a TOTP secret
+type TOTPProvider struct {
+	Secret string
+}
+
+// GetOTP - generate the current TOTP code
+func (p TOTPProvider) GetOTP() (string, error) {
+	o, err := totp.GenerateCode(p.Secret, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return o, nil
+}
+
+// NewPromptOTPProvider - create an OTPProvider that asks for the OTP on the terminal
+func NewPromptOTPProvider() *PromptOTPProvider {
+	p := PromptOTPProvider{}
+	p.In = os.Stdin
+	p.Out = os.Stderr
+	p.Prompt = "Stake OTP: "
+	return &p
+}
+
+// PromptOTPProvider - prompts for the OTP interactively. If In is a
+// *bufio.Reader it is read directly, so it can be shared with other prompts
+// without either losing buffered input.
+type PromptOTPProvider struct {
+	In     io.Reader
+	Out    io.Writer
+	Prompt string
+}
+
+// GetOTP - write the prompt and read a code from the input
+func (p *PromptOTPProvider) GetOTP() (string, error) {
+	r, ok := p.In.(*bufio.Reader)
+	if !ok {
+		r = bufio.NewReader(p.In)
+		p.In = r
+	}
+	fmt.Fprint(p.Out, p.Prompt)
+	line, err := r.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	code := strings.TrimSpace(line)
+	if code == "" {
+		return "", ErrOTPRequired
+	}
+	return code, nil
+}
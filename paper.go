@@ -0,0 +1,339 @@
+package stakego
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// newOrderID - generate a random UUID formatted order ID
+func newOrderID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	h := hex.EncodeToString(b)
+	return fmt.Sprintf("%s-%s-%s-%s-%s", h[0:8], h[8:12], h[12:16], h[16:20], h[20:32])
+}
+
+// paperPosition - a simulated holding
+type paperPosition struct {
+	Units        int
+	AveragePrice float64
+	LastPrice    float64
+	PriorClose   float64
+}
+
+// NewPaperLedger - create a simulated account holding cash, using prices
+// to decide when limit orders fill
+func NewPaperLedger(cash float64, prices PriceFunc) *PaperLedger {
+	l := PaperLedger{}
+	l.cash = cash
+	l.Prices = prices
+	l.positions = make(map[string]*paperPosition)
+	l.day = auToday()
+	return &l
+}
+
+// PaperLedger - a simulated account. Limit buys fill when the price is at
+// or below the limit, limit sells when it is at or above. When the date in
+// Sydney changes, each position's PriorClose becomes its last price, GFD
+// orders expire and so do GTD orders whose ValidityDate has passed.
+type PaperLedger struct {
+	Prices    PriceFunc
+	Brokerage BrokerageFunc // optional, no brokerage is charged if nil
+
+	mutex     sync.Mutex
+	cash      float64
+	positions map[string]*paperPosition
+	orders    []OrderDetails
+	filled    []OrderDetails
+	day       string
+}
+
+// SetPosition - seed the ledger with a holding
+func (l *PaperLedger) SetPosition(symbol string, units int, averagePrice float64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.positions[symbol] = &paperPosition{Units: units, AveragePrice: averagePrice, LastPrice: averagePrice, PriorClose: averagePrice}
+}
+
+// Filled - returns orders that have filled, been cancelled or expired
+func (l *PaperLedger) Filled() []OrderDetails {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]OrderDetails{}, l.filled...)
+}
+
+// pendingBuys - cash committed to open buy orders. Must be called with the mutex held.
+func (l *PaperLedger) pendingBuys() float64 {
+	total := 0.0
+	for _, o := range l.orders {
+		if o.Side == OrderBUY {
+			total += float64(o.UnitsRemaining)*o.LimitPrice + o.EstimatedBrokerage
+		}
+	}
+	return total
+}
+
+// pendingSells - units committed to open sell orders. Must be called with the mutex held.
+func (l *PaperLedger) pendingSells(symbol string) int {
+	total := 0
+	for _, o := range l.orders {
+		if o.Side == OrderSELL && o.InstrumentCode == symbol {
+			total += o.UnitsRemaining
+		}
+	}
+	return total
+}
+
+// PlaceOrder - add an order to the ledger, filling it straight away if the price allows
+func (l *PaperLedger) PlaceOrder(order Order) (*OrderResponse, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.rollDay()
+
+	if order.Units <= 0 || order.Price <= 0 {
+		return nil, fmt.Errorf("units and price must be positive")
+	}
+	fee := 0.0
+	if l.Brokerage != nil {
+		f, err := l.Brokerage(float64(order.Units) * order.Price)
+		if err != nil {
+			return nil, err
+		}
+		fee = f
+	}
+
+	switch order.Side {
+	case OrderBUY:
+		cost := float64(order.Units)*order.Price + fee
+		if cost > l.cash-l.pendingBuys() {
+			return nil, fmt.Errorf("insufficient buying power for $%.2f", cost)
+		}
+	case OrderSELL:
+		held := 0
+		if p, ok := l.positions[order.InstrumentCode]; ok {
+			held = p.Units
+		}
+		if order.Units > held-l.pendingSells(order.InstrumentCode) {
+			return nil, fmt.Errorf("insufficient units of %s available to sell", order.InstrumentCode)
+		}
+	default:
+		return nil, fmt.Errorf("unknown order side '%s'", order.Side)
+	}
+
+	now := time.Now()
+	o := OrderDetails{
+		ID:                   newOrderID(),
+		Broker:               "PAPER",
+		InstrumentCode:       order.InstrumentCode,
+		InstrumentID:         order.InstrumentCode,
+		Side:                 order.Side,
+		LimitPrice:           order.Price,
+		Validity:             order.Validity,
+		ValidityDate:         order.ValidityDate,
+		Type:                 order.Type,
+		PlacedTimestamp:      now.Format(time.RFC3339),
+		OrderStatus:          OrderStatusOpen,
+		UnitsRemaining:       order.Units,
+		UnitsRequested:       order.Units,
+		EstimatedBrokerage:   fee,
+		PendingBrokerage:     fee,
+		AllowAwaitingTrigger: order.AllowAwaitingTrigger,
+	}
+	l.orders = append(l.orders, o)
+	l.match()
+
+	for _, f := range l.filled {
+		if f.ID == o.ID {
+			return &OrderResponse{Order: f}, nil
+		}
+	}
+	return &OrderResponse{Order: o}, nil
+}
+
+// CancelOrder - cancel an open order
+func (l *PaperLedger) CancelOrder(id string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.rollDay()
+	for i, o := range l.orders {
+		if o.ID == id {
+			o.OrderStatus = OrderStatusCancelled
+			o.OrderCompletionType = OrderStatusCancelled
+			o.CompletedTimestamp = time.Now().Format(time.RFC3339)
+			l.filled = append(l.filled, o)
+			l.orders = append(l.orders[:i], l.orders[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("order '%s' not found", id)
+}
+
+// GetOrders - returns open orders, after filling any whose price has crossed
+func (l *PaperLedger) GetOrders() (*[]OrderDetails, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.match()
+	orders := append([]OrderDetails{}, l.orders...)
+	return &orders, nil
+}
+
+// GetCash - returns the simulated cash balances
+func (l *PaperLedger) GetCash() (*Cash, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.match()
+	pending := l.pendingBuys()
+	c := Cash{
+		SettledCash:                l.cash,
+		PostedBalance:              l.cash,
+		BuyingPower:                l.cash - pending,
+		PendingBuys:                pending,
+		CashAvailableForWithdrawal: l.cash - pending,
+		CashAvailableForTransfer:   l.cash - pending,
+	}
+	return &c, nil
+}
+
+// GetEquityPositions - returns the simulated holdings valued at the latest prices
+func (l *PaperLedger) GetEquityPositions() (*EquityPositions, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.match()
+
+	e := EquityPositions{PageNum: 1}
+	symbols := make([]string, 0, len(l.positions))
+	for s := range l.positions {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+	for _, s := range symbols {
+		p := l.positions[s]
+		if p.Units == 0 {
+			continue
+		}
+		cost := float64(p.Units) * p.AveragePrice
+		value := float64(p.Units) * p.LastPrice
+		item := EquityPositionItem{
+			InstrumentID:           s,
+			Symbol:                 s,
+			Name:                   s,
+			OpenQty:                p.Units,
+			AvailableForTradingQty: p.Units - l.pendingSells(s),
+			AveragePrice:           p.AveragePrice,
+			MarketValue:            value,
+			MktPrice:               p.LastPrice,
+			PriorClose:             p.PriorClose,
+			UnrealizedDayPL:        float64(p.Units) * (p.LastPrice - p.PriorClose),
+			UnrealizedPL:           value - cost,
+		}
+		if p.PriorClose != 0 {
+			item.UnrealizedDayPLPercent = (p.LastPrice - p.PriorClose) / p.PriorClose * 100
+		}
+		if cost != 0 {
+			item.UnrealizedPLPercent = (value - cost) / cost * 100
+		}
+		e.EquityPositions = append(e.EquityPositions, item)
+	}
+	return &e, nil
+}
+
+// rollDay - start a new trading day if the date has changed since the last
+// one. Must be called with the mutex held.
+func (l *PaperLedger) rollDay() {
+	today := auToday()
+	if today == l.day {
+		return
+	}
+	l.day = today
+
+	for _, pos := range l.positions {
+		pos.PriorClose = pos.LastPrice
+	}
+	open := l.orders[:0]
+	for _, o := range l.orders {
+		if o.Validity == OrderValidityGoodForDay || (o.Validity == OrderValidityGoodTilDate && o.ValidityDate < today) {
+			o.OrderStatus = OrderStatusExpired
+			o.OrderCompletionType = OrderStatusExpired
+			o.CompletedTimestamp = time.Now().Format(time.RFC3339)
+			l.filled = append(l.filled, o)
+			continue
+		}
+		open = append(open, o)
+	}
+	l.orders = open
+}
+
+// match - start a new day if needed, update prices and fill open orders
+// that have crossed. Must be called with the mutex held.
+func (l *PaperLedger) match() {
+	l.rollDay()
+	if l.Prices == nil {
+		return
+	}
+	prices := make(map[string]float64)
+	price := func(symbol string) (float64, bool) {
+		if p, ok := prices[symbol]; ok {
+			return p, p > 0
+		}
+		p, err := l.Prices(symbol)
+		if err != nil {
+			p = 0
+		}
+		prices[symbol] = p
+		return p, p > 0
+	}
+
+	for s, pos := range l.positions {
+		if p, ok := price(s); ok {
+			pos.LastPrice = p
+		}
+	}
+
+	open := l.orders[:0]
+	for _, o := range l.orders {
+		p, ok := price(o.InstrumentCode)
+		if !ok || (o.Side == OrderBUY && p > o.LimitPrice) || (o.Side == OrderSELL && p < o.LimitPrice) {
+			open = append(open, o)
+			continue
+		}
+		l.fill(&o, p)
+		l.filled = append(l.filled, o)
+	}
+	l.orders = open
+}
+
+// fill - fill an order in full at price. Must be called with the mutex held.
+func (l *PaperLedger) fill(o *OrderDetails, price float64) {
+	units := o.UnitsRemaining
+	value := float64(units) * price
+	pos, ok := l.positions[o.InstrumentCode]
+	if !ok {
+		pos = &paperPosition{LastPrice: price, PriorClose: price}
+		l.positions[o.InstrumentCode] = pos
+	}
+
+	if o.Side == OrderBUY {
+		l.cash -= value + o.EstimatedBrokerage
+		pos.AveragePrice = (pos.AveragePrice*float64(pos.Units) + value) / float64(pos.Units+units)
+		pos.Units += units
+	} else {
+		l.cash += value - o.EstimatedBrokerage
+		pos.Units -= units
+	}
+	pos.LastPrice = price
+
+	o.FilledUnits = units
+	o.UnitsRemaining = 0
+	o.AveragePrice = price
+	o.ChargedBrokerage = o.EstimatedBrokerage
+	o.PendingBrokerage = 0
+	o.OrderStatus = OrderStatusFilled
+	o.OrderCompletionType = OrderStatusFilled
+	o.CompletedTimestamp = time.Now().Format(time.RFC3339)
+}
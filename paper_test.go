@@ -0,0 +1,187 @@
+package stakego
+
+import (
+	"testing"
+)
+
+// paperOrder - a GTD limit order, valid for a month
+func paperOrder(side string, symbol string, units int, price float64) Order {
+	o := NewBuyOrder()
+	if side == OrderSELL {
+		o = NewSellOrder()
+	}
+	o.InstrumentCode = symbol
+	o.Units = units
+	o.Price = price
+	return *o
+}
+
+// newTestLedger - a ledger with $10000 and 100 BHP, priced from prices,
+// charging $3 brokerage
+func newTestLedger(prices map[string]float64) *PaperLedger {
+	l := NewPaperLedger(10000, func(symbol string) (float64, error) {
+		return prices[symbol], nil
+	})
+	l.Brokerage = func(amount float64) (float64, error) { return 3, nil }
+	l.SetPosition("BHP", 100, 40)
+	return l
+}
+
+func TestPaperLedgerFill(t *testing.T) {
+	tests := []struct {
+		name   string
+		order  Order
+		status string
+		price  float64 // average fill price
+		cash   float64
+		units  int // BHP held afterwards
+		err    bool
+	}{
+		{"buy at market", paperOrder(OrderBUY, "BHP", 10, 50), OrderStatusFilled, 50, 9497, 110, false},
+		{"buy fills at the better price", paperOrder(OrderBUY, "BHP", 10, 52), OrderStatusFilled, 50, 9497, 110, false},
+		{"buy below market waits", paperOrder(OrderBUY, "BHP", 10, 49.5), OrderStatusOpen, 0, 10000, 100, false},
+		{"sell at market", paperOrder(OrderSELL, "BHP", 40, 50), OrderStatusFilled, 50, 11997, 60, false},
+		{"sell above market waits", paperOrder(OrderSELL, "BHP", 40, 51), OrderStatusOpen, 0, 10000, 100, false},
+		{"buy without the cash", paperOrder(OrderBUY, "BHP", 200, 50), "", 0, 10000, 100, true},
+		{"sell more than held", paperOrder(OrderSELL, "BHP", 101, 50), "", 0, 10000, 100, true},
+		{"no units", paperOrder(OrderBUY, "BHP", 0, 50), "", 0, 10000, 100, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLedger(map[string]float64{"BHP": 50})
+			resp, err := l.PlaceOrder(tt.order)
+			if (err != nil) != tt.err {
+				t.Fatalf("PlaceOrder = %v, want error %v", err, tt.err)
+			}
+			if err == nil {
+				// AveragePrice is only set once an order fills
+				avg, _ := resp.Order.AveragePrice.(float64)
+				if resp.Order.OrderStatus != tt.status || avg != tt.price {
+					t.Errorf("order %s at %.2f, want %s at %.2f", resp.Order.OrderStatus, avg, tt.status, tt.price)
+				}
+			}
+			cash, _ := l.GetCash()
+			if cash.SettledCash != tt.cash {
+				t.Errorf("cash = %.2f, want %.2f", cash.SettledCash, tt.cash)
+			}
+			positions, _ := l.GetEquityPositions()
+			if positions.EquityPositions[0].OpenQty != tt.units {
+				t.Errorf("BHP held = %d, want %d", positions.EquityPositions[0].OpenQty, tt.units)
+			}
+		})
+	}
+}
+
+func TestPaperLedgerPendingOrders(t *testing.T) {
+	prices := map[string]float64{"BHP": 50}
+	l := newTestLedger(prices)
+
+	// pending orders hold back cash and units
+	if _, err := l.PlaceOrder(paperOrder(OrderBUY, "BHP", 100, 49)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.PlaceOrder(paperOrder(OrderSELL, "BHP", 60, 55)); err != nil {
+		t.Fatal(err)
+	}
+	cash, _ := l.GetCash()
+	if cash.BuyingPower != 10000-4903 || cash.PendingBuys != 4903 {
+		t.Errorf("buying power %.2f, pending %.2f, want 5097 and 4903", cash.BuyingPower, cash.PendingBuys)
+	}
+	if _, err := l.PlaceOrder(paperOrder(OrderBUY, "BHP", 110, 49)); err == nil {
+		t.Error("buy beyond the remaining buying power was accepted")
+	}
+	if _, err := l.PlaceOrder(paperOrder(OrderSELL, "BHP", 41, 55)); err == nil {
+		t.Error("sell of units committed to another sell was accepted")
+	}
+	positions, _ := l.GetEquityPositions()
+	if p := positions.EquityPositions[0]; p.AvailableForTradingQty != 40 {
+		t.Errorf("available = %d, want 40", p.AvailableForTradingQty)
+	}
+
+	// the buy fills once the price drops to its limit, at the new price
+	prices["BHP"] = 48.5
+	orders, _ := l.GetOrders()
+	if len(*orders) != 1 || (*orders)[0].Side != OrderSELL {
+		t.Fatalf("open orders = %+v, want the sell", *orders)
+	}
+	positions, _ = l.GetEquityPositions()
+	p := positions.EquityPositions[0]
+	if p.OpenQty != 200 || p.AveragePrice != 44.25 || p.MktPrice != 48.5 {
+		t.Errorf("position = %d @ %.2f, last %.2f, want 200 @ 44.25, last 48.50", p.OpenQty, p.AveragePrice, p.MktPrice)
+	}
+	cash, _ = l.GetCash()
+	if cash.SettledCash != 10000-4853 || cash.PendingBuys != 0 {
+		t.Errorf("cash = %.2f, pending %.2f, want 5147 and none", cash.SettledCash, cash.PendingBuys)
+	}
+
+	// then the sell, once the price rises
+	prices["BHP"] = 56
+	orders, _ = l.GetOrders()
+	if len(*orders) != 0 {
+		t.Errorf("open orders = %+v, want none", *orders)
+	}
+	filled := l.Filled()
+	if len(filled) != 2 || filled[1].FilledUnits != 60 || filled[1].AveragePrice != 56.0 || filled[1].ChargedBrokerage != 3 {
+		t.Errorf("filled = %+v, want the sell of 60 at 56", filled)
+	}
+}
+
+func TestPaperLedgerRollDay(t *testing.T) {
+	prices := map[string]float64{"BHP": 50}
+	l := newTestLedger(prices)
+
+	gfd := paperOrder(OrderBUY, "BHP", 10, 45)
+	gfd.Validity = OrderValidityGoodForDay
+	gfd.ValidityDate = ""
+	expired := paperOrder(OrderBUY, "BHP", 10, 45)
+	expired.ValidityDate = "2000-01-01"
+	open := paperOrder(OrderSELL, "BHP", 10, 60)
+	open.ValidityDate = "2999-12-31"
+	ids := map[string]string{}
+	for name, o := range map[string]Order{"gfd": gfd, "expired": expired, "open": open} {
+		resp, err := l.PlaceOrder(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[resp.Order.ID] = name
+	}
+	prices["BHP"] = 52
+	positions, _ := l.GetEquityPositions()
+	if p := positions.EquityPositions[0]; p.PriorClose != 40 || p.UnrealizedDayPL != 1200 {
+		t.Fatalf("before the roll prior close %.2f, day P&L %.2f, want 40 and 1200", p.PriorClose, p.UnrealizedDayPL)
+	}
+
+	// a new day in Sydney
+	l.mutex.Lock()
+	l.day = "2000-01-02"
+	l.mutex.Unlock()
+
+	orders, _ := l.GetOrders()
+	if len(*orders) != 1 || ids[(*orders)[0].ID] != "open" {
+		t.Errorf("open orders = %+v, want only the GTD order that hasn't passed", *orders)
+	}
+	for _, o := range l.Filled() {
+		if o.OrderStatus != OrderStatusExpired || o.OrderCompletionType != OrderStatusExpired {
+			t.Errorf("%s order = %s, want %s", ids[o.ID], o.OrderStatus, OrderStatusExpired)
+		}
+	}
+	if n := len(l.Filled()); n != 2 {
+		t.Errorf("%d orders expired, want 2", n)
+	}
+	cash, _ := l.GetCash()
+	if cash.PendingBuys != 0 {
+		t.Errorf("pending buys = %.2f after expiry, want 0", cash.PendingBuys)
+	}
+	positions, _ = l.GetEquityPositions()
+	if p := positions.EquityPositions[0]; p.PriorClose != 52 || p.UnrealizedDayPL != 0 {
+		t.Errorf("after the roll prior close %.2f, day P&L %.2f, want 52 and 0", p.PriorClose, p.UnrealizedDayPL)
+	}
+
+	// only rolls once a day
+	if _, err := l.PlaceOrder(gfd); err != nil {
+		t.Fatal(err)
+	}
+	if orders, _ := l.GetOrders(); len(*orders) != 2 {
+		t.Errorf("%d open orders, want the new GFD order to stay open today", len(*orders))
+	}
+}
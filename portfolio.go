@@ -0,0 +1,163 @@
+package stakego
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Portfolio - a combined snapshot of cash, positions and pending orders
+type Portfolio struct {
+	AsOf      time.Time // when all parts of the snapshot had been fetched
+	Cash      Cash
+	Positions []EquityPositionItem
+	Orders    []OrderDetails
+	Holdings  []Holding
+
+	MarketValue      float64 // total market value of held positions
+	CashBalance      float64 // Cash.PostedBalance
+	TotalEquity      float64 // MarketValue + CashBalance
+	PendingBuyCash   float64 // cash committed to pending buy orders, including estimated fees
+	PendingSellUnits map[string]int
+	DayPL            float64
+	UnrealisedPL     float64
+}
+
+// Holding - a position with its weight in the portfolio
+type Holding struct {
+	Symbol              string
+	Name                string
+	Units               int
+	AvailableUnits      int
+	PendingSellUnits    int
+	AveragePrice        float64
+	Price               float64
+	MarketValue         float64
+	Weight              float64 // fraction of TotalEquity
+	DayPL               float64
+	DayPLPercent        float64
+	UnrealisedPL        float64
+	UnrealisedPLPercent float64
+}
+
+// GetPortfolio - fetch cash, positions and orders concurrently and combine them
+func (c *ASXClient) GetPortfolio(ctx context.Context) (*Portfolio, error) {
+	return GetPortfolio(ctx, c)
+}
+
+// GetPortfolio - fetch cash, positions and orders from any AccountReader
+// concurrently and combine them
+func GetPortfolio(ctx context.Context, c AccountReader) (*Portfolio, error) {
+	var wg sync.WaitGroup
+	var cash *Cash
+	var positions *EquityPositions
+	var orders *[]OrderDetails
+	var cashErr, positionsErr, ordersErr error
+
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		cash, cashErr = c.GetCash()
+	}()
+	go func() {
+		defer wg.Done()
+		positions, positionsErr = c.GetEquityPositions()
+	}()
+	go func() {
+		defer wg.Done()
+		orders, ordersErr = c.GetOrders()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, NewStakeError("portfolio", ctx.Err())
+	case <-done:
+	}
+
+	for _, err := range []error{cashErr, positionsErr, ordersErr} {
+		if err != nil {
+			return nil, NewStakeError("portfolio", err)
+		}
+	}
+	if cash == nil || positions == nil || orders == nil {
+		return nil, NewStakeError("portfolio", ErrInvalidAPIResponse)
+	}
+
+	return NewPortfolio(cash, positions, *orders, time.Now()), nil
+}
+
+// NewPortfolio - combine cash, positions and pending orders into a Portfolio
+func NewPortfolio(cash *Cash, positions *EquityPositions, orders []OrderDetails, asOf time.Time) *Portfolio {
+	p := Portfolio{}
+	p.AsOf = asOf
+	p.Cash = *cash
+	p.Positions = positions.EquityPositions
+	p.Orders = orders
+	p.PendingSellUnits = make(map[string]int)
+
+	for _, o := range orders {
+		switch o.Side {
+		case OrderBUY:
+			p.PendingBuyCash += float64(o.UnitsRemaining)*o.LimitPrice + o.EstimatedBrokerage + o.EstimatedExchangeFees
+		case OrderSELL:
+			p.PendingSellUnits[o.InstrumentCode] += o.UnitsRemaining
+		}
+	}
+
+	p.CashBalance = cash.PostedBalance
+	for _, ep := range positions.EquityPositions {
+		p.MarketValue += ep.MarketValue
+		p.DayPL += ep.UnrealizedDayPL
+		p.UnrealisedPL += ep.UnrealizedPL
+	}
+	p.TotalEquity = p.MarketValue + p.CashBalance
+
+	for _, ep := range positions.EquityPositions {
+		h := Holding{
+			Symbol:              ep.Symbol,
+			Name:                ep.Name,
+			Units:               ep.OpenQty,
+			AvailableUnits:      ep.AvailableForTradingQty,
+			PendingSellUnits:    p.PendingSellUnits[ep.Symbol],
+			AveragePrice:        ep.AveragePrice,
+			Price:               ep.MktPrice,
+			MarketValue:         ep.MarketValue,
+			DayPL:               ep.UnrealizedDayPL,
+			DayPLPercent:        ep.UnrealizedDayPLPercent,
+			UnrealisedPL:        ep.UnrealizedPL,
+			UnrealisedPLPercent: ep.UnrealizedPLPercent,
+		}
+		if p.TotalEquity != 0 {
+			h.Weight = ep.MarketValue / p.TotalEquity
+		}
+		p.Holdings = append(p.Holdings, h)
+	}
+	sort.SliceStable(p.Holdings, func(i, j int) bool {
+		return p.Holdings[i].MarketValue > p.Holdings[j].MarketValue
+	})
+	return &p
+}
+
+// GetHolding - find a holding by symbol
+func (p *Portfolio) GetHolding(symbol string) (*Holding, bool) {
+	for i := range p.Holdings {
+		if p.Holdings[i].Symbol == symbol {
+			return &p.Holdings[i], true
+		}
+	}
+	return nil, false
+}
+
+// CashWeight - fraction of TotalEquity held as cash
+func (p *Portfolio) CashWeight() float64 {
+	if p.TotalEquity == 0 {
+		return 0
+	}
+	return p.CashBalance / p.TotalEquity
+}
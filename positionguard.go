@@ -0,0 +1,532 @@
+package stakego
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Reasons a guard rule placed a sell order
+const GuardStopLoss = "STOP_LOSS"
+const GuardTrailingStop = "TRAILING_STOP"
+const GuardTakeProfit = "TAKE_PROFIT"
+const GuardOCOCancelled = "OCO_CANCELLED"
+
+// GuardRearmed - a triggered rule's sell order ended without filling while
+// the position was still held, so the rule was re-armed
+const GuardRearmed = "REARMED"
+
+// GuardOCOEnded - an OCO leg was cancelled or disappeared without filling,
+// so its sibling was left alone
+const GuardOCOEnded = "OCO_ENDED"
+
+// GuardRule - exit levels for a held position. Zero values disable a level.
+type GuardRule struct {
+	Symbol          string  `json:"symbol"`
+	Units           int     `json:"units"` // units to sell, 0 for all available
+	StopPrice       float64 `json:"stopPrice"`
+	TrailingPercent float64 `json:"trailingPercent"` // sell if the price falls this far below its high
+	TakeProfitPrice float64 `json:"takeProfitPrice"`
+}
+
+// GuardState - a rule and what the guard has done about it
+type GuardState struct {
+	Rule          GuardRule `json:"rule"`
+	HighWaterMark float64   `json:"highWaterMark"`
+	Triggered     bool      `json:"triggered"`
+	TriggeredAt   time.Time `json:"triggeredAt,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+	OrderID       string    `json:"orderId,omitempty"`
+	HeldUnits     int       `json:"heldUnits,omitempty"` // units held when triggered
+}
+
+// OCOPair - two orders where one is cancelled when the other fills
+type OCOPair struct {
+	OrderIDs [2]string `json:"orderIds"`
+	Done     bool      `json:"done"`
+	Legs     [2]OCOLeg `json:"legs"`
+}
+
+// OCOLeg - an OCO order as last seen pending, used to tell whether it
+// filled once it leaves the order list
+type OCOLeg struct {
+	Symbol string `json:"symbol,omitempty"`
+	Side   string `json:"side,omitempty"`
+	Held   int    `json:"held"` // units of Symbol held while the order was pending
+}
+
+// GuardEvent - something the guard did
+type GuardEvent struct {
+	Symbol  string
+	Reason  string
+	Price   float64
+	OrderID string
+	Err     error
+}
+
+// guardStateFile - on disk format of the guard's state
+type guardStateFile struct {
+	Rules []GuardState `json:"rules"`
+	OCOs  []OCOPair    `json:"ocos"`
+}
+
+// NewPositionGuard - create a PositionGuard, loading any saved state from statePath
+func NewPositionGuard(c Client, statePath string) (*PositionGuard, error) {
+	g := PositionGuard{}
+	g.client = c
+	g.StatePath = statePath
+	g.rules = make(map[string]*GuardState)
+
+	b, err := os.ReadFile(statePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, NewStakeError("guard", err)
+	}
+	if err == nil {
+		var sf guardStateFile
+		err = json.Unmarshal(b, &sf)
+		if err != nil {
+			return nil, NewStakeError("guard", err)
+		}
+		for i := range sf.Rules {
+			g.rules[sf.Rules[i].Rule.Symbol] = &sf.Rules[i]
+		}
+		g.ocos = sf.OCOs
+	}
+	return &g, nil
+}
+
+// PositionGuard - watches held positions and places sell orders when stop,
+// trailing stop or take profit levels are hit. It also emulates
+// one-cancels-other for pairs of orders.
+type PositionGuard struct {
+	StatePath       string
+	Prices          PriceFunc // optional, defaults to the position's MktPrice
+	SlippagePercent float64   // sell limit is placed this far below the trigger price
+	OnEvent         func(GuardEvent)
+
+	client Client
+	mutex  sync.Mutex
+	rules  map[string]*GuardState
+	ocos   []OCOPair
+	busy   map[string]bool // symbols and order IDs with a call in flight
+}
+
+// SetRule - add or replace the rule for a symbol, resetting its state
+func (g *PositionGuard) SetRule(rule GuardRule) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.rules[rule.Symbol] = &GuardState{Rule: rule}
+	return g.save()
+}
+
+// RemoveRule - stop guarding a symbol
+func (g *PositionGuard) RemoveRule(symbol string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.rules, symbol)
+	return g.save()
+}
+
+// Rules - returns the state of every rule, sorted by symbol
+func (g *PositionGuard) Rules() []GuardState {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	states := make([]GuardState, 0, len(g.rules))
+	for _, s := range g.rules {
+		states = append(states, *s)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Rule.Symbol < states[j].Rule.Symbol })
+	return states
+}
+
+// AddOCO - link two pending orders so that when one fills, the other is
+// cancelled
+func (g *PositionGuard) AddOCO(orderA string, orderB string) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.ocos = append(g.ocos, OCOPair{OrderIDs: [2]string{orderA, orderB}})
+	return g.save()
+}
+
+// save - persist the state. Must be called with the mutex held.
+func (g *PositionGuard) save() error {
+	sf := guardStateFile{OCOs: g.ocos}
+	for _, s := range g.rules {
+		sf.Rules = append(sf.Rules, *s)
+	}
+	sort.Slice(sf.Rules, func(i, j int) bool { return sf.Rules[i].Rule.Symbol < sf.Rules[j].Rule.Symbol })
+	b, err := json.MarshalIndent(sf, "", "  ")
+	if err != nil {
+		return NewStakeError("guard", err)
+	}
+	err = WriteFileAtomic(g.StatePath, b, 0600)
+	if err != nil {
+		return NewStakeError("guard", err)
+	}
+	return nil
+}
+
+// emit - send events to OnEvent, if set. Must be called without the mutex held.
+func (g *PositionGuard) emit(events ...GuardEvent) {
+	if g.OnEvent == nil {
+		return
+	}
+	for _, ev := range events {
+		g.OnEvent(ev)
+	}
+}
+
+// guardSell - a sell order a triggered rule needs placed
+type guardSell struct {
+	state *GuardState
+	order Order
+	event GuardEvent
+}
+
+// guardCancel - an OCO sibling that needs cancelling
+type guardCancel struct {
+	pair  [2]string
+	event GuardEvent
+}
+
+// Check - evaluate every rule and OCO pair once. Orders are placed and
+// cancelled without holding the mutex.
+func (g *PositionGuard) Check(ctx context.Context) error {
+	positions, err := g.client.GetEquityPositions()
+	if err != nil {
+		return NewStakeError("guard", err)
+	}
+	orders, err := g.client.GetOrders()
+	if err != nil {
+		return NewStakeError("guard", err)
+	}
+
+	held := make(map[string]EquityPositionItem)
+	for _, ep := range positions.EquityPositions {
+		held[ep.Symbol] = ep
+	}
+	known := make(map[string]OrderDetails)
+	for _, o := range *orders {
+		known[o.ID] = o
+	}
+
+	prices, events := g.prices(held)
+
+	g.mutex.Lock()
+	sells := []guardSell{}
+	for _, symbol := range sortedKeys(g.rules) {
+		if ctx.Err() != nil {
+			break
+		}
+		s := g.rules[symbol]
+		if g.busy[symbol] {
+			continue
+		}
+		if s.Triggered {
+			if ev, rearmed := g.checkTriggered(s, known, held); rearmed {
+				events = append(events, ev)
+			}
+			continue
+		}
+		ep, ok := held[symbol]
+		if !ok {
+			continue
+		}
+		price, ok := prices[symbol]
+		if !ok {
+			continue
+		}
+		sell, ev := g.checkRule(s, ep, price)
+		if sell != nil {
+			sells = append(sells, *sell)
+		} else if ev != nil {
+			events = append(events, *ev)
+		}
+	}
+
+	cancels := []guardCancel{}
+	for i := range g.ocos {
+		if ctx.Err() != nil {
+			break
+		}
+		cancel, ev := g.checkOCO(&g.ocos[i], known, held)
+		if cancel != nil {
+			cancels = append(cancels, *cancel)
+		} else if ev != nil {
+			events = append(events, *ev)
+		}
+	}
+	err = g.save()
+	g.mutex.Unlock()
+
+	g.emit(events...)
+	for _, sell := range sells {
+		g.placeSell(sell)
+	}
+	for _, cancel := range cancels {
+		g.cancelSibling(cancel)
+	}
+	if len(sells) > 0 || len(cancels) > 0 {
+		g.mutex.Lock()
+		err = errors.Join(err, g.save())
+		g.mutex.Unlock()
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// prices - the price of each held symbol with an untriggered rule
+func (g *PositionGuard) prices(held map[string]EquityPositionItem) (map[string]float64, []GuardEvent) {
+	g.mutex.Lock()
+	symbols := []string{}
+	for symbol, s := range g.rules {
+		if _, ok := held[symbol]; ok && !s.Triggered {
+			symbols = append(symbols, symbol)
+		}
+	}
+	g.mutex.Unlock()
+	sort.Strings(symbols)
+
+	prices := make(map[string]float64)
+	events := []GuardEvent{}
+	for _, symbol := range symbols {
+		price := held[symbol].MktPrice
+		if g.Prices != nil {
+			p, err := g.Prices(symbol)
+			if err != nil {
+				events = append(events, GuardEvent{Symbol: symbol, Err: err})
+				continue
+			}
+			price = p
+		}
+		prices[symbol] = price
+	}
+	return prices, events
+}
+
+// checkRule - evaluate a rule against a position, returning the sell to
+// place if it triggered. The rule is marked triggered and busy until the
+// sell is placed. Must be called with the mutex held.
+func (g *PositionGuard) checkRule(s *GuardState, ep EquityPositionItem, price float64) (*guardSell, *GuardEvent) {
+	if price <= 0 {
+		return nil, nil
+	}
+	if price > s.HighWaterMark {
+		s.HighWaterMark = price
+	}
+
+	r := s.Rule
+	reason := ""
+	switch {
+	case r.StopPrice > 0 && price <= r.StopPrice:
+		reason = GuardStopLoss
+	case r.TrailingPercent > 0 && price <= s.HighWaterMark*(1-r.TrailingPercent/100):
+		reason = GuardTrailingStop
+	case r.TakeProfitPrice > 0 && price >= r.TakeProfitPrice:
+		reason = GuardTakeProfit
+	}
+	if reason == "" {
+		return nil, nil
+	}
+
+	units := ep.AvailableForTradingQty
+	if r.Units > 0 && r.Units < units {
+		units = r.Units
+	}
+	ev := GuardEvent{Symbol: ep.Symbol, Reason: reason, Price: price}
+	if units <= 0 {
+		ev.Err = fmt.Errorf("no units available to sell")
+		return nil, &ev
+	}
+
+	o := NewSellOrder()
+	o.InstrumentCode = ep.Symbol
+	o.Units = units
+	o.Price = RoundToTick(price*(1-g.SlippagePercent/100), OrderSELL)
+	o.Validity = OrderValidityGoodForDay
+	o.ValidityDate = ""
+
+	s.Triggered = true
+	s.TriggeredAt = time.Now()
+	s.Reason = reason
+	s.OrderID = ""
+	s.HeldUnits = ep.OpenQty
+	if g.busy == nil {
+		g.busy = make(map[string]bool)
+	}
+	g.busy[ep.Symbol] = true
+	return &guardSell{state: s, order: *o, event: ev}, nil
+}
+
+// placeSell - place a triggered rule's sell order, re-arming the rule if
+// it can't be placed. Must be called without the mutex held.
+func (g *PositionGuard) placeSell(sell guardSell) {
+	resp, err := g.client.PlaceOrder(sell.order)
+
+	g.mutex.Lock()
+	symbol := sell.order.InstrumentCode
+	delete(g.busy, symbol)
+	s := sell.state
+	current := g.rules[symbol] == s
+	switch {
+	case err != nil:
+		sell.event.Err = err
+		if current {
+			s.Triggered = false
+			s.TriggeredAt = time.Time{}
+			s.Reason = ""
+			s.HeldUnits = 0
+		}
+	case resp != nil:
+		sell.event.OrderID = resp.Order.ID
+		if current {
+			s.OrderID = resp.Order.ID
+		}
+	}
+	g.mutex.Unlock()
+
+	g.emit(sell.event)
+}
+
+// checkTriggered - re-arm a triggered rule whose sell order is no longer
+// pending but didn't sell anything, e.g. a GFD order that expired. Must be
+// called with the mutex held.
+func (g *PositionGuard) checkTriggered(s *GuardState, known map[string]OrderDetails, held map[string]EquityPositionItem) (GuardEvent, bool) {
+	if s.OrderID == "" {
+		return GuardEvent{}, false
+	}
+	o, listed := known[s.OrderID]
+	if listed && !isOrderDone(o) {
+		return GuardEvent{}, false
+	}
+	if listed && (isOrderFilled(o) || o.FilledUnits > 0) {
+		return GuardEvent{}, false
+	}
+	ep, holding := held[s.Rule.Symbol]
+	if !holding || ep.OpenQty < s.HeldUnits {
+		return GuardEvent{}, false
+	}
+
+	ev := GuardEvent{Symbol: s.Rule.Symbol, Reason: GuardRearmed, OrderID: s.OrderID}
+	ev.Err = fmt.Errorf("%s sell order %s ended without filling, rule re-armed", s.Reason, s.OrderID)
+	s.Triggered = false
+	s.TriggeredAt = time.Time{}
+	s.Reason = ""
+	s.OrderID = ""
+	s.HeldUnits = 0
+	return ev, true
+}
+
+// OCO leg outcomes
+const ocoFilled = "FILLED"
+const ocoCancelled = "CANCELLED"
+const ocoUnknown = "UNKNOWN"
+
+// ocoLegOutcome - what became of an OCO leg, "" while it is pending. A leg
+// that leaves the order list is only taken as filled if the position moved
+// its way.
+func ocoLegOutcome(leg *OCOLeg, id string, known map[string]OrderDetails, held map[string]EquityPositionItem) string {
+	o, listed := known[id]
+	if listed {
+		switch {
+		case isOrderFilled(o):
+			return ocoFilled
+		case isOrderCancelled(o):
+			return ocoCancelled
+		}
+		// still pending, remember what it would change
+		leg.Symbol = o.InstrumentCode
+		leg.Side = o.Side
+		leg.Held = held[o.InstrumentCode].OpenQty
+		return ""
+	}
+
+	if leg.Symbol == "" {
+		return ocoUnknown
+	}
+	now := held[leg.Symbol].OpenQty
+	if (leg.Side == OrderSELL && now < leg.Held) || (leg.Side == OrderBUY && now > leg.Held) {
+		return ocoFilled
+	}
+	return ocoUnknown
+}
+
+// checkOCO - once one leg of an OCO pair has filled, return the sibling to
+// cancel. A leg that was cancelled, or that left the order list without
+// any sign of filling, ends the pair without touching its sibling. Must be
+// called with the mutex held.
+func (g *PositionGuard) checkOCO(pair *OCOPair, known map[string]OrderDetails, held map[string]EquityPositionItem) (*guardCancel, *GuardEvent) {
+	if pair.Done {
+		return nil, nil
+	}
+	outcomes := [2]string{}
+	for i := range pair.OrderIDs {
+		outcomes[i] = ocoLegOutcome(&pair.Legs[i], pair.OrderIDs[i], known, held)
+	}
+
+	for i, outcome := range outcomes {
+		sibling := pair.OrderIDs[1-i]
+		switch outcome {
+		case ocoFilled:
+			pair.Done = true
+			if outcomes[1-i] != "" || g.busy[sibling] {
+				return nil, nil
+			}
+			if g.busy == nil {
+				g.busy = make(map[string]bool)
+			}
+			g.busy[sibling] = true
+			return &guardCancel{pair: pair.OrderIDs, event: GuardEvent{Reason: GuardOCOCancelled, OrderID: sibling}}, nil
+		case ocoCancelled, ocoUnknown:
+			if outcomes[1-i] == ocoFilled {
+				continue
+			}
+			pair.Done = true
+			ev := GuardEvent{Reason: GuardOCOEnded, OrderID: pair.OrderIDs[i]}
+			ev.Err = fmt.Errorf("OCO order %s ended without filling, %s left as is", pair.OrderIDs[i], sibling)
+			return nil, &ev
+		}
+	}
+	return nil, nil
+}
+
+// cancelSibling - cancel the remaining leg of an OCO pair, reopening the
+// pair if that fails. Must be called without the mutex held.
+func (g *PositionGuard) cancelSibling(cancel guardCancel) {
+	err := g.client.CancelOrder(cancel.event.OrderID)
+
+	g.mutex.Lock()
+	delete(g.busy, cancel.event.OrderID)
+	if err != nil {
+		cancel.event.Err = err
+		for i := range g.ocos {
+			if g.ocos[i].OrderIDs == cancel.pair {
+				g.ocos[i].Done = false
+			}
+		}
+	}
+	g.mutex.Unlock()
+
+	g.emit(cancel.event)
+}
+
+// Run - call Check every interval until ctx is cancelled
+func (g *PositionGuard) Run(ctx context.Context, interval time.Duration) error {
+	for {
+		err := g.Check(ctx)
+		if err != nil && ctx.Err() == nil {
+			g.emit(GuardEvent{Err: err})
+		}
+		err = sleepCtx(ctx, interval)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package stakego_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/mdusher/stakego"
+	"github.com/mdusher/stakego/staketest"
+)
+
+// guardAccount - mutable positions and orders behind a mock client, with
+// the orders placed and cancelled recorded
+type guardAccount struct {
+	positions []stakego.EquityPositionItem
+	orders    []stakego.OrderDetails
+	placed    []stakego.Order
+	cancelled []string
+	placeErr  error
+	cancelErr error
+}
+
+func (a *guardAccount) client() *staketest.MockClient {
+	return &staketest.MockClient{
+		GetEquityPositionsFunc: func() (*stakego.EquityPositions, error) {
+			return &stakego.EquityPositions{EquityPositions: a.positions}, nil
+		},
+		GetOrdersFunc: func() (*[]stakego.OrderDetails, error) {
+			orders := append([]stakego.OrderDetails{}, a.orders...)
+			return &orders, nil
+		},
+		PlaceOrderFunc: func(order stakego.Order) (*stakego.OrderResponse, error) {
+			if a.placeErr != nil {
+				return nil, a.placeErr
+			}
+			a.placed = append(a.placed, order)
+			resp := &stakego.OrderResponse{}
+			resp.Order.ID = fmt.Sprintf("sell-%d", len(a.placed))
+			return resp, nil
+		},
+		CancelOrderFunc: func(uuid string) error {
+			if a.cancelErr != nil {
+				return a.cancelErr
+			}
+			a.cancelled = append(a.cancelled, uuid)
+			return nil
+		},
+	}
+}
+
+// newGuard - a guard over a, priced by *price, collecting its events
+func newGuard(t *testing.T, a *guardAccount, price *float64) (*stakego.PositionGuard, *[]stakego.GuardEvent) {
+	t.Helper()
+	g, err := stakego.NewPositionGuard(a.client(), filepath.Join(t.TempDir(), "guard.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Prices = func(symbol string) (float64, error) { return *price, nil }
+	events := &[]stakego.GuardEvent{}
+	g.OnEvent = func(ev stakego.GuardEvent) { *events = append(*events, ev) }
+	return g, events
+}
+
+func TestPositionGuardTrigger(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      stakego.GuardRule
+		available int
+		slippage  float64
+		prices    []float64
+		reason    string
+		units     int
+		limit     float64
+		err       bool
+	}{
+		{
+			name:      "stop loss",
+			rule:      stakego.GuardRule{StopPrice: 40},
+			available: 100,
+			prices:    []float64{45, 40.5, 39.5},
+			reason:    stakego.GuardStopLoss,
+			units:     100,
+			limit:     39.5,
+		},
+		{
+			name:      "trailing stop follows the high",
+			rule:      stakego.GuardRule{TrailingPercent: 10},
+			available: 100,
+			prices:    []float64{50, 55, 49.6, 49.5},
+			reason:    stakego.GuardTrailingStop,
+			units:     100,
+			limit:     49.5,
+		},
+		{
+			name:      "take profit",
+			rule:      stakego.GuardRule{TakeProfitPrice: 60, Units: 30},
+			available: 100,
+			prices:    []float64{50, 59.99, 60.2},
+			reason:    stakego.GuardTakeProfit,
+			units:     30,
+			limit:     60.2,
+		},
+		{
+			name:      "slippage",
+			rule:      stakego.GuardRule{StopPrice: 40},
+			available: 100,
+			slippage:  1,
+			prices:    []float64{40},
+			reason:    stakego.GuardStopLoss,
+			units:     100,
+			limit:     39.6,
+		},
+		{
+			name:      "units capped by available",
+			rule:      stakego.GuardRule{StopPrice: 40, Units: 500},
+			available: 60,
+			prices:    []float64{39},
+			reason:    stakego.GuardStopLoss,
+			units:     60,
+			limit:     39,
+		},
+		{
+			name:      "nothing available",
+			rule:      stakego.GuardRule{StopPrice: 40},
+			available: 0,
+			prices:    []float64{39},
+			reason:    stakego.GuardStopLoss,
+			err:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &guardAccount{positions: []stakego.EquityPositionItem{{Symbol: "BHP", OpenQty: 100, AvailableForTradingQty: tt.available}}}
+			var price float64
+			g, events := newGuard(t, a, &price)
+			g.SlippagePercent = tt.slippage
+			tt.rule.Symbol = "BHP"
+			if err := g.SetRule(tt.rule); err != nil {
+				t.Fatal(err)
+			}
+			for n, p := range tt.prices {
+				price = p
+				if err := g.Check(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				if n < len(tt.prices)-1 && (len(*events) > 0 || len(a.placed) > 0) {
+					t.Fatalf("triggered early at %.2f: %+v", p, *events)
+				}
+			}
+			if len(*events) != 1 || (*events)[0].Reason != tt.reason || ((*events)[0].Err != nil) != tt.err {
+				t.Fatalf("events = %+v, want one %s", *events, tt.reason)
+			}
+			if tt.err {
+				if len(a.placed) != 0 || g.Rules()[0].Triggered {
+					t.Errorf("placed %+v and triggered %v, want nothing", a.placed, g.Rules()[0].Triggered)
+				}
+				return
+			}
+			if len(a.placed) != 1 {
+				t.Fatalf("placed %+v, want one sell", a.placed)
+			}
+			o := a.placed[0]
+			if o.Side != stakego.OrderSELL || o.Units != tt.units || o.Price != tt.limit {
+				t.Errorf("sell = %s %d @ %.3f, want SELL %d @ %.3f", o.Side, o.Units, o.Price, tt.units, tt.limit)
+			}
+			s := g.Rules()[0]
+			if !s.Triggered || s.Reason != tt.reason || s.OrderID != "sell-1" {
+				t.Errorf("state = %+v, want triggered by %s with order sell-1", s, tt.reason)
+			}
+		})
+	}
+}
+
+func TestPositionGuardRearm(t *testing.T) {
+	a := &guardAccount{positions: []stakego.EquityPositionItem{{Symbol: "BHP", OpenQty: 100, AvailableForTradingQty: 100}}}
+	price := 39.0
+	g, events := newGuard(t, a, &price)
+	if err := g.SetRule(stakego.GuardRule{Symbol: "BHP", StopPrice: 40}); err != nil {
+		t.Fatal(err)
+	}
+	check := func() {
+		t.Helper()
+		if err := g.Check(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check()
+	if len(a.placed) != 1 {
+		t.Fatalf("placed %d orders, want 1", len(a.placed))
+	}
+
+	// pending, then expired without filling while the position is still held
+	a.orders = []stakego.OrderDetails{{ID: "sell-1", InstrumentCode: "BHP", Side: stakego.OrderSELL, OrderStatus: stakego.OrderStatusOpen, UnitsRequested: 100}}
+	check()
+	if !g.Rules()[0].Triggered || len(a.placed) != 1 {
+		t.Fatalf("rule re-armed while its order was pending")
+	}
+	a.orders[0].OrderStatus = stakego.OrderStatusExpired
+	price = 41
+	check()
+	last := (*events)[len(*events)-1]
+	if last.Reason != stakego.GuardRearmed || last.OrderID != "sell-1" || g.Rules()[0].Triggered {
+		t.Fatalf("last event = %+v, triggered %v, want re-armed", last, g.Rules()[0].Triggered)
+	}
+
+	// triggers again once the price is back under the stop
+	price = 39
+	check()
+	if len(a.placed) != 2 || g.Rules()[0].OrderID != "sell-2" {
+		t.Errorf("placed %d orders, order %s, want a second sell", len(a.placed), g.Rules()[0].OrderID)
+	}
+
+	// a sell that filled isn't re-armed
+	a.orders = []stakego.OrderDetails{{ID: "sell-2", InstrumentCode: "BHP", Side: stakego.OrderSELL, OrderStatus: stakego.OrderStatusFilled, UnitsRequested: 100, FilledUnits: 100}}
+	a.positions = nil
+	check()
+	if !g.Rules()[0].Triggered {
+		t.Error("rule re-armed after its sell filled")
+	}
+}
+
+func TestPositionGuardPlaceFailureRearms(t *testing.T) {
+	a := &guardAccount{positions: []stakego.EquityPositionItem{{Symbol: "BHP", OpenQty: 100, AvailableForTradingQty: 100}}, placeErr: errors.New("market closed")}
+	price := 39.0
+	g, events := newGuard(t, a, &price)
+	if err := g.SetRule(stakego.GuardRule{Symbol: "BHP", StopPrice: 40}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(*events) != 1 || (*events)[0].Err == nil || g.Rules()[0].Triggered {
+		t.Fatalf("events = %+v, triggered %v, want a failed sell and the rule re-armed", *events, g.Rules()[0].Triggered)
+	}
+
+	a.placeErr = nil
+	if err := g.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.placed) != 1 || !g.Rules()[0].Triggered {
+		t.Errorf("placed %d orders after the failure, want 1", len(a.placed))
+	}
+}
+
+func TestPositionGuardOCO(t *testing.T) {
+	pending := func(id string, price float64) stakego.OrderDetails {
+		return stakego.OrderDetails{ID: id, InstrumentCode: "BHP", Side: stakego.OrderSELL, OrderStatus: stakego.OrderStatusOpen, UnitsRequested: 100, LimitPrice: price}
+	}
+	tests := []struct {
+		name      string
+		then      func(a *guardAccount)
+		cancelErr error
+		cancelled []string
+		reason    string
+		done      bool
+	}{
+		{
+			name: "fill reported",
+			then: func(a *guardAccount) {
+				a.orders[0].OrderStatus = stakego.OrderStatusFilled
+			},
+			cancelled: []string{"stop"},
+			reason:    stakego.GuardOCOCancelled,
+			done:      true,
+		},
+		{
+			name: "left the list and the position fell",
+			then: func(a *guardAccount) {
+				a.orders = a.orders[1:]
+				a.positions = nil
+			},
+			cancelled: []string{"stop"},
+			reason:    stakego.GuardOCOCancelled,
+			done:      true,
+		},
+		{
+			name: "cancelled",
+			then: func(a *guardAccount) {
+				a.orders[0].OrderStatus = stakego.OrderStatusCancelled
+			},
+			reason: stakego.GuardOCOEnded,
+			done:   true,
+		},
+		{
+			name: "left the list without filling",
+			then: func(a *guardAccount) {
+				a.orders = a.orders[1:]
+			},
+			reason: stakego.GuardOCOEnded,
+			done:   true,
+		},
+		{
+			name: "cancel failed",
+			then: func(a *guardAccount) {
+				a.orders[0].OrderStatus = stakego.OrderStatusFilled
+			},
+			cancelErr: errors.New("order not found"),
+			reason:    stakego.GuardOCOCancelled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &guardAccount{
+				positions: []stakego.EquityPositionItem{{Symbol: "BHP", OpenQty: 100, AvailableForTradingQty: 0}},
+				orders:    []stakego.OrderDetails{pending("tp", 60), pending("stop", 40)},
+			}
+			price := 50.0
+			g, events := newGuard(t, a, &price)
+			if err := g.AddOCO("tp", "stop"); err != nil {
+				t.Fatal(err)
+			}
+			if err := g.Check(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if len(*events) != 0 || len(a.cancelled) != 0 {
+				t.Fatalf("acted on pending orders: %+v", *events)
+			}
+
+			tt.then(a)
+			a.cancelErr = tt.cancelErr
+			if err := g.Check(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(a.cancelled) != fmt.Sprint(tt.cancelled) {
+				t.Errorf("cancelled %v, want %v", a.cancelled, tt.cancelled)
+			}
+			if len(*events) != 1 || (*events)[0].Reason != tt.reason {
+				t.Fatalf("events = %+v, want one %s", *events, tt.reason)
+			}
+			if tt.cancelErr != nil && (*events)[0].Err == nil {
+				t.Error("cancel failure not reported")
+			}
+
+			// a finished pair is left alone, a reopened one is retried
+			a.cancelErr = nil
+			*events = nil
+			if err := g.Check(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if tt.done && len(*events) != 0 {
+				t.Errorf("finished pair acted again: %+v", *events)
+			}
+			if !tt.done && (len(a.cancelled) != 1 || a.cancelled[0] != "stop") {
+				t.Errorf("cancelled %v after the failure, want the stop retried", a.cancelled)
+			}
+		})
+	}
+}
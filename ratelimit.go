@@ -0,0 +1,38 @@
+package stakego
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter - spaces out requests so no more than one is sent per interval
+type rateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// wait - block until the next request is allowed
+func (r *rateLimiter) wait() {
+	r.mutex.Lock()
+	now := time.Now()
+	at := r.next
+	if at.Before(now) {
+		at = now
+	}
+	r.next = at.Add(r.interval)
+	r.mutex.Unlock()
+
+	time.Sleep(time.Until(at))
+}
+
+// WithRateLimit - limit authenticated API requests to perSecond requests per second
+func WithRateLimit(perSecond float64) ClientOption {
+	return func(c *ASXClient) {
+		if perSecond <= 0 {
+			c.limiter = nil
+			return
+		}
+		c.limiter = &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+	}
+}
@@ -0,0 +1,306 @@
+package stakego
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ASXMinimumParcel - minimum value of an initial purchase of a new holding on the ASX
+const ASXMinimumParcel = 500.0
+
+// Quotes - latest prices by symbol
+type Quotes map[string]float64
+
+// BrokerageFunc - returns the brokerage charged on an order of the given value
+type BrokerageFunc func(amount float64) (float64, error)
+
+// NewRebalancer - create a Rebalancer that uses the client for positions,
+// cash, brokerage and placing orders
+func NewRebalancer(c Client) *Rebalancer {
+	r := Rebalancer{}
+	r.client = c
+	r.Targets = make(map[string]float64)
+	r.Quotes = make(Quotes)
+	r.DriftThreshold = 0.02
+	r.MinParcel = ASXMinimumParcel
+	r.Brokerage = brokerageFrom(c)
+	return &r
+}
+
+// Rebalancer - produces an OrderPlan that moves a portfolio towards target weights
+type Rebalancer struct {
+	Targets        map[string]float64 // target weight per symbol, as a fraction of Investable; must sum to at most 1
+	Quotes         Quotes             // prices to use, held positions default to their MktPrice
+	DriftThreshold float64            // only trade symbols whose weight is off by more than this
+	CashBuffer     float64            // dollars to leave uninvested
+	MinParcel      float64            // minimum value of a buy that opens a new holding
+	MinOrderValue  float64            // minimum value of any order
+	SellUntargeted bool               // sell holdings that have no target weight
+	Brokerage      BrokerageFunc
+
+	client Client
+}
+
+// PlannedOrder - a single order in an OrderPlan
+type PlannedOrder struct {
+	Order         Order
+	Value         float64
+	Brokerage     float64
+	CurrentWeight float64
+	TargetWeight  float64
+}
+
+// OrderPlan - the orders required to rebalance
+type OrderPlan struct {
+	Orders         []PlannedOrder // sells first, then buys
+	TotalValue     float64        // portfolio value, including cash
+	Investable     float64        // TotalValue less CashBuffer, which weights are measured against
+	CashBefore     float64
+	CashAfter      float64 // estimated cash after all orders fill
+	TotalBrokerage float64
+	Skipped        map[string]string // symbol -> reason it wasn't traded
+}
+
+// PlanResult - the outcome of placing a PlannedOrder
+type PlanResult struct {
+	Planned  PlannedOrder
+	Response *OrderResponse
+	Err      error
+}
+
+// Plan - fetch positions and cash from the client and plan the rebalance
+func (r *Rebalancer) Plan(ctx context.Context) (*OrderPlan, error) {
+	positions, err := r.client.GetEquityPositions()
+	if err != nil {
+		return nil, NewStakeError("rebalance", err)
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, NewStakeError("rebalance", err)
+	}
+	cash, err := r.client.GetCash()
+	if err != nil {
+		return nil, NewStakeError("rebalance", err)
+	}
+	return r.PlanFrom(positions, cash)
+}
+
+// PlanFrom - plan the rebalance from the given positions and cash. Buys
+// are sized from the current buying power only, as sell proceeds aren't
+// available until the sells fill; plan again once they have to invest them.
+func (r *Rebalancer) PlanFrom(positions *EquityPositions, cash *Cash) (*OrderPlan, error) {
+	total := 0.0
+	for s, w := range r.Targets {
+		if w < 0 {
+			return nil, NewStakeError("rebalance", fmt.Errorf("target weight for %s is negative", s))
+		}
+		total += w
+	}
+	if total > 1+1e-9 {
+		return nil, NewStakeError("rebalance", fmt.Errorf("target weights sum to %.4f, more than 1", total))
+	}
+
+	plan := OrderPlan{}
+	plan.Skipped = make(map[string]string)
+	plan.CashBefore = cash.BuyingPower
+
+	held := make(map[string]EquityPositionItem)
+	for _, ep := range positions.EquityPositions {
+		held[ep.Symbol] = ep
+	}
+
+	symbols := []string{}
+	for s := range r.Targets {
+		symbols = append(symbols, s)
+	}
+	for s := range held {
+		if _, ok := r.Targets[s]; !ok && r.SellUntargeted {
+			symbols = append(symbols, s)
+		}
+	}
+	sort.Strings(symbols)
+
+	price := func(symbol string) float64 {
+		if p, ok := r.Quotes[symbol]; ok && p > 0 {
+			return p
+		}
+		return held[symbol].MktPrice
+	}
+
+	plan.TotalValue = cash.BuyingPower
+	for _, ep := range positions.EquityPositions {
+		plan.TotalValue += float64(ep.OpenQty) * price(ep.Symbol)
+	}
+	investable := plan.TotalValue - r.CashBuffer
+	plan.Investable = investable
+	if plan.TotalValue <= 0 || investable <= 0 {
+		return nil, NewStakeError("rebalance", fmt.Errorf("nothing to invest"))
+	}
+
+	type delta struct {
+		symbol  string
+		price   float64
+		current float64
+		target  float64
+		weight  float64
+		tweight float64
+	}
+	var sells, buys []delta
+	for _, s := range symbols {
+		p := price(s)
+		if p <= 0 {
+			plan.Skipped[s] = "no price"
+			continue
+		}
+		d := delta{symbol: s, price: p, tweight: r.Targets[s]}
+		d.current = float64(held[s].OpenQty) * p
+		d.target = d.tweight * investable
+		d.weight = d.current / investable
+		if math.Abs(d.weight-d.tweight) <= r.DriftThreshold {
+			plan.Skipped[s] = "within drift threshold"
+			continue
+		}
+		if d.current > d.target {
+			sells = append(sells, d)
+		} else {
+			buys = append(buys, d)
+		}
+	}
+
+	available := cash.BuyingPower - r.CashBuffer
+	proceeds := 0.0
+	for _, d := range sells {
+		limit := RoundToTick(d.price, OrderSELL)
+		units := int(math.Floor((d.current - d.target) / limit))
+		if units > held[d.symbol].AvailableForTradingQty {
+			units = held[d.symbol].AvailableForTradingQty
+		}
+		if units <= 0 {
+			plan.Skipped[d.symbol] = "no units available to sell"
+			continue
+		}
+		value := float64(units) * limit
+		if value < r.MinOrderValue {
+			plan.Skipped[d.symbol] = "below minimum order value"
+			continue
+		}
+		fee, err := r.brokerage(value)
+		if err != nil {
+			return nil, NewStakeError("rebalance", err)
+		}
+		plan.add(OrderSELL, d.symbol, units, limit, value, fee, d.weight, d.tweight)
+		proceeds += value - fee
+	}
+
+	// most underweight first, so they get the cash if it's short
+	sort.SliceStable(buys, func(i, j int) bool {
+		return buys[i].tweight-buys[i].weight > buys[j].tweight-buys[j].weight
+	})
+	for _, d := range buys {
+		limit := RoundToTick(d.price, OrderBUY)
+		want := d.target - d.current
+		if want > available {
+			want = available
+		}
+		units := int(math.Floor(want / limit))
+		var fee float64
+		for units > 0 {
+			var err error
+			fee, err = r.brokerage(float64(units) * limit)
+			if err != nil {
+				return nil, NewStakeError("rebalance", err)
+			}
+			if float64(units)*limit+fee <= available {
+				break
+			}
+			units = int(math.Floor((available - fee) / limit))
+		}
+		value := float64(units) * limit
+		switch {
+		case units <= 0 && proceeds > 0:
+			plan.Skipped[d.symbol] = "insufficient cash until sells fill"
+			continue
+		case units <= 0:
+			plan.Skipped[d.symbol] = "insufficient cash"
+			continue
+		case d.current == 0 && value < r.MinParcel:
+			plan.Skipped[d.symbol] = "below minimum parcel"
+			continue
+		case value < r.MinOrderValue:
+			plan.Skipped[d.symbol] = "below minimum order value"
+			continue
+		}
+		plan.add(OrderBUY, d.symbol, units, limit, value, fee, d.weight, d.tweight)
+		available -= value + fee
+	}
+
+	plan.CashAfter = available + proceeds + r.CashBuffer
+	return &plan, nil
+}
+
+// brokerage - get the brokerage for an order value, zero if no BrokerageFunc is set
+func (r *Rebalancer) brokerage(value float64) (float64, error) {
+	if r.Brokerage == nil {
+		return 0, nil
+	}
+	return r.Brokerage(value)
+}
+
+// add - append an order to the plan
+func (p *OrderPlan) add(side string, symbol string, units int, price float64, value float64, fee float64, weight float64, target float64) {
+	o := NewBuyOrder()
+	if side == OrderSELL {
+		o = NewSellOrder()
+	}
+	o.InstrumentCode = symbol
+	o.Units = units
+	o.Price = price
+	p.Orders = append(p.Orders, PlannedOrder{
+		Order:         *o,
+		Value:         value,
+		Brokerage:     fee,
+		CurrentWeight: weight,
+		TargetWeight:  target,
+	})
+	p.TotalBrokerage += fee
+}
+
+// String - a human readable preview of the plan
+func (p *OrderPlan) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-4s %-8s %8s %10s %12s %9s %8s %8s\n", "SIDE", "SYMBOL", "UNITS", "PRICE", "VALUE", "BROKERAGE", "WEIGHT", "TARGET")
+	for _, po := range p.Orders {
+		o := po.Order
+		fmt.Fprintf(&sb, "%-4s %-8s %8d %10.3f %12.2f %9.2f %7.2f%% %7.2f%%\n",
+			o.Side, o.InstrumentCode, o.Units, o.Price, po.Value, po.Brokerage, po.CurrentWeight*100, po.TargetWeight*100)
+	}
+	fmt.Fprintf(&sb, "cash before $%.2f, after $%.2f, brokerage $%.2f\n", p.CashBefore, p.CashAfter, p.TotalBrokerage)
+	skipped := make([]string, 0, len(p.Skipped))
+	for s := range p.Skipped {
+		skipped = append(skipped, s)
+	}
+	sort.Strings(skipped)
+	for _, s := range skipped {
+		fmt.Fprintf(&sb, "skipped %s: %s\n", s, p.Skipped[s])
+	}
+	return sb.String()
+}
+
+// Execute - place the plan's orders, sells first. Execution stops at the
+// first failure or if ctx is cancelled; the results of attempted orders are returned.
+func (r *Rebalancer) Execute(ctx context.Context, plan *OrderPlan) ([]PlanResult, error) {
+	results := []PlanResult{}
+	for _, po := range plan.Orders {
+		if err := ctx.Err(); err != nil {
+			return results, NewStakeError("rebalance", err)
+		}
+		resp, err := r.client.PlaceOrder(po.Order)
+		results = append(results, PlanResult{Planned: po, Response: resp, Err: err})
+		if err != nil {
+			return results, NewStakeError("rebalance", err)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,183 @@
+package stakego_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mdusher/stakego"
+	"github.com/mdusher/stakego/staketest"
+)
+
+// flatBrokerage - a mock client charging $3 on every order
+func flatBrokerage() *staketest.MockClient {
+	return &staketest.MockClient{
+		GetBrokerageFunc: func(price float64) (*stakego.Brokerage, error) {
+			return &stakego.Brokerage{BrokerageFee: 3}, nil
+		},
+	}
+}
+
+// held - a position of units at price, all available to trade
+func held(symbol string, units int, price float64) stakego.EquityPositionItem {
+	return stakego.EquityPositionItem{Symbol: symbol, OpenQty: units, AvailableForTradingQty: units, MktPrice: price}
+}
+
+func TestRebalancerPlanFrom(t *testing.T) {
+	tests := []struct {
+		name      string
+		targets   map[string]float64
+		quotes    stakego.Quotes
+		positions []stakego.EquityPositionItem
+		cash      float64
+		buffer    float64
+		untarget  bool
+		orders    []string
+		skipped   map[string]string
+		cashAfter float64
+		err       string
+	}{
+		{
+			name:      "invest cash",
+			targets:   map[string]float64{"BHP": 0.5, "VAS": 0.5},
+			quotes:    stakego.Quotes{"BHP": 50, "VAS": 100},
+			cash:      10000,
+			orders:    []string{"BUY BHP 100 @ 50.00", "BUY VAS 49 @ 100.00"},
+			cashAfter: 94,
+		},
+		{
+			name:      "sell before buying",
+			targets:   map[string]float64{"BHP": 0.5, "VAS": 0.5},
+			quotes:    stakego.Quotes{"VAS": 100},
+			positions: []stakego.EquityPositionItem{held("BHP", 200, 50)},
+			orders:    []string{"SELL BHP 100 @ 50.00"},
+			skipped:   map[string]string{"VAS": "insufficient cash until sells fill"},
+			cashAfter: 4997,
+		},
+		{
+			name:      "within drift threshold",
+			targets:   map[string]float64{"BHP": 0.51},
+			positions: []stakego.EquityPositionItem{held("BHP", 100, 50)},
+			cash:      5000,
+			skipped:   map[string]string{"BHP": "within drift threshold"},
+			cashAfter: 5000,
+		},
+		{
+			name:      "below minimum parcel",
+			targets:   map[string]float64{"BHP": 0.4},
+			quotes:    stakego.Quotes{"BHP": 50},
+			cash:      1000,
+			skipped:   map[string]string{"BHP": "below minimum parcel"},
+			cashAfter: 1000,
+		},
+		{
+			name:      "no price",
+			targets:   map[string]float64{"XYZ": 0.5},
+			cash:      1000,
+			skipped:   map[string]string{"XYZ": "no price"},
+			cashAfter: 1000,
+		},
+		{
+			name:      "cash buffer and brokerage",
+			targets:   map[string]float64{"BHP": 1},
+			quotes:    stakego.Quotes{"BHP": 50},
+			cash:      10000,
+			buffer:    1000,
+			orders:    []string{"BUY BHP 179 @ 50.00"},
+			cashAfter: 1047,
+		},
+		{
+			name:      "sell untargeted",
+			targets:   map[string]float64{},
+			positions: []stakego.EquityPositionItem{held("CBA", 10, 100)},
+			untarget:  true,
+			orders:    []string{"SELL CBA 10 @ 100.00"},
+			cashAfter: 997,
+		},
+		{
+			name:      "keep untargeted",
+			targets:   map[string]float64{"BHP": 0.5},
+			positions: []stakego.EquityPositionItem{held("CBA", 10, 100), held("BHP", 20, 50)},
+			skipped:   map[string]string{"BHP": "within drift threshold"},
+		},
+		{
+			name:    "weights over 1",
+			targets: map[string]float64{"BHP": 0.6, "VAS": 0.5},
+			cash:    1000,
+			err:     "more than 1",
+		},
+		{
+			name:    "negative weight",
+			targets: map[string]float64{"BHP": -0.1},
+			cash:    1000,
+			err:     "negative",
+		},
+		{
+			name:    "nothing to invest",
+			targets: map[string]float64{"BHP": 1},
+			err:     "nothing to invest",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := stakego.NewRebalancer(flatBrokerage())
+			r.Targets = tt.targets
+			if tt.quotes != nil {
+				r.Quotes = tt.quotes
+			}
+			r.CashBuffer = tt.buffer
+			r.SellUntargeted = tt.untarget
+			plan, err := r.PlanFrom(&stakego.EquityPositions{EquityPositions: tt.positions}, &stakego.Cash{BuyingPower: tt.cash})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("PlanFrom = %v, want an error containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PlanFrom: %v", err)
+			}
+			orders := []string{}
+			for _, po := range plan.Orders {
+				orders = append(orders, fmt.Sprintf("%s %s %d @ %.2f", po.Order.Side, po.Order.InstrumentCode, po.Order.Units, po.Order.Price))
+			}
+			if strings.Join(orders, ", ") != strings.Join(tt.orders, ", ") {
+				t.Errorf("orders = %v, want %v", orders, tt.orders)
+			}
+			if len(plan.Skipped) != len(tt.skipped) {
+				t.Errorf("skipped = %v, want %v", plan.Skipped, tt.skipped)
+			}
+			for s, reason := range tt.skipped {
+				if plan.Skipped[s] != reason {
+					t.Errorf("skipped %s = %q, want %q", s, plan.Skipped[s], reason)
+				}
+			}
+			if diff := plan.CashAfter - tt.cashAfter; diff > 0.005 || diff < -0.005 {
+				t.Errorf("CashAfter = %.2f, want %.2f", plan.CashAfter, tt.cashAfter)
+			}
+		})
+	}
+}
+
+func TestRebalancerPlan(t *testing.T) {
+	m := flatBrokerage()
+	m.GetEquityPositionsFunc = func() (*stakego.EquityPositions, error) {
+		return &stakego.EquityPositions{EquityPositions: []stakego.EquityPositionItem{held("BHP", 100, 50)}}, nil
+	}
+	m.GetCashFunc = func() (*stakego.Cash, error) {
+		return &stakego.Cash{BuyingPower: 5000}, nil
+	}
+	r := stakego.NewRebalancer(m)
+	r.Targets = map[string]float64{"BHP": 1}
+	plan, err := r.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	if len(plan.Orders) != 1 || plan.Orders[0].Order.Units != 99 || plan.TotalValue != 10000 {
+		t.Errorf("plan = %+v, want 99 BHP from a total of 10000", plan)
+	}
+	if m.Calls("GetBrokerage") == 0 {
+		t.Error("brokerage was not taken from the client")
+	}
+}
@@ -0,0 +1,438 @@
+package stakego
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrOrderRejected - matched by errors.Is for any RiskRejection
+var ErrOrderRejected = NewStakeError("", fmt.Errorf("order rejected by risk policy"))
+
+// RiskRejection - returned when a RiskPolicy blocks an order
+type RiskRejection struct {
+	Rule   string
+	Reason string
+	Order  Order
+}
+
+// Error - error compatible message
+func (e *RiskRejection) Error() string {
+	return fmt.Sprintf("order rejected by %s: %s", e.Rule, e.Reason)
+}
+
+// Is - allows errors.Is(err, ErrOrderRejected)
+func (e *RiskRejection) Is(target error) bool {
+	return target == ErrOrderRejected
+}
+
+// RiskPolicy - a pre-trade check run before an order is sent to Stake
+type RiskPolicy interface {
+	Name() string
+	Check(ctx context.Context, rc *RiskCheck) error
+}
+
+// RiskReleaser - implemented by policies that reserve part of a limit when
+// an order passes Check, and give it back if the order isn't placed
+type RiskReleaser interface {
+	Release(order Order)
+}
+
+// newRiskCheck - create a RiskCheck for an order
+func newRiskCheck(c *ASXClient, order Order) *RiskCheck {
+	rc := RiskCheck{}
+	rc.Order = order
+	rc.client = c
+	return &rc
+}
+
+// RiskCheck - the order being checked, with lazily fetched account data
+// shared between the policies in a chain
+type RiskCheck struct {
+	Order Order
+
+	client     *ASXClient
+	cash       *Cash
+	positions  *EquityPositions
+	instrument *Instrument
+}
+
+// Value - the notional value of the order
+func (rc *RiskCheck) Value() float64 {
+	return float64(rc.Order.Units) * rc.Order.Price
+}
+
+// Cash - the account's cash, fetched once per check
+func (rc *RiskCheck) Cash() (*Cash, error) {
+	if rc.cash == nil {
+		c, err := rc.client.GetCash()
+		if err != nil {
+			return nil, err
+		}
+		rc.cash = c
+	}
+	return rc.cash, nil
+}
+
+// Positions - the account's positions, fetched once per check
+func (rc *RiskCheck) Positions() (*EquityPositions, error) {
+	if rc.positions == nil {
+		p, err := rc.client.GetEquityPositions()
+		if err != nil {
+			return nil, err
+		}
+		rc.positions = p
+	}
+	return rc.positions, nil
+}
+
+// Position - the position held in the order's instrument, if any
+func (rc *RiskCheck) Position() (*EquityPositionItem, error) {
+	p, err := rc.Positions()
+	if err != nil {
+		return nil, err
+	}
+	for i := range p.EquityPositions {
+		if p.EquityPositions[i].Symbol == rc.Order.InstrumentCode {
+			return &p.EquityPositions[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Instrument - the order's instrument, fetched once per check
+func (rc *RiskCheck) Instrument() (*Instrument, error) {
+	if rc.instrument == nil {
+		i, err := rc.client.LookupInstrument(rc.Order.InstrumentCode)
+		if err != nil {
+			return nil, err
+		}
+		rc.instrument = i
+	}
+	return rc.instrument, nil
+}
+
+// reject - create a RiskRejection for this check
+func (rc *RiskCheck) reject(rule string, format string, args ...interface{}) error {
+	return &RiskRejection{Rule: rule, Reason: fmt.Sprintf(format, args...), Order: rc.Order}
+}
+
+// checkRisk - run the kill switch and risk policies against an order
+func (c *ASXClient) checkRisk(ctx context.Context, order Order) (err error) {
+	defer func() {
+		if r, ok := IsRiskRejection(err); ok && c.OnRiskRejection != nil {
+			c.OnRiskRejection(r)
+		}
+	}()
+
+	rc := newRiskCheck(c, order)
+	if c.KillSwitch != nil {
+		err = c.KillSwitch.Check(ctx, rc)
+		if err != nil {
+			return err
+		}
+	}
+	for i, p := range c.RiskPolicies {
+		err = p.Check(ctx, rc)
+		if err != nil {
+			c.releaseRisk(order, c.RiskPolicies[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseRisk - tell policies that passed an order that it wasn't placed
+func (c *ASXClient) releaseRisk(order Order, policies []RiskPolicy) {
+	for _, p := range policies {
+		if r, ok := p.(RiskReleaser); ok {
+			r.Release(order)
+		}
+	}
+}
+
+// NewKillSwitch - create a KillSwitch. If path is set, the switch is also
+// engaged whenever that file exists.
+func NewKillSwitch(path string) *KillSwitch {
+	k := KillSwitch{}
+	k.Path = path
+	return &k
+}
+
+// KillSwitch - blocks all new orders while engaged
+type KillSwitch struct {
+	Path string
+
+	mutex   sync.Mutex
+	engaged bool
+	reason  string
+}
+
+// Engage - block all new orders
+func (k *KillSwitch) Engage(reason string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.engaged = true
+	k.reason = reason
+}
+
+// Release - allow orders again. The flag file, if any, must be removed separately.
+func (k *KillSwitch) Release() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.engaged = false
+	k.reason = ""
+}
+
+// Engaged - checks if the switch is engaged, by API call or flag file
+func (k *KillSwitch) Engaged() (bool, string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.engaged {
+		return true, k.reason
+	}
+	if k.Path != "" {
+		if b, err := os.ReadFile(k.Path); err == nil {
+			reason := strings.TrimSpace(string(b))
+			if reason == "" {
+				reason = "flag file " + k.Path + " exists"
+			}
+			return true, reason
+		}
+	}
+	return false, ""
+}
+
+// Name - the name of the policy
+func (k *KillSwitch) Name() string {
+	return "kill switch"
+}
+
+// Check - reject every order while engaged
+func (k *KillSwitch) Check(ctx context.Context, rc *RiskCheck) error {
+	if engaged, reason := k.Engaged(); engaged {
+		return rc.reject(k.Name(), "%s", reason)
+	}
+	return nil
+}
+
+// RiskPolicyFunc - adapts a function to a RiskPolicy
+type RiskPolicyFunc struct {
+	PolicyName string
+	Fn         func(ctx context.Context, rc *RiskCheck) error
+}
+
+// Name - the name of the policy
+func (f RiskPolicyFunc) Name() string {
+	return f.PolicyName
+}
+
+// Check - call the function
+func (f RiskPolicyFunc) Check(ctx context.Context, rc *RiskCheck) error {
+	return f.Fn(ctx, rc)
+}
+
+// MaxOrderNotional - reject orders worth more than max
+func MaxOrderNotional(max float64) RiskPolicy {
+	name := "max order notional"
+	return RiskPolicyFunc{name, func(ctx context.Context, rc *RiskCheck) error {
+		if rc.Value() > max {
+			return rc.reject(name, "order value $%.2f exceeds $%.2f", rc.Value(), max)
+		}
+		return nil
+	}}
+}
+
+// MaxPositionWeight - reject buys that would make a position more than max
+// (a fraction) of the portfolio's value
+func MaxPositionWeight(max float64) RiskPolicy {
+	name := "max position weight"
+	return RiskPolicyFunc{name, func(ctx context.Context, rc *RiskCheck) error {
+		if rc.Order.Side != OrderBUY {
+			return nil
+		}
+		positions, err := rc.Positions()
+		if err != nil {
+			return err
+		}
+		cash, err := rc.Cash()
+		if err != nil {
+			return err
+		}
+		pos, _ := rc.Position()
+		current := 0.0
+		if pos != nil {
+			current = pos.MarketValue
+		}
+		total := positions.GetTotal() + cash.BuyingPower
+		if total <= 0 {
+			return rc.reject(name, "portfolio value is zero")
+		}
+		weight := (current + rc.Value()) / total
+		if weight > max {
+			return rc.reject(name, "%s would be %.1f%% of the portfolio, limit is %.1f%%", rc.Order.InstrumentCode, weight*100, max*100)
+		}
+		return nil
+	}}
+}
+
+// NewDailyTradedValueCap - create a policy that limits the total value of
+// orders placed each day
+func NewDailyTradedValueCap(max float64) *DailyTradedValueCap {
+	d := DailyTradedValueCap{}
+	d.Max = max
+	return &d
+}
+
+// DailyTradedValueCap - rejects orders once the day's traded value would exceed Max
+type DailyTradedValueCap struct {
+	Max float64
+
+	mutex sync.Mutex
+	date  string
+	total float64
+}
+
+// Name - the name of the policy
+func (d *DailyTradedValueCap) Name() string {
+	return "daily traded value cap"
+}
+
+// Traded - the value traded today
+func (d *DailyTradedValueCap) Traded() float64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.date != auToday() {
+		return 0
+	}
+	return d.total
+}
+
+// Check - reject the order if it would take the day's total over Max,
+// otherwise add it to the total so concurrent orders can't both pass
+func (d *DailyTradedValueCap) Check(ctx context.Context, rc *RiskCheck) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	today := auToday()
+	if d.date != today {
+		d.date = today
+		d.total = 0
+	}
+	if d.total+rc.Value() > d.Max {
+		return rc.reject(d.Name(), "$%.2f already traded today, order of $%.2f would exceed $%.2f", d.total, rc.Value(), d.Max)
+	}
+	d.total += rc.Value()
+	return nil
+}
+
+// Release - remove an order that wasn't placed from the day's total
+func (d *DailyTradedValueCap) Release(order Order) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.date != auToday() {
+		return
+	}
+	d.total -= float64(order.Units) * order.Price
+	if d.total < 0 {
+		d.total = 0
+	}
+}
+
+// PriceCollar - reject orders priced more than percent away from the last
+// price. The last price comes from prices if set, otherwise the held position.
+func PriceCollar(percent float64, prices PriceFunc) RiskPolicy {
+	name := "price collar"
+	return RiskPolicyFunc{name, func(ctx context.Context, rc *RiskCheck) error {
+		last := 0.0
+		if prices != nil {
+			p, err := prices(rc.Order.InstrumentCode)
+			if err != nil {
+				return err
+			}
+			last = p
+		} else {
+			pos, err := rc.Position()
+			if err != nil {
+				return err
+			}
+			if pos != nil {
+				last = pos.MktPrice
+			}
+		}
+		if last <= 0 {
+			return rc.reject(name, "no last price for %s", rc.Order.InstrumentCode)
+		}
+		diff := (rc.Order.Price - last) / last * 100
+		if diff > percent || diff < -percent {
+			return rc.reject(name, "price %.3f is %.1f%% from last price %.3f, limit is %.1f%%", rc.Order.Price, diff, last, percent)
+		}
+		return nil
+	}}
+}
+
+// RestrictedSymbols - reject orders in any of the given symbols
+func RestrictedSymbols(symbols ...string) RiskPolicy {
+	name := "restricted symbols"
+	restricted := make(map[string]bool)
+	for _, s := range symbols {
+		restricted[strings.ToUpper(s)] = true
+	}
+	return RiskPolicyFunc{name, func(ctx context.Context, rc *RiskCheck) error {
+		if restricted[strings.ToUpper(rc.Order.InstrumentCode)] {
+			return rc.reject(name, "%s is restricted", rc.Order.InstrumentCode)
+		}
+		return nil
+	}}
+}
+
+// NoSensitiveInstruments - reject orders in instruments flagged as price
+// sensitive or with a recent announcement
+func NoSensitiveInstruments() RiskPolicy {
+	name := "sensitive instrument"
+	return RiskPolicyFunc{name, func(ctx context.Context, rc *RiskCheck) error {
+		i, err := rc.Instrument()
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(i.Symbol, rc.Order.InstrumentCode) {
+			return rc.reject(name, "no instrument found for %s", rc.Order.InstrumentCode)
+		}
+		if i.Sensitive {
+			return rc.reject(name, "%s is flagged as sensitive", rc.Order.InstrumentCode)
+		}
+		if i.RecentAnnouncement {
+			return rc.reject(name, "%s has a recent announcement", rc.Order.InstrumentCode)
+		}
+		return nil
+	}}
+}
+
+// BuyingPowerCheck - reject buys worth more than the account's buying power
+func BuyingPowerCheck() RiskPolicy {
+	name := "buying power"
+	return RiskPolicyFunc{name, func(ctx context.Context, rc *RiskCheck) error {
+		if rc.Order.Side != OrderBUY {
+			return nil
+		}
+		cash, err := rc.Cash()
+		if err != nil {
+			return err
+		}
+		if rc.Value() > cash.BuyingPower {
+			return rc.reject(name, "order value $%.2f exceeds buying power $%.2f", rc.Value(), cash.BuyingPower)
+		}
+		return nil
+	}}
+}
+
+// IsRiskRejection - returns the RiskRejection in err's chain, if any
+func IsRiskRejection(err error) (*RiskRejection, bool) {
+	var r *RiskRejection
+	if errors.As(err, &r) {
+		return r, true
+	}
+	return nil, false
+}
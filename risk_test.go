@@ -0,0 +1,146 @@
+package stakego_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/mdusher/stakego"
+	"github.com/mdusher/stakego/staketest"
+)
+
+// riskServer - a fake account with $10000 of buying power and $2000 of BHP
+func riskServer(t *testing.T) *staketest.Server {
+	t.Helper()
+	s := staketest.NewServer()
+	t.Cleanup(s.Close)
+	s.Update(func(st *staketest.State) {
+		st.Cash.BuyingPower = 8000
+		st.Positions = []stakego.EquityPositionItem{{Symbol: "BHP", OpenQty: 40, AvailableForTradingQty: 40, MktPrice: 50, MarketValue: 2000}}
+	})
+	return s
+}
+
+// riskClient - a logged in client for s with the given policies
+func riskClient(t *testing.T, s *staketest.Server, policies ...stakego.RiskPolicy) *stakego.ASXClient {
+	t.Helper()
+	c := s.NewClient()
+	c.RiskPolicies = policies
+	if err := c.Login(); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	return c
+}
+
+func order(side string, symbol string, units int, price float64) stakego.Order {
+	o := stakego.NewBuyOrder()
+	if side == stakego.OrderSELL {
+		o = stakego.NewSellOrder()
+	}
+	o.InstrumentCode = symbol
+	o.Units = units
+	o.Price = price
+	return *o
+}
+
+func TestRiskPolicies(t *testing.T) {
+	last := func(symbol string) (float64, error) {
+		if symbol == "NEW" {
+			return 0, nil
+		}
+		return 100, nil
+	}
+	tests := []struct {
+		name   string
+		policy stakego.RiskPolicy
+		order  stakego.Order
+		reject bool
+	}{
+		{"collar inside", stakego.PriceCollar(5, last), order(stakego.OrderBUY, "CBA", 1, 104.9), false},
+		{"collar at limit", stakego.PriceCollar(5, last), order(stakego.OrderBUY, "CBA", 1, 95), false},
+		{"collar above", stakego.PriceCollar(5, last), order(stakego.OrderBUY, "CBA", 1, 105.5), true},
+		{"collar below", stakego.PriceCollar(5, last), order(stakego.OrderSELL, "BHP", 1, 94), true},
+		{"collar no price", stakego.PriceCollar(5, last), order(stakego.OrderBUY, "NEW", 1, 10), true},
+		{"collar from position", stakego.PriceCollar(5, nil), order(stakego.OrderSELL, "BHP", 1, 48), false},
+		{"collar from position outside", stakego.PriceCollar(5, nil), order(stakego.OrderSELL, "BHP", 1, 47), true},
+		{"collar without position", stakego.PriceCollar(5, nil), order(stakego.OrderBUY, "VAS", 1, 100), true},
+		{"weight at cap", stakego.MaxPositionWeight(0.25), order(stakego.OrderBUY, "BHP", 10, 50), false},
+		{"weight over cap", stakego.MaxPositionWeight(0.25), order(stakego.OrderBUY, "BHP", 11, 50), true},
+		{"weight new holding", stakego.MaxPositionWeight(0.25), order(stakego.OrderBUY, "VAS", 25, 100), false},
+		{"weight new holding over cap", stakego.MaxPositionWeight(0.25), order(stakego.OrderBUY, "VAS", 26, 100), true},
+		{"weight ignores sells", stakego.MaxPositionWeight(0.1), order(stakego.OrderSELL, "BHP", 40, 50), false},
+		{"notional", stakego.MaxOrderNotional(1000), order(stakego.OrderBUY, "BHP", 21, 50), true},
+		{"restricted", stakego.RestrictedSymbols("bhp"), order(stakego.OrderBUY, "BHP", 1, 50), true},
+		{"buying power", stakego.BuyingPowerCheck(), order(stakego.OrderBUY, "VAS", 81, 100), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := riskServer(t)
+			c := riskClient(t, s, tt.policy)
+			var rejected *stakego.RiskRejection
+			c.OnRiskRejection = func(r *stakego.RiskRejection) { rejected = r }
+
+			_, err := c.PlaceOrder(tt.order)
+			r, isRejection := stakego.IsRiskRejection(err)
+			if isRejection != tt.reject {
+				t.Fatalf("PlaceOrder = %v, want rejected %v", err, tt.reject)
+			}
+			if !tt.reject {
+				if err != nil {
+					t.Fatalf("PlaceOrder: %v", err)
+				}
+				if n := len(s.State().Orders); n != 1 {
+					t.Errorf("%d orders placed, want 1", n)
+				}
+				return
+			}
+			if r.Rule != tt.policy.Name() || !errors.Is(err, stakego.ErrOrderRejected) || rejected != r {
+				t.Errorf("rejection = %+v, OnRiskRejection %+v, want rule %s", r, rejected, tt.policy.Name())
+			}
+			if n := len(s.State().Orders); n != 0 {
+				t.Errorf("%d orders placed, want none", n)
+			}
+		})
+	}
+}
+
+func TestDailyTradedValueCap(t *testing.T) {
+	s := riskServer(t)
+	daily := stakego.NewDailyTradedValueCap(1000)
+	c := riskClient(t, s, daily, stakego.RestrictedSymbols("CBA"))
+
+	steps := []struct {
+		name    string
+		order   stakego.Order
+		fail    bool // the fake server rejects the order
+		reject  bool
+		traded  float64
+		wantErr bool
+	}{
+		{name: "first order", order: order(stakego.OrderBUY, "BHP", 10, 50), traded: 500},
+		{name: "sells count", order: order(stakego.OrderSELL, "BHP", 8, 50), traded: 900},
+		{name: "over the cap", order: order(stakego.OrderBUY, "BHP", 4, 50), reject: true, traded: 900, wantErr: true},
+		{name: "released when the API fails", order: order(stakego.OrderBUY, "BHP", 2, 50), fail: true, traded: 900, wantErr: true},
+		{name: "released when a later policy rejects", order: order(stakego.OrderBUY, "CBA", 1, 100), reject: true, traded: 900, wantErr: true},
+		{name: "up to the cap", order: order(stakego.OrderBUY, "BHP", 2, 50), traded: 1000},
+		{name: "nothing left", order: order(stakego.OrderBUY, "BHP", 1, 0.5), reject: true, traded: 1000, wantErr: true},
+	}
+	for _, step := range steps {
+		if step.fail {
+			s.Fail("/api/asx/orders", http.StatusInternalServerError, 1)
+		}
+		_, err := c.PlaceOrder(step.order)
+		if (err != nil) != step.wantErr {
+			t.Fatalf("%s: PlaceOrder = %v, want error %v", step.name, err, step.wantErr)
+		}
+		if _, rejected := stakego.IsRiskRejection(err); rejected != step.reject {
+			t.Errorf("%s: rejected %v, want %v (%v)", step.name, rejected, step.reject, err)
+		}
+		if got := daily.Traded(); got != step.traded {
+			t.Errorf("%s: traded %.2f, want %.2f", step.name, got, step.traded)
+		}
+	}
+	if n := len(s.State().Orders); n != 3 {
+		t.Errorf("%d orders placed, want 3", n)
+	}
+}
@@ -0,0 +1,398 @@
+package stakego
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Market phases for the ASX trading day
+const MarketPhasePreOpen = "PRE_OPEN"
+const MarketPhaseOpen = MarketStatusOpen
+const MarketPhaseCSPA = "CSPA"
+const MarketPhaseClosed = MarketStatusClosed
+
+// Default ASX session times, used when a day has no early close entry
+var MarketDefaultPreOpenASX = MarketTime{Hour: 7, Minute: 0}
+var MarketDefaultCSPAASX = MarketTime{Hour: 16, Minute: 10}
+
+// sessionLookahead - how many days ahead to search for the next trading session
+const sessionLookahead = 14
+
+// TradingSession - the key times of a single trading day
+type TradingSession struct {
+	Date       time.Time // midnight, Sydney time
+	PreOpen    time.Time
+	Open       time.Time
+	Close      time.Time // end of continuous trading
+	CSPA       time.Time // closing single price auction
+	EarlyClose bool
+}
+
+// GetAULocation - returns the Australia/Sydney time zone
+func GetAULocation() (*time.Location, error) {
+	return time.LoadLocation("Australia/Sydney")
+}
+
+// atTime - returns the given MarketTime on the same day as t
+func atTime(t time.Time, mt MarketTime) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), mt.Hour, mt.Minute, 0, 0, t.Location())
+}
+
+// IsTradingDay - checks if the date is a weekday that isn't a trading holiday
+func (m *Market) IsTradingDay(day time.Time) bool {
+	loc, err := GetAULocation()
+	if err != nil {
+		return false
+	}
+	day = day.In(loc)
+	if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		return false
+	}
+	if m.LocationData != nil {
+		date := day.Format(LocationDataDateFormat)
+		for _, h := range m.LocationData.Calendar.AUTRADING.TradingHolidays {
+			if h.Date == date {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// GetSession - returns the trading session for the given day, or nil
+// if the market doesn't trade that day
+func (m *Market) GetSession(day time.Time) *TradingSession {
+	if !m.IsTradingDay(day) {
+		return nil
+	}
+	loc, err := GetAULocation()
+	if err != nil {
+		return nil
+	}
+	day = day.In(loc)
+
+	s := TradingSession{}
+	s.Date = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+	s.PreOpen = atTime(s.Date, MarketDefaultPreOpenASX)
+	s.Open = atTime(s.Date, MarketDefaultOpenASX)
+	s.Close = atTime(s.Date, MarketDefaultCloseASX)
+	s.CSPA = atTime(s.Date, MarketDefaultCSPAASX)
+
+	if m.LocationData != nil {
+		date := s.Date.Format(LocationDataDateFormat)
+		for _, e := range m.LocationData.Calendar.AUTRADING.EarlyClose {
+			if e.Date != date {
+				continue
+			}
+			parse := func(hm string) (time.Time, error) {
+				return time.ParseInLocation(LocationDataTimeFormat, fmt.Sprintf("%s %s", e.Date, hm), loc)
+			}
+			if t, err := parse(e.TradingOpen); err == nil {
+				s.Open = t
+			}
+			tc, err := parse(e.TradingClose)
+			if err != nil {
+				break
+			}
+			if pc, err := parse(e.PreCspaClose); err == nil && e.PreCspaClose != "" {
+				s.Close = pc
+				s.CSPA = tc
+			} else {
+				s.Close = tc
+				s.CSPA = tc.Add(MarketDefaultCSPAASX.Sub(MarketDefaultCloseASX))
+			}
+			s.EarlyClose = true
+			break
+		}
+	}
+	return &s
+}
+
+// NextSession - returns the first trading session on or after the day of t
+func (m *Market) NextSession(t time.Time) *TradingSession {
+	for i := 0; i < sessionLookahead; i++ {
+		s := m.GetSession(t.AddDate(0, 0, i))
+		if s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// PhaseAt - returns the market phase at the given time
+func (m *Market) PhaseAt(t time.Time) string {
+	s := m.GetSession(t)
+	if s == nil {
+		return MarketPhaseClosed
+	}
+	return s.PhaseAt(t)
+}
+
+// GetPhase - returns the current market phase
+func (m *Market) GetPhase() string {
+	return m.PhaseAt(time.Now())
+}
+
+// PhaseAt - returns the phase of this session at the given time
+func (s *TradingSession) PhaseAt(t time.Time) string {
+	switch {
+	case t.Before(s.PreOpen):
+		return MarketPhaseClosed
+	case t.Before(s.Open):
+		return MarketPhasePreOpen
+	case t.Before(s.Close):
+		return MarketPhaseOpen
+	case t.Before(s.CSPA):
+		return MarketPhaseCSPA
+	}
+	return MarketPhaseClosed
+}
+
+// PhaseStart - returns when the given phase starts in this session
+func (s *TradingSession) PhaseStart(phase string) (time.Time, error) {
+	switch phase {
+	case MarketPhasePreOpen:
+		return s.PreOpen, nil
+	case MarketPhaseOpen:
+		return s.Open, nil
+	case MarketPhaseCSPA:
+		return s.Close, nil
+	case MarketPhaseClosed:
+		return s.CSPA, nil
+	}
+	return time.Time{}, fmt.Errorf("unknown market phase '%s'", phase)
+}
+
+// Sub - duration between two MarketTimes
+func (mt MarketTime) Sub(o MarketTime) time.Duration {
+	return time.Duration((mt.Hour-o.Hour)*60+(mt.Minute-o.Minute)) * time.Minute
+}
+
+// maxWaitInterval - upper bound on a single sleep while waiting, so that
+// calendar updates are picked up
+const maxWaitInterval = time.Hour
+
+// sleepCtx - sleep for d, returning early with an error if ctx is done
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// WaitUntilOpen - block until the market is open for continuous trading
+func (c *ASXClient) WaitUntilOpen(ctx context.Context) error {
+	return c.WaitUntilPhase(ctx, MarketPhaseOpen)
+}
+
+// WaitUntilPhase - block until the market is in the given phase
+func (c *ASXClient) WaitUntilPhase(ctx context.Context, phase string) error {
+	return WaitUntilPhase(ctx, c, phase)
+}
+
+// WaitUntilPhase - block until the market from any MarketDataReader is in the given phase
+func WaitUntilPhase(ctx context.Context, c MarketDataReader, phase string) error {
+	if _, err := (&TradingSession{}).PhaseStart(phase); err != nil {
+		return NewStakeError("wait", err)
+	}
+	for {
+		m, err := c.GetMarket()
+		if err != nil {
+			return NewStakeError("wait", err)
+		}
+		now := time.Now()
+		if m.PhaseAt(now) == phase {
+			return nil
+		}
+
+		wait := maxWaitInterval
+		if next, ok := nextPhaseStart(m, now, phase); ok && next.Sub(now) < wait {
+			wait = next.Sub(now)
+		}
+		err = sleepCtx(ctx, wait)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// nextPhaseStart - find when the phase next starts after t
+func nextPhaseStart(m *Market, t time.Time, phase string) (time.Time, bool) {
+	for i := 0; i < sessionLookahead; i++ {
+		s := m.GetSession(t.AddDate(0, 0, i))
+		if s == nil {
+			continue
+		}
+		start, err := s.PhaseStart(phase)
+		if err == nil && start.After(t) {
+			return start, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// SessionAnchor - a point in the trading session that scheduled jobs are relative to
+type SessionAnchor string
+
+const SessionAnchorPreOpen SessionAnchor = "preopen"
+const SessionAnchorOpen SessionAnchor = "open"
+const SessionAnchorClose SessionAnchor = "close"
+const SessionAnchorCSPA SessionAnchor = "cspa"
+
+// SessionTime - a time relative to a session anchor, e.g. "open+5m"
+type SessionTime struct {
+	Anchor SessionAnchor
+	Offset time.Duration
+}
+
+// ParseSessionTime - parses strings such as "open+5m", "close-15m" or "CSPA"
+func ParseSessionTime(spec string) (SessionTime, error) {
+	st := SessionTime{}
+	s := strings.ToLower(strings.TrimSpace(spec))
+	i := strings.IndexAny(s, "+-")
+	anchor := s
+	if i >= 0 {
+		anchor = s[:i]
+		d, err := time.ParseDuration(s[i:])
+		if err != nil {
+			return st, fmt.Errorf("invalid session time '%s': %w", spec, err)
+		}
+		st.Offset = d
+	}
+	st.Anchor = SessionAnchor(strings.TrimSpace(anchor))
+	switch st.Anchor {
+	case SessionAnchorPreOpen, SessionAnchorOpen, SessionAnchorClose, SessionAnchorCSPA:
+		return st, nil
+	}
+	return st, fmt.Errorf("invalid session time '%s': unknown anchor '%s'", spec, anchor)
+}
+
+// In - returns the absolute time of this SessionTime in the given session
+func (st SessionTime) In(s *TradingSession) time.Time {
+	var t time.Time
+	switch st.Anchor {
+	case SessionAnchorPreOpen:
+		t = s.PreOpen
+	case SessionAnchorOpen:
+		t = s.Open
+	case SessionAnchorClose:
+		t = s.Close
+	case SessionAnchorCSPA:
+		t = s.CSPA
+	}
+	return t.Add(st.Offset)
+}
+
+// SessionFunc - callback run by the SessionScheduler
+type SessionFunc func(ctx context.Context, session TradingSession)
+
+// sessionJob - a scheduled callback
+type sessionJob struct {
+	spec    string
+	at      SessionTime
+	fn      SessionFunc
+	lastRun time.Time
+}
+
+// NewSessionScheduler - create a SessionScheduler using the client's market calendar
+func NewSessionScheduler(c MarketDataReader) *SessionScheduler {
+	s := SessionScheduler{}
+	s.client = c
+	return &s
+}
+
+// SessionScheduler - runs callbacks at times relative to the trading
+// session, on trading days only
+type SessionScheduler struct {
+	client MarketDataReader
+	mutex  sync.Mutex
+	jobs   []*sessionJob
+}
+
+// Add - schedule fn to run at spec (e.g. "open+5m") on every trading day
+func (s *SessionScheduler) Add(spec string, fn SessionFunc) error {
+	at, err := ParseSessionTime(spec)
+	if err != nil {
+		return NewStakeError("scheduler", err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.jobs = append(s.jobs, &sessionJob{spec: spec, at: at, fn: fn})
+	return nil
+}
+
+// Run - run scheduled jobs until ctx is cancelled. Jobs due at the same
+// time are run sequentially in the order they were added.
+func (s *SessionScheduler) Run(ctx context.Context) error {
+	for {
+		m, err := s.client.GetMarket()
+		if err != nil {
+			return NewStakeError("scheduler", err)
+		}
+
+		now := time.Now()
+		next, due, session := s.nextDue(m, now)
+		wait := maxWaitInterval
+		if session != nil && next.Sub(now) < wait {
+			wait = next.Sub(now)
+		}
+		err = sleepCtx(ctx, wait)
+		if err != nil {
+			return err
+		}
+		if session == nil || time.Now().Before(next) {
+			continue
+		}
+		for _, j := range due {
+			j.lastRun = next
+			j.fn(ctx, *session)
+		}
+	}
+}
+
+// nextDue - find the next time any job is due, and the jobs due then
+func (s *SessionScheduler) nextDue(m *Market, now time.Time) (time.Time, []*sessionJob, *TradingSession) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	type candidate struct {
+		at      time.Time
+		job     *sessionJob
+		session *TradingSession
+	}
+	var cands []candidate
+	for _, j := range s.jobs {
+		for i := -1; i < sessionLookahead; i++ {
+			session := m.GetSession(now.AddDate(0, 0, i))
+			if session == nil {
+				continue
+			}
+			t := j.at.In(session)
+			if !t.After(j.lastRun) || t.Before(now.Add(-time.Second)) {
+				continue
+			}
+			cands = append(cands, candidate{t, j, session})
+			break
+		}
+	}
+	if len(cands) == 0 {
+		return time.Time{}, nil, nil
+	}
+	sort.SliceStable(cands, func(a, b int) bool { return cands[a].at.Before(cands[b].at) })
+
+	var due []*sessionJob
+	for _, c := range cands {
+		if c.at.Equal(cands[0].at) {
+			due = append(due, c.job)
+		}
+	}
+	return cands[0].at, due, cands[0].session
+}
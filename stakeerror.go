@@ -34,4 +34,5 @@ func (e StakeError) Unwrap() error {
 var (
 	ErrSessionTokenMissing = NewStakeError("", fmt.Errorf("session token is invalid or missing"))
 	ErrInvalidAPIResponse = NewStakeError("", fmt.Errorf("invalid API response"))
+	ErrOTPRequired = NewStakeError("", fmt.Errorf("one time password required"))
 )
\ No newline at end of file
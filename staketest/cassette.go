@@ -0,0 +1,507 @@
+package staketest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mdusher/stakego"
+)
+
+// CassetteVersion - the current cassette file format version. Version 1
+// cassettes, which had no BodyEncoding, are still read.
+const CassetteVersion = 2
+
+// BodyEncodingRaw - marks a recorded body that wasn't JSON, stored as a
+// JSON string of the raw text
+const BodyEncodingRaw = "raw"
+
+// Redacted - replaces scrubbed values in cassettes
+const Redacted = "REDACTED"
+
+// DefaultScrubKeys - JSON keys whose values are scrubbed from recorded
+// request and response bodies, compared case insensitively
+var DefaultScrubKeys = []string{
+	"username", "password", "otp", "sessionKey",
+	"firstName", "middleName", "lastName", "email", "emailAddress", "phoneNumber",
+	"dateOfBirth", "residentialAddress", "postalAddress",
+	"userId", "dw_AccountId", "dw_AccountNumber", "macAccountNumber", "masterAccountId",
+	"referralCode", "referredByCode", "brokerOrderId", "cpfValue",
+}
+
+// DefaultScrubHeaders - request and response headers that are scrubbed
+var DefaultScrubHeaders = []string{"Stake-Session-Token", "Authorization", "Cookie", "Set-Cookie"}
+
+// Cassette - a recorded set of HTTP interactions
+type Cassette struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction - a single recorded request and response
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest - the scrubbed request
+type RecordedRequest struct {
+	Method       string              `json:"method"`
+	Path         string              `json:"path"` // path and query, without scheme and host
+	Headers      map[string][]string `json:"headers,omitempty"`
+	Body         json.RawMessage     `json:"body,omitempty"`
+	BodyEncoding string              `json:"bodyEncoding,omitempty"` // BodyEncodingRaw, or empty for JSON
+}
+
+// RecordedResponse - the scrubbed response
+type RecordedResponse struct {
+	StatusCode   int                 `json:"statusCode"`
+	Headers      map[string][]string `json:"headers,omitempty"`
+	Body         json.RawMessage     `json:"body,omitempty"`
+	BodyEncoding string              `json:"bodyEncoding,omitempty"` // BodyEncodingRaw, or empty for JSON
+}
+
+// LoadCassette - read a cassette file
+func LoadCassette(path string) (*Cassette, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Cassette
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, err
+	}
+	switch c.Version {
+	case CassetteVersion:
+	case 1:
+		c.upgradeV1()
+	default:
+		return nil, fmt.Errorf("unsupported cassette version %d, expected %d", c.Version, CassetteVersion)
+	}
+	return &c, nil
+}
+
+// upgradeV1 - version 1 stored bodies that weren't JSON as a JSON string
+// without marking them, so any string body is taken to be raw
+func (c *Cassette) upgradeV1() {
+	for n := range c.Interactions {
+		i := &c.Interactions[n]
+		if isJSONString(i.Request.Body) {
+			i.Request.BodyEncoding = BodyEncodingRaw
+		}
+		if isJSONString(i.Response.Body) {
+			i.Response.BodyEncoding = BodyEncodingRaw
+		}
+	}
+	c.Version = CassetteVersion
+}
+
+// isJSONString - checks if body is a JSON string
+func isJSONString(body json.RawMessage) bool {
+	var s string
+	return json.Unmarshal(body, &s) == nil
+}
+
+// Save - write the cassette to path
+func (c *Cassette) Save(path string) error {
+	c.Version = CassetteVersion
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return stakego.WriteFileAtomic(path, b, 0600)
+}
+
+// NewRecordingTransport - create a transport that records traffic through
+// inner (http.DefaultTransport if nil) to a cassette saved at path
+func NewRecordingTransport(path string, inner http.RoundTripper) *RecordingTransport {
+	t := RecordingTransport{}
+	t.Path = path
+	t.Inner = inner
+	if t.Inner == nil {
+		t.Inner = http.DefaultTransport
+	}
+	t.ScrubKeys = DefaultScrubKeys
+	t.ScrubHeaders = DefaultScrubHeaders
+	return &t
+}
+
+// RecordingTransport - an http.RoundTripper that records scrubbed traffic.
+// Session tokens, passwords, OTPs, names and account numbers are removed by
+// JSON key and header name. Scrubbed strings of at least MinSecretLength
+// characters, e.g. a session token that also appears in a URL, are also
+// replaced wherever else they occur when the cassette is saved. Numbers are
+// only ever scrubbed by key.
+type RecordingTransport struct {
+	Path         string
+	Inner        http.RoundTripper
+	ScrubKeys    []string
+	ScrubHeaders []string
+
+	mutex    sync.Mutex
+	cassette Cassette
+	secrets  map[string]bool
+}
+
+// MinSecretLength - scrubbed values shorter than this are only removed by
+// key, as replacing them everywhere would corrupt unrelated values
+const MinSecretLength = 8
+
+// AddSecret - scrub every occurrence of value from the cassette
+func (t *RecordingTransport) AddSecret(value string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.addSecret(value)
+}
+
+// addSecret - must be called with the mutex held
+func (t *RecordingTransport) addSecret(value string) {
+	if len(value) < MinSecretLength || value == Redacted {
+		return
+	}
+	if t.secrets == nil {
+		t.secrets = make(map[string]bool)
+	}
+	t.secrets[value] = true
+}
+
+// RoundTrip - perform the request and record it
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = b
+		req.Body = io.NopCloser(bytes.NewReader(b))
+	}
+
+	resp, err := t.Inner.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var i Interaction
+	i.Request.Method = req.Method
+	i.Request.Path = req.URL.RequestURI()
+	i.Request.Headers = t.scrubHeaders(req.Header)
+	i.Request.Body, i.Request.BodyEncoding = t.scrubBody(reqBody)
+	i.Response.StatusCode = resp.StatusCode
+	i.Response.Headers = t.scrubHeaders(resp.Header)
+	i.Response.Body, i.Response.BodyEncoding = t.scrubBody(respBody)
+	t.cassette.Interactions = append(t.cassette.Interactions, i)
+	return resp, nil
+}
+
+// Save - write the recorded cassette to Path. Secrets are replaced here,
+// once, as values found in one interaction may have appeared in an earlier
+// one, e.g. in a URL.
+func (t *RecordingTransport) Save() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	c := Cassette{Interactions: make([]Interaction, len(t.cassette.Interactions))}
+	re := t.secretsMatcher()
+	for n, i := range t.cassette.Interactions {
+		c.Interactions[n] = scrubSecrets(i, re)
+	}
+	return c.Save(t.Path)
+}
+
+// scrubHeaders - copy headers, redacting sensitive ones. Must be called with the mutex held.
+func (t *RecordingTransport) scrubHeaders(h http.Header) map[string][]string {
+	out := make(map[string][]string)
+	for k, v := range h {
+		redact := false
+		for _, s := range t.ScrubHeaders {
+			if strings.EqualFold(k, s) {
+				redact = true
+			}
+		}
+		if redact {
+			for _, val := range v {
+				t.addSecret(val)
+			}
+			out[k] = []string{Redacted}
+			continue
+		}
+		out[k] = append([]string{}, v...)
+	}
+	return out
+}
+
+// scrubBody - redact sensitive JSON keys, returning the body and its
+// encoding. Must be called with the mutex held.
+func (t *RecordingTransport) scrubBody(b []byte) (json.RawMessage, string) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return nil, ""
+	}
+	var v interface{}
+	if json.Unmarshal(b, &v) != nil {
+		// not JSON, store it as a string
+		s, _ := json.Marshal(string(b))
+		return s, BodyEncodingRaw
+	}
+	v = t.scrubValue(v)
+	out, err := json.Marshal(v)
+	if err != nil {
+		return nil, ""
+	}
+	return out, ""
+}
+
+// scrubValue - recursively redact sensitive keys. Must be called with the mutex held.
+func (t *RecordingTransport) scrubValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			if t.isScrubKey(k) {
+				val[k] = t.redact(child)
+			} else {
+				val[k] = t.scrubValue(child)
+			}
+		}
+	case []interface{}:
+		for i := range val {
+			val[i] = t.scrubValue(val[i])
+		}
+	}
+	return v
+}
+
+// redact - replace a value with a placeholder of the same kind. Must be called with the mutex held.
+func (t *RecordingTransport) redact(v interface{}) interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case string:
+		t.addSecret(val)
+		return Redacted
+	case float64:
+		return 0
+	case bool:
+		return val
+	}
+	return nil
+}
+
+// isScrubKey - checks if a JSON key should be scrubbed
+func (t *RecordingTransport) isScrubKey(k string) bool {
+	for _, s := range t.ScrubKeys {
+		if strings.EqualFold(k, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretsMatcher - a regexp matching every secret, or nil if there are
+// none. Must be called with the mutex held.
+func (t *RecordingTransport) secretsMatcher() *regexp.Regexp {
+	if len(t.secrets) == 0 {
+		return nil
+	}
+	secrets := make([]string, 0, len(t.secrets))
+	for s := range t.secrets {
+		secrets = append(secrets, regexp.QuoteMeta(s))
+	}
+	// longest first, so a secret containing another is replaced whole
+	sort.Slice(secrets, func(a, b int) bool { return len(secrets[a]) > len(secrets[b]) })
+	return regexp.MustCompile(strings.Join(secrets, "|"))
+}
+
+// scrubSecrets - a copy of i with any remaining occurrence of a secret
+// replaced
+func scrubSecrets(i Interaction, re *regexp.Regexp) Interaction {
+	i.Request.Headers = copyHeaders(i.Request.Headers)
+	i.Response.Headers = copyHeaders(i.Response.Headers)
+	if re == nil {
+		return i
+	}
+	i.Request.Path = re.ReplaceAllString(i.Request.Path, Redacted)
+	i.Request.Body = replaceInStrings(i.Request.Body, re)
+	i.Response.Body = replaceInStrings(i.Response.Body, re)
+	for _, h := range []map[string][]string{i.Request.Headers, i.Response.Headers} {
+		for _, vals := range h {
+			for n := range vals {
+				vals[n] = re.ReplaceAllString(vals[n], Redacted)
+			}
+		}
+	}
+	return i
+}
+
+// copyHeaders - a deep copy of recorded headers
+func copyHeaders(h map[string][]string) map[string][]string {
+	if h == nil {
+		return nil
+	}
+	out := make(map[string][]string, len(h))
+	for k, v := range h {
+		out[k] = append([]string{}, v...)
+	}
+	return out
+}
+
+// replaceInStrings - replace matches of re in the string values of a JSON
+// document, leaving keys alone
+func replaceInStrings(body json.RawMessage, re *regexp.Regexp) json.RawMessage {
+	if len(body) == 0 {
+		return body
+	}
+	var v interface{}
+	if json.Unmarshal(body, &v) != nil {
+		return body
+	}
+	var walk func(v interface{}) interface{}
+	walk = func(v interface{}) interface{} {
+		switch val := v.(type) {
+		case string:
+			return re.ReplaceAllString(val, Redacted)
+		case map[string]interface{}:
+			for k := range val {
+				val[k] = walk(val[k])
+			}
+		case []interface{}:
+			for n := range val {
+				val[n] = walk(val[n])
+			}
+		}
+		return v
+	}
+	out, err := json.Marshal(walk(v))
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// ErrNoInteraction - returned by ReplayTransport when no recorded interaction matches
+var ErrNoInteraction = errors.New("no recorded interaction")
+
+// NewReplayTransport - create a transport that serves responses from a cassette file
+func NewReplayTransport(path string) (*ReplayTransport, error) {
+	c, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayTransportFromCassette(c), nil
+}
+
+// NewReplayTransportFromCassette - create a transport that serves responses from c
+func NewReplayTransportFromCassette(c *Cassette) *ReplayTransport {
+	t := ReplayTransport{}
+	t.cassette = c
+	t.used = make([]bool, len(c.Interactions))
+	t.paths = make([]*regexp.Regexp, len(c.Interactions))
+	for n, i := range c.Interactions {
+		t.paths[n] = replayPathPattern(i.Request.Path)
+	}
+	return &t
+}
+
+// ReplayTransport - an http.RoundTripper that serves recorded responses
+// without network access. Requests are matched on method, path and query,
+// ignoring the host; repeated requests are served in recorded order and the
+// last match is reused once they run out.
+type ReplayTransport struct {
+	mutex    sync.Mutex
+	cassette *Cassette
+	used     []bool
+	paths    []*regexp.Regexp // per interaction, nil if the path has nothing redacted
+}
+
+// RoundTrip - serve the recorded response for req
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	path := req.URL.RequestURI()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	last := -1
+	match := -1
+	for n, i := range t.cassette.Interactions {
+		if i.Request.Method != req.Method || !replayPathMatches(i.Request.Path, t.paths[n], path) {
+			continue
+		}
+		last = n
+		if !t.used[n] {
+			match = n
+			break
+		}
+	}
+	if match < 0 {
+		match = last
+	}
+	if match < 0 {
+		return nil, fmt.Errorf("%w for %s %s", ErrNoInteraction, req.Method, path)
+	}
+	t.used[match] = true
+
+	r := t.cassette.Interactions[match].Response
+	body := []byte(r.Body)
+	var s string
+	if r.BodyEncoding == BodyEncodingRaw && json.Unmarshal(r.Body, &s) == nil {
+		body = []byte(s)
+	}
+	resp := http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header(r.Headers).Clone(),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+	return &resp, nil
+}
+
+// replayPathPattern - compile a recorded path with redacted segments into a
+// pattern treating them as wildcards, or nil if nothing was redacted
+func replayPathPattern(recorded string) *regexp.Regexp {
+	if !strings.Contains(recorded, Redacted) {
+		return nil
+	}
+	parts := strings.Split(recorded, Redacted)
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	re, err := regexp.Compile("^" + strings.Join(parts, "[^/?&]+") + "$")
+	if err != nil {
+		return nil
+	}
+	return re
+}
+
+// replayPathMatches - compare a recorded path, and its pattern if it has
+// one, with a request path
+func replayPathMatches(recorded string, pattern *regexp.Regexp, path string) bool {
+	return recorded == path || (pattern != nil && pattern.MatchString(path))
+}
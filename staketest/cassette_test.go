@@ -0,0 +1,204 @@
+package staketest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mdusher/stakego"
+	"github.com/mdusher/stakego/staketest"
+)
+
+func TestRecordReplay(t *testing.T) {
+	s := staketest.NewServer()
+	defer s.Close()
+	s.Update(func(st *staketest.State) {
+		st.Password = "correct-horse"
+		st.Cash.PostedBalance = 10000
+		st.Cash.BuyingPower = 10000
+		st.Positions = []stakego.EquityPositionItem{{Symbol: "BHP", Name: "BHP Group", OpenQty: 1000, MktPrice: 45.5}}
+	})
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	rec := staketest.NewRecordingTransport(path, nil)
+	c := s.NewClient(stakego.WithHTTPClient(http.Client{Transport: rec}))
+	if err := c.Login(); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	token := c.Credentials.GetSessionToken()
+	if _, err := c.GetCash(); err != nil {
+		t.Fatalf("GetCash: %v", err)
+	}
+	if _, err := c.GetEquityPositions(); err != nil {
+		t.Fatalf("GetEquityPositions: %v", err)
+	}
+	if err := c.Logout(); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{token, "test@example.com", "correct-horse", "00000000-0000-0000-0000-000000000001"} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("cassette contains %q", secret)
+		}
+	}
+
+	replay, err := staketest.NewReplayTransport(path)
+	if err != nil {
+		t.Fatalf("NewReplayTransport: %v", err)
+	}
+	s.Close() // replay must not need the server
+	c = s.NewClient(stakego.WithHTTPClient(http.Client{Transport: replay}))
+	if err := c.Login(); err != nil {
+		t.Fatalf("replayed Login: %v", err)
+	}
+	cash, err := c.GetCash()
+	if err != nil {
+		t.Fatalf("replayed GetCash: %v", err)
+	}
+	if cash.PostedBalance != 10000 || cash.BuyingPower != 10000 {
+		t.Errorf("replayed cash = %+v, want 10000", cash)
+	}
+	positions, err := c.GetEquityPositions()
+	if err != nil {
+		t.Fatalf("replayed GetEquityPositions: %v", err)
+	}
+	if len(positions.EquityPositions) != 1 || positions.EquityPositions[0].OpenQty != 1000 {
+		t.Errorf("replayed positions = %+v, want 1000 BHP", positions.EquityPositions)
+	}
+	if err := c.Logout(); err != nil {
+		t.Fatalf("replayed Logout: %v", err)
+	}
+}
+
+func TestScrubbedNumbersStayLocal(t *testing.T) {
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"dw_AccountNumber": 1000, "cashAvailableForWithdrawal": "10000.00", "units": 1000, "userId": "a1b2c3d4e5"}`))
+	}))
+	defer hs.Close()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	rec := staketest.NewRecordingTransport(path, nil)
+	hc := http.Client{Transport: rec}
+	for _, p := range []string{"/first", "/users/a1b2c3d4e5"} {
+		resp, err := hc.Get(hs.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := staketest.LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Interactions[1].Request.Path; got != "/users/"+staketest.Redacted {
+		t.Errorf("path = %q, want the user id redacted", got)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(c.Interactions[0].Response.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"dw_AccountNumber":           0.0,
+		"cashAvailableForWithdrawal": "10000.00",
+		"units":                      1000.0,
+		"userId":                     staketest.Redacted,
+	}
+	for k, v := range want {
+		if body[k] != v {
+			t.Errorf("%s = %v, want %v", k, body[k], v)
+		}
+	}
+}
+
+func TestReplayBodyEncoding(t *testing.T) {
+	bodies := map[string]string{
+		"/json":   `{"status": "ok"}`,
+		"/string": `"a JSON string"`,
+		"/text":   "plain text, not JSON",
+	}
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(bodies[r.URL.Path]))
+	}))
+	defer hs.Close()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	rec := staketest.NewRecordingTransport(path, nil)
+	hc := http.Client{Transport: rec}
+	for p := range bodies {
+		resp, err := hc.Get(hs.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	hs.Close()
+
+	replay, err := staketest.NewReplayTransport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc = http.Client{Transport: replay}
+	for p, want := range bodies {
+		resp, err := hc.Get(hs.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		got := string(b)
+		if p == "/json" {
+			// JSON bodies are reindented when saved
+			var compact bytes.Buffer
+			_ = json.Compact(&compact, b)
+			got, want = compact.String(), `{"status":"ok"}`
+		}
+		if got != want {
+			t.Errorf("replayed %s = %q, want %q", p, got, want)
+		}
+	}
+}
+
+func TestLoadCassetteVersion1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	v1 := `{"version": 1, "interactions": [
+		{"request": {"method": "GET", "path": "/text"}, "response": {"statusCode": 200, "body": "plain text"}},
+		{"request": {"method": "GET", "path": "/json"}, "response": {"statusCode": 200, "body": {"ok": true}}}
+	]}`
+	if err := os.WriteFile(path, []byte(v1), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := staketest.LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != staketest.CassetteVersion {
+		t.Errorf("Version = %d, want %d", c.Version, staketest.CassetteVersion)
+	}
+	if got := c.Interactions[0].Response.BodyEncoding; got != staketest.BodyEncodingRaw {
+		t.Errorf("string body encoding = %q, want %q", got, staketest.BodyEncodingRaw)
+	}
+	if got := c.Interactions[1].Response.BodyEncoding; got != "" {
+		t.Errorf("JSON body encoding = %q, want none", got)
+	}
+}
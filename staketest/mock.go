@@ -0,0 +1,142 @@
+package staketest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mdusher/stakego"
+)
+
+// ErrNotMocked - returned by MockClient methods without a function set
+var ErrNotMocked = errors.New("staketest: method not mocked")
+
+// MockClient - a stakego.Client whose methods call the matching function
+// field. Methods without a function return ErrNotMocked. Calls are counted
+// by method name.
+type MockClient struct {
+	GetUserFunc            func() (*stakego.User, error)
+	GetCashFunc            func() (*stakego.Cash, error)
+	GetEquityPositionsFunc func() (*stakego.EquityPositions, error)
+	GetOrdersFunc          func() (*[]stakego.OrderDetails, error)
+	PlaceOrderFunc         func(order stakego.Order) (*stakego.OrderResponse, error)
+	CancelOrderFunc        func(uuid string) error
+	GetBrokerageFunc       func(price float64) (*stakego.Brokerage, error)
+	GetMarketFunc          func() (*stakego.Market, error)
+	LookupInstrumentFunc   func(keyword string) (*stakego.Instrument, error)
+	GetTransactionsFunc    func(ctx context.Context, from time.Time, to time.Time, kinds ...string) ([]stakego.Transaction, error)
+
+	mutex sync.Mutex
+	calls map[string]int
+}
+
+var _ stakego.Client = (*MockClient)(nil)
+var _ stakego.TransactionReader = (*MockClient)(nil)
+
+// Calls - how many times a method has been called
+func (m *MockClient) Calls(method string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.calls[method]
+}
+
+// called - count a call
+func (m *MockClient) called(method string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.calls == nil {
+		m.calls = make(map[string]int)
+	}
+	m.calls[method]++
+}
+
+// GetUser - calls GetUserFunc
+func (m *MockClient) GetUser() (*stakego.User, error) {
+	m.called("GetUser")
+	if m.GetUserFunc == nil {
+		return nil, ErrNotMocked
+	}
+	return m.GetUserFunc()
+}
+
+// GetCash - calls GetCashFunc
+func (m *MockClient) GetCash() (*stakego.Cash, error) {
+	m.called("GetCash")
+	if m.GetCashFunc == nil {
+		return nil, ErrNotMocked
+	}
+	return m.GetCashFunc()
+}
+
+// GetEquityPositions - calls GetEquityPositionsFunc
+func (m *MockClient) GetEquityPositions() (*stakego.EquityPositions, error) {
+	m.called("GetEquityPositions")
+	if m.GetEquityPositionsFunc == nil {
+		return nil, ErrNotMocked
+	}
+	return m.GetEquityPositionsFunc()
+}
+
+// GetOrders - calls GetOrdersFunc
+func (m *MockClient) GetOrders() (*[]stakego.OrderDetails, error) {
+	m.called("GetOrders")
+	if m.GetOrdersFunc == nil {
+		return nil, ErrNotMocked
+	}
+	return m.GetOrdersFunc()
+}
+
+// PlaceOrder - calls PlaceOrderFunc
+func (m *MockClient) PlaceOrder(order stakego.Order) (*stakego.OrderResponse, error) {
+	m.called("PlaceOrder")
+	if m.PlaceOrderFunc == nil {
+		return nil, ErrNotMocked
+	}
+	return m.PlaceOrderFunc(order)
+}
+
+// CancelOrder - calls CancelOrderFunc
+func (m *MockClient) CancelOrder(uuid string) error {
+	m.called("CancelOrder")
+	if m.CancelOrderFunc == nil {
+		return ErrNotMocked
+	}
+	return m.CancelOrderFunc(uuid)
+}
+
+// GetBrokerage - calls GetBrokerageFunc
+func (m *MockClient) GetBrokerage(price float64) (*stakego.Brokerage, error) {
+	m.called("GetBrokerage")
+	if m.GetBrokerageFunc == nil {
+		return nil, ErrNotMocked
+	}
+	return m.GetBrokerageFunc(price)
+}
+
+// GetMarket - calls GetMarketFunc
+func (m *MockClient) GetMarket() (*stakego.Market, error) {
+	m.called("GetMarket")
+	if m.GetMarketFunc == nil {
+		return nil, ErrNotMocked
+	}
+	return m.GetMarketFunc()
+}
+
+// LookupInstrument - calls LookupInstrumentFunc
+func (m *MockClient) LookupInstrument(keyword string) (*stakego.Instrument, error) {
+	m.called("LookupInstrument")
+	if m.LookupInstrumentFunc == nil {
+		return nil, ErrNotMocked
+	}
+	return m.LookupInstrumentFunc(keyword)
+}
+
+// GetTransactions - calls GetTransactionsFunc
+func (m *MockClient) GetTransactions(ctx context.Context, from time.Time, to time.Time, kinds ...string) ([]stakego.Transaction, error) {
+	m.called("GetTransactions")
+	if m.GetTransactionsFunc == nil {
+		return nil, ErrNotMocked
+	}
+	return m.GetTransactionsFunc(ctx, from, to, kinds...)
+}
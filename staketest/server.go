@@ -0,0 +1,602 @@
+// Package staketest provides an in-memory fake of the Stake API for testing
+// code built on stakego without touching production.
+package staketest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mdusher/stakego"
+)
+
+// DefaultLocationJSON - location data served until SetLocation is called
+var DefaultLocationJSON = []byte(`{"calendar":{"AU_TRADING":{"tradingHolidays":[],"earlyClose":[]},"US_TRADING":{"trading_holidays":[],"earlyClose":[]}}}`)
+
+// State - the fake account. Change it with Server.Update. Buy orders
+// reserve their value plus brokerage from Cash.BuyingPower into
+// Cash.PendingBuys until they are filled or cancelled.
+type State struct {
+	Username    string
+	Password    string
+	OTP         string // if set, createSession requires this OTP
+	Tokens      map[string]bool
+	User        stakego.User
+	Cash        stakego.Cash
+	Positions   []stakego.EquityPositionItem
+	Orders      []stakego.OrderDetails
+	Instruments []stakego.Instrument
+	// Transactions - the cash ledger, oldest first. FillOrder and
+	// AddTransaction append to it.
+	Transactions []stakego.Transaction
+	Location     []byte
+	Brokerage    func(amount float64) stakego.Brokerage
+}
+
+// fault - an injected error or delay
+type fault struct {
+	status    int
+	delay     time.Duration
+	remaining int // -1 for unlimited
+}
+
+// NewServer - start a fake Stake server with a logged out user
+// "test@example.com" / "password". Close it when done.
+func NewServer() *Server {
+	s := Server{}
+	s.state = State{
+		Username:  "test@example.com",
+		Password:  "password",
+		Tokens:    make(map[string]bool),
+		Location:  DefaultLocationJSON,
+		Brokerage: DefaultBrokerage,
+	}
+	s.state.User.UserID = "00000000-0000-0000-0000-000000000001"
+	s.state.User.EmailAddress = s.state.Username
+	s.state.User.FirstName = "Test"
+	s.state.User.LastName = "User"
+	s.faults = make(map[string]*fault)
+	s.requests = make(map[string]int)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/sessions/v2/createSession", s.createSession)
+	mux.HandleFunc("DELETE /api/userauth/{token}", s.authed(s.deleteSession))
+	mux.HandleFunc("GET /api/user", s.authed(s.getUser))
+	mux.HandleFunc("GET /api/asx/cash", s.authed(s.getCash))
+	mux.HandleFunc("GET /api/asx/instrument/equityPositions", s.authed(s.getEquityPositions))
+	mux.HandleFunc("GET /api/asx/instrument/search", s.authed(s.searchInstruments))
+	mux.HandleFunc("GET /api/asx/orders", s.authed(s.getOrders))
+	mux.HandleFunc("POST /api/asx/orders", s.authed(s.placeOrder))
+	mux.HandleFunc("POST /api/asx/orders/{id}/cancel", s.authed(s.cancelOrder))
+	mux.HandleFunc("GET /api/asx/orders/brokerage", s.authed(s.getBrokerage))
+	mux.HandleFunc("GET /api/asx/transactions", s.authed(s.getTransactions))
+	mux.HandleFunc("GET /_get_location", s.getLocation)
+
+	s.httpServer = httptest.NewServer(s.inject(mux))
+	return &s
+}
+
+// Server - a fake Stake API backed by an httptest.Server
+type Server struct {
+	httpServer *httptest.Server
+	mutex      sync.Mutex
+	state      State
+	faults     map[string]*fault
+	requests   map[string]int
+}
+
+// DefaultBrokerage - $3 up to $1000, then 0.03%
+func DefaultBrokerage(amount float64) stakego.Brokerage {
+	b := stakego.Brokerage{FixedFee: 3, VariableFeePercentage: 0.03, VariableLimit: 1000}
+	b.BrokerageFee = 3
+	if amount > 1000 {
+		b.BrokerageFee = amount * 0.0003
+	}
+	return b
+}
+
+// URL - the base URL of the fake API, suitable for stakego.WithBaseURL
+func (s *Server) URL() string {
+	return s.httpServer.URL + "/api/"
+}
+
+// LocationURL - the URL of the fake location data, suitable for stakego.WithLocationURL
+func (s *Server) LocationURL() string {
+	return s.httpServer.URL + "/_get_location"
+}
+
+// ClientOptions - options that point an ASXClient at this server
+func (s *Server) ClientOptions() []stakego.ClientOption {
+	return []stakego.ClientOption{
+		stakego.WithBaseURL(s.URL()),
+		stakego.WithLocationURL(s.LocationURL()),
+		stakego.WithHTTPClient(*s.httpServer.Client()),
+	}
+}
+
+// NewClient - create an ASXClient pointed at this server with the server's
+// username and password as credentials
+func (s *Server) NewClient(opts ...stakego.ClientOption) *stakego.ASXClient {
+	c := stakego.NewASXClient(append(s.ClientOptions(), opts...)...)
+	s.mutex.Lock()
+	c.Credentials = &stakego.Credentials{Username: s.state.Username, Password: s.state.Password, RememberMeDays: 30, PlatformType: stakego.DefaultPlatformType}
+	s.mutex.Unlock()
+	return c
+}
+
+// Close - shut down the server
+func (s *Server) Close() {
+	s.httpServer.Close()
+}
+
+// Update - change the fake account's state
+func (s *Server) Update(fn func(state *State)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fn(&s.state)
+}
+
+// State - returns a copy of the fake account's state
+func (s *Server) State() State {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	st := s.state
+	st.Positions = append([]stakego.EquityPositionItem{}, s.state.Positions...)
+	st.Orders = append([]stakego.OrderDetails{}, s.state.Orders...)
+	st.Instruments = append([]stakego.Instrument{}, s.state.Instruments...)
+	st.Transactions = append([]stakego.Transaction{}, s.state.Transactions...)
+	st.Location = append([]byte{}, s.state.Location...)
+	st.Tokens = make(map[string]bool, len(s.state.Tokens))
+	for t, v := range s.state.Tokens {
+		st.Tokens[t] = v
+	}
+	return st
+}
+
+// IssueToken - create a valid session token without logging in
+func (s *Server) IssueToken() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.issueToken()
+}
+
+// issueToken - must be called with the mutex held
+func (s *Server) issueToken() string {
+	b := sha256.Sum256([]byte(fmt.Sprintf("%d-%d", time.Now().UnixNano(), len(s.state.Tokens))))
+	token := hex.EncodeToString(b[:16])
+	s.state.Tokens[token] = true
+	return token
+}
+
+// SetLocation - serve the given location data
+func (s *Server) SetLocation(l *stakego.LocationData) {
+	b, _ := json.Marshal(l)
+	s.Update(func(st *State) { st.Location = b })
+}
+
+// FillOrder - fill a pending order in full at its limit price, moving it
+// into the positions and adjusting cash
+func (s *Server) FillOrder(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, o := range s.state.Orders {
+		if o.ID != id {
+			continue
+		}
+		s.state.Orders = append(s.state.Orders[:i], s.state.Orders[i+1:]...)
+		value := float64(o.UnitsRemaining) * o.LimitPrice
+		units := o.UnitsRemaining
+		if o.Side == stakego.OrderSELL {
+			units = -units
+			s.state.Cash.PostedBalance += value - o.EstimatedBrokerage
+			s.state.Cash.BuyingPower += value - o.EstimatedBrokerage
+		} else {
+			// the buying power was reserved when the order was placed
+			s.state.Cash.PostedBalance -= value + o.EstimatedBrokerage
+			s.state.Cash.PendingBuys -= value + o.EstimatedBrokerage
+		}
+		s.state.Cash.SettledCash = s.state.Cash.PostedBalance
+		s.applyFill(o.InstrumentCode, units, o.LimitPrice)
+
+		settled := value
+		if o.Side == stakego.OrderBUY {
+			settled = -value
+		}
+		description := fmt.Sprintf("%s %d %s @ %.3f", o.Side, o.UnitsRemaining, o.InstrumentCode, o.LimitPrice)
+		s.recordTransaction(stakego.TransactionTradeSettlement, settled, s.state.Cash.PostedBalance+o.EstimatedBrokerage, description, o)
+		s.recordTransaction(stakego.TransactionBrokerage, -o.EstimatedBrokerage, s.state.Cash.PostedBalance, "Brokerage", o)
+		return nil
+	}
+	return fmt.Errorf("order '%s' not found", id)
+}
+
+// AddTransaction - add a cash transaction, such as a deposit or dividend,
+// and adjust the balances by amount
+func (s *Server) AddTransaction(kind string, amount float64, description string) stakego.Transaction {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.state.Cash.PostedBalance += amount
+	s.state.Cash.SettledCash += amount
+	s.state.Cash.BuyingPower += amount
+	return s.recordTransaction(kind, amount, s.state.Cash.PostedBalance, description, stakego.OrderDetails{})
+}
+
+// recordTransaction - append to the ledger. Must be called with the mutex held.
+func (s *Server) recordTransaction(kind string, amount float64, balance float64, description string, o stakego.OrderDetails) stakego.Transaction {
+	t := stakego.Transaction{
+		ID:          stakegoOrderID(len(s.state.Transactions)),
+		Type:        kind,
+		Time:        time.Now(),
+		Description: description,
+		Amount:      amount,
+		Balance:     &balance,
+		Symbol:      o.InstrumentCode,
+		OrderID:     o.ID,
+	}
+	s.state.Transactions = append(s.state.Transactions, t)
+	return t
+}
+
+// applyFill - adjust a position. Must be called with the mutex held.
+func (s *Server) applyFill(symbol string, units int, price float64) {
+	for i := range s.state.Positions {
+		p := &s.state.Positions[i]
+		if p.Symbol != symbol {
+			continue
+		}
+		if units > 0 {
+			p.AveragePrice = (p.AveragePrice*float64(p.OpenQty) + price*float64(units)) / float64(p.OpenQty+units)
+		}
+		p.OpenQty += units
+		p.AvailableForTradingQty += units
+		p.MktPrice = price
+		p.MarketValue = float64(p.OpenQty) * price
+		if p.OpenQty <= 0 {
+			s.state.Positions = append(s.state.Positions[:i], s.state.Positions[i+1:]...)
+		}
+		return
+	}
+	if units > 0 {
+		s.state.Positions = append(s.state.Positions, stakego.EquityPositionItem{
+			InstrumentID: symbol, Symbol: symbol, Name: symbol,
+			OpenQty: units, AvailableForTradingQty: units,
+			AveragePrice: price, MktPrice: price, PriorClose: price,
+			MarketValue: float64(units) * price,
+		})
+	}
+}
+
+// Fail - respond to the next n requests to path (e.g. "/api/asx/cash", or
+// "" for any path) with status. n < 0 fails every request until cleared.
+func (s *Server) Fail(path string, status int, n int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults[path] = &fault{status: status, remaining: n}
+}
+
+// Delay - delay every response to path (or "" for any path) by d
+func (s *Server) Delay(path string, d time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults[path] = &fault{delay: d, remaining: -1}
+}
+
+// ClearFaults - remove all injected errors and delays
+func (s *Server) ClearFaults() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = make(map[string]*fault)
+}
+
+// Requests - how many requests have been made to path
+func (s *Server) Requests(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests[path]
+}
+
+// inject - count requests and apply injected faults
+func (s *Server) inject(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		s.requests[r.URL.Path]++
+		f, ok := s.faults[r.URL.Path]
+		if !ok {
+			f, ok = s.faults[""]
+		}
+		var status int
+		var delay time.Duration
+		if ok && f.remaining != 0 {
+			status = f.status
+			delay = f.delay
+			if f.remaining > 0 {
+				f.remaining--
+			}
+		}
+		s.mutex.Unlock()
+
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if status != 0 {
+			if status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			writeJSON(w, status, map[string]string{"message": http.StatusText(status)})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authed - require a valid Stake-Session-Token
+func (s *Server) authed(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Stake-Session-Token")
+		if r.PathValue("token") != "" {
+			token = r.PathValue("token")
+		}
+		s.mutex.Lock()
+		valid := s.state.Tokens[token]
+		s.mutex.Unlock()
+		if !valid {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Unauthorized"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeJSON - write v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) createSession(w http.ResponseWriter, r *http.Request) {
+	var creds struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		OTP      string `json:"otp"`
+	}
+	body, _ := io.ReadAll(r.Body)
+	if json.Unmarshal(body, &creds) != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	switch {
+	case creds.Username != s.state.Username || creds.Password != s.state.Password:
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Invalid username or password"})
+	case s.state.OTP != "" && creds.OTP == "":
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "OTP required"})
+	case s.state.OTP != "" && creds.OTP != s.state.OTP:
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Invalid OTP"})
+	default:
+		us := stakego.UserSession{
+			UserID:     s.state.User.UserID,
+			FirstName:  s.state.User.FirstName,
+			LastName:   s.state.User.LastName,
+			Username:   s.state.Username,
+			Email:      s.state.User.EmailAddress,
+			SessionKey: s.issueToken(),
+		}
+		writeJSON(w, http.StatusOK, us)
+	}
+}
+
+func (s *Server) deleteSession(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	delete(s.state.Tokens, r.PathValue("token"))
+	s.mutex.Unlock()
+	writeJSON(w, http.StatusOK, map[string]string{})
+}
+
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	writeJSON(w, http.StatusOK, s.state.User)
+}
+
+func (s *Server) getCash(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	writeJSON(w, http.StatusOK, s.state.Cash)
+}
+
+func (s *Server) getEquityPositions(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	positions := s.state.Positions
+	if positions == nil {
+		positions = []stakego.EquityPositionItem{}
+	}
+	writeJSON(w, http.StatusOK, stakego.EquityPositions{PageNum: 1, EquityPositions: positions})
+}
+
+func (s *Server) searchInstruments(w http.ResponseWriter, r *http.Request) {
+	key := strings.ToUpper(r.URL.Query().Get("searchKey"))
+	max, err := strconv.Atoi(r.URL.Query().Get("max"))
+	if err != nil || max <= 0 {
+		max = 10
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	resp := stakego.InstrumentResponse{Instruments: []stakego.Instrument{}, InstrumentTags: []interface{}{}}
+	for _, i := range s.state.Instruments {
+		if len(resp.Instruments) >= max {
+			break
+		}
+		if strings.HasPrefix(i.Symbol, key) || strings.Contains(strings.ToUpper(i.Name), key) {
+			resp.Instruments = append(resp.Instruments, i)
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) getOrders(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	orders := s.state.Orders
+	if orders == nil {
+		orders = []stakego.OrderDetails{}
+	}
+	writeJSON(w, http.StatusOK, orders)
+}
+
+func (s *Server) getTransactions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, err := time.Parse(time.RFC3339, q.Get("from"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Invalid from"})
+		return
+	}
+	to, err := time.Parse(time.RFC3339, q.Get("to"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Invalid to"})
+		return
+	}
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	types := map[string]bool{}
+	for _, t := range strings.Split(q.Get("types"), ",") {
+		if t != "" {
+			types[t] = true
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	matched := []stakego.Transaction{}
+	for _, t := range s.state.Transactions {
+		if t.Time.Before(from) || t.Time.After(to) || (len(types) > 0 && !types[t.Kind()]) {
+			continue
+		}
+		matched = append(matched, t)
+	}
+	page := stakego.TransactionPage{Transactions: []stakego.Transaction{}}
+	if offset < len(matched) {
+		end := offset + limit
+		if end > len(matched) {
+			end = len(matched)
+		}
+		page.Transactions = matched[offset:end]
+		page.HasNext = end < len(matched)
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) placeOrder(w http.ResponseWriter, r *http.Request) {
+	var o stakego.Order
+	body, _ := io.ReadAll(r.Body)
+	if json.Unmarshal(body, &o) != nil || o.Units <= 0 || o.Price <= 0 || o.InstrumentCode == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Invalid order"})
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	value := float64(o.Units) * o.Price
+	b := s.state.Brokerage(value)
+	if o.Side == stakego.OrderBUY {
+		if value+b.BrokerageFee > s.state.Cash.BuyingPower {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Insufficient buying power"})
+			return
+		}
+		s.state.Cash.BuyingPower -= value + b.BrokerageFee
+		s.state.Cash.PendingBuys += value + b.BrokerageFee
+	}
+	details := stakego.OrderDetails{
+		ID:                   stakegoOrderID(len(s.state.Orders)),
+		Broker:               "FAKE",
+		UserID:               s.state.User.UserID,
+		InstrumentID:         o.InstrumentCode,
+		InstrumentCode:       o.InstrumentCode,
+		Side:                 o.Side,
+		LimitPrice:           o.Price,
+		Validity:             o.Validity,
+		ValidityDate:         o.ValidityDate,
+		Type:                 o.Type,
+		PlacedTimestamp:      time.Now().Format(time.RFC3339),
+		OrderStatus:          stakego.OrderStatusOpen,
+		UnitsRemaining:       o.Units,
+		UnitsRequested:       o.Units,
+		EstimatedBrokerage:   b.BrokerageFee,
+		PendingBrokerage:     b.BrokerageFee,
+		AllowAwaitingTrigger: o.AllowAwaitingTrigger,
+	}
+	s.state.Orders = append(s.state.Orders, details)
+	writeJSON(w, http.StatusOK, stakego.OrderResponse{Order: details})
+}
+
+// stakegoOrderID - a UUID shaped order id
+func stakegoOrderID(n int) string {
+	b := sha256.Sum256([]byte(fmt.Sprintf("%d-%d", time.Now().UnixNano(), n)))
+	h := hex.EncodeToString(b[:16])
+	return fmt.Sprintf("%s-%s-%s-%s-%s", h[0:8], h[8:12], h[12:16], h[16:20], h[20:32])
+}
+
+func (s *Server) cancelOrder(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, o := range s.state.Orders {
+		if o.ID == id {
+			if o.Side == stakego.OrderBUY {
+				reserved := float64(o.UnitsRemaining)*o.LimitPrice + o.EstimatedBrokerage
+				s.state.Cash.BuyingPower += reserved
+				s.state.Cash.PendingBuys -= reserved
+			}
+			s.state.Orders = append(s.state.Orders[:i], s.state.Orders[i+1:]...)
+			writeJSON(w, http.StatusOK, map[string]string{})
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "Order not found"})
+}
+
+func (s *Server) getBrokerage(w http.ResponseWriter, r *http.Request) {
+	amount, err := strconv.ParseFloat(r.URL.Query().Get("orderAmount"), 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": "Invalid orderAmount"})
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	writeJSON(w, http.StatusOK, s.state.Brokerage(amount))
+}
+
+func (s *Server) getLocation(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	body := s.state.Location
+	s.mutex.Unlock()
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
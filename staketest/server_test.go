@@ -0,0 +1,410 @@
+package staketest_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mdusher/stakego"
+	"github.com/mdusher/stakego/staketest"
+)
+
+// newLoggedIn - start a server with cash and return a logged in client
+func newLoggedIn(t *testing.T, cash float64) (*staketest.Server, *stakego.ASXClient) {
+	t.Helper()
+	s := staketest.NewServer()
+	t.Cleanup(s.Close)
+	s.Update(func(st *staketest.State) {
+		st.Cash.PostedBalance = cash
+		st.Cash.SettledCash = cash
+		st.Cash.BuyingPower = cash
+	})
+	c := s.NewClient()
+	if err := c.Login(); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	return s, c
+}
+
+// near - checks two amounts are equal to the cent
+func near(a float64, b float64) bool {
+	return math.Abs(a-b) < 0.005
+}
+
+func TestLogin(t *testing.T) {
+	s := staketest.NewServer()
+	defer s.Close()
+
+	c := s.NewClient()
+	if err := c.Login(); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if c.User == nil || c.User.EmailAddress != "test@example.com" {
+		t.Errorf("User = %+v, want test@example.com", c.User)
+	}
+	if c.Session == nil || c.Session.SessionKey == "" {
+		t.Errorf("Session = %+v, want a session key", c.Session)
+	}
+	token := c.Credentials.GetSessionToken()
+	if !s.State().Tokens[token] {
+		t.Errorf("token %q not issued by the server", token)
+	}
+
+	if err := c.Logout(); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if s.State().Tokens[token] {
+		t.Errorf("token %q still valid after Logout", token)
+	}
+}
+
+func TestLoginResumesStoredSession(t *testing.T) {
+	s := staketest.NewServer()
+	defer s.Close()
+	path := filepath.Join(t.TempDir(), "token")
+
+	c := s.NewClient()
+	c.Credentials.RememberMeDays = 30
+	c.TokenStore = stakego.NewEncryptedFileTokenStore(path, "passphrase")
+	if err := c.Login(); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	resumed := s.NewClient()
+	resumed.Credentials.Password = "wrong" // must not be needed
+	resumed.TokenStore = stakego.NewEncryptedFileTokenStore(path, "passphrase")
+	if err := resumed.Login(); err != nil {
+		t.Fatalf("resumed Login: %v", err)
+	}
+	if resumed.Credentials.GetSessionToken() != c.Credentials.GetSessionToken() {
+		t.Error("resumed Login created a new session")
+	}
+	if us := resumed.CurrentSession(); us == nil || us.SessionKey != c.Credentials.GetSessionToken() {
+		t.Errorf("Session = %+v, want the stored session", us)
+	}
+	if !resumed.SessionIssuedAt().Equal(c.SessionIssuedAt()) || !resumed.SessionExpiresAt().Equal(c.SessionExpiresAt()) {
+		t.Errorf("session times = %v, %v, want %v, %v", resumed.SessionIssuedAt(), resumed.SessionExpiresAt(), c.SessionIssuedAt(), c.SessionExpiresAt())
+	}
+
+	// a bare token, as saved by earlier versions
+	if err := os.WriteFile(path+".old", []byte(c.Credentials.GetSessionToken()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := s.NewClient()
+	old.TokenStore = stakego.NewFileTokenStore(path + ".old")
+	if err := old.Login(); err != nil {
+		t.Fatalf("Login from a bare token: %v", err)
+	}
+	if us := old.CurrentSession(); us == nil || us.Email != "test@example.com" {
+		t.Errorf("Session = %+v, want one built from the user", us)
+	}
+}
+
+func TestLoginInvalidPassword(t *testing.T) {
+	s := staketest.NewServer()
+	defer s.Close()
+
+	c := s.NewClient()
+	c.Credentials.Password = "wrong"
+	err := c.Login()
+	var authErr *stakego.AuthError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("Login error = %v, want an AuthError", err)
+	}
+	if authErr.Reason != stakego.AuthInvalidCredentials {
+		t.Errorf("Reason = %q, want %q", authErr.Reason, stakego.AuthInvalidCredentials)
+	}
+}
+
+func TestLoginOTP(t *testing.T) {
+	s := staketest.NewServer()
+	defer s.Close()
+	s.Update(func(st *staketest.State) { st.OTP = "123456" })
+
+	c := s.NewClient()
+	err := c.Login()
+	if !errors.Is(err, stakego.ErrOTPRequired) {
+		t.Fatalf("Login without an OTP = %v, want ErrOTPRequired", err)
+	}
+
+	c.Credentials.OTPProvider = stakego.StaticOTPProvider{Code: "654321"}
+	err = c.Login()
+	var authErr *stakego.AuthError
+	if !errors.As(err, &authErr) || authErr.Reason != stakego.AuthOTPInvalid {
+		t.Fatalf("Login with the wrong OTP = %v, want %q", err, stakego.AuthOTPInvalid)
+	}
+
+	c.Credentials.OTPProvider = stakego.StaticOTPProvider{Code: "123456"}
+	if err := c.Login(); err != nil {
+		t.Fatalf("Login with the OTP: %v", err)
+	}
+}
+
+func TestUnauthenticated(t *testing.T) {
+	s := staketest.NewServer()
+	defer s.Close()
+
+	c := s.NewClient()
+	c.Credentials.SetSessionToken("not-a-token")
+	if _, err := c.GetCash(); err == nil {
+		t.Fatal("GetCash with an invalid token succeeded")
+	}
+}
+
+func TestBuyFillAndCash(t *testing.T) {
+	s, c := newLoggedIn(t, 10000)
+
+	o := stakego.NewBuyOrder()
+	o.InstrumentCode = "BHP"
+	o.Units = 100
+	o.Price = 40
+	resp, err := c.PlaceOrder(*o)
+	if err != nil {
+		t.Fatalf("PlaceOrder: %v", err)
+	}
+
+	// $4000 plus $1.20 brokerage is reserved
+	cash, err := c.GetCash()
+	if err != nil {
+		t.Fatalf("GetCash: %v", err)
+	}
+	if !near(cash.BuyingPower, 5998.8) || !near(cash.PendingBuys, 4001.2) || !near(cash.PostedBalance, 10000) {
+		t.Errorf("after placing: buying power %.2f, pending %.2f, posted %.2f", cash.BuyingPower, cash.PendingBuys, cash.PostedBalance)
+	}
+	orders, err := c.GetOrders()
+	if err != nil || len(*orders) != 1 || (*orders)[0].ID != resp.Order.ID {
+		t.Fatalf("GetOrders = %v, %v, want the placed order", orders, err)
+	}
+
+	if err := s.FillOrder(resp.Order.ID); err != nil {
+		t.Fatalf("FillOrder: %v", err)
+	}
+	cash, _ = c.GetCash()
+	if !near(cash.BuyingPower, 5998.8) || !near(cash.PendingBuys, 0) || !near(cash.PostedBalance, 5998.8) {
+		t.Errorf("after filling: buying power %.2f, pending %.2f, posted %.2f", cash.BuyingPower, cash.PendingBuys, cash.PostedBalance)
+	}
+	positions, err := c.GetEquityPositions()
+	if err != nil {
+		t.Fatalf("GetEquityPositions: %v", err)
+	}
+	if len(positions.EquityPositions) != 1 || positions.EquityPositions[0].OpenQty != 100 {
+		t.Errorf("positions = %+v, want 100 BHP", positions.EquityPositions)
+	}
+	orders, _ = c.GetOrders()
+	if len(*orders) != 0 {
+		t.Errorf("%d orders pending after the fill, want 0", len(*orders))
+	}
+
+	sell := stakego.NewSellOrder()
+	sell.InstrumentCode = "BHP"
+	sell.Units = 100
+	sell.Price = 41
+	resp, err = c.PlaceOrder(*sell)
+	if err != nil {
+		t.Fatalf("PlaceOrder sell: %v", err)
+	}
+	if err := s.FillOrder(resp.Order.ID); err != nil {
+		t.Fatalf("FillOrder sell: %v", err)
+	}
+	cash, _ = c.GetCash()
+	if !near(cash.BuyingPower, 10097.57) || !near(cash.PostedBalance, 10097.57) {
+		t.Errorf("after selling: buying power %.2f, posted %.2f", cash.BuyingPower, cash.PostedBalance)
+	}
+	if positions, _ := c.GetEquityPositions(); len(positions.EquityPositions) != 0 {
+		t.Errorf("positions = %+v, want none", positions.EquityPositions)
+	}
+}
+
+func TestCancelReleasesBuyingPower(t *testing.T) {
+	_, c := newLoggedIn(t, 1000)
+
+	o := stakego.NewBuyOrder()
+	o.InstrumentCode = "VAS"
+	o.Units = 10
+	o.Price = 90
+	resp, err := c.PlaceOrder(*o)
+	if err != nil {
+		t.Fatalf("PlaceOrder: %v", err)
+	}
+	if err := c.CancelOrder(resp.Order.ID); err != nil {
+		t.Fatalf("CancelOrder: %v", err)
+	}
+	cash, _ := c.GetCash()
+	if !near(cash.BuyingPower, 1000) || !near(cash.PendingBuys, 0) {
+		t.Errorf("after cancelling: buying power %.2f, pending %.2f", cash.BuyingPower, cash.PendingBuys)
+	}
+	if err := c.CancelOrder(resp.Order.ID); err == nil {
+		t.Error("cancelling twice succeeded")
+	}
+}
+
+func TestInsufficientBuyingPower(t *testing.T) {
+	s, c := newLoggedIn(t, 100)
+
+	o := stakego.NewBuyOrder()
+	o.InstrumentCode = "VAS"
+	o.Units = 10
+	o.Price = 10 // $100 plus brokerage
+	if _, err := c.PlaceOrder(*o); err == nil {
+		t.Fatal("PlaceOrder beyond buying power succeeded")
+	}
+	if n := len(s.State().Orders); n != 0 {
+		t.Errorf("%d orders recorded, want 0", n)
+	}
+}
+
+func TestFail(t *testing.T) {
+	s, c := newLoggedIn(t, 100)
+
+	s.Fail("/api/asx/cash", http.StatusInternalServerError, 1)
+	if _, err := c.GetCash(); err == nil {
+		t.Fatal("GetCash succeeded with an injected error")
+	}
+	if _, err := c.GetCash(); err != nil {
+		t.Fatalf("GetCash after the fault: %v", err)
+	}
+	if n := s.Requests("/api/asx/cash"); n != 2 {
+		t.Errorf("Requests = %d, want 2", n)
+	}
+
+	s.Fail("", http.StatusServiceUnavailable, -1)
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetOrders(); err == nil {
+			t.Fatal("GetOrders succeeded with a permanent fault")
+		}
+	}
+	s.ClearFaults()
+	if _, err := c.GetOrders(); err != nil {
+		t.Fatalf("GetOrders after ClearFaults: %v", err)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	s, _ := newLoggedIn(t, 100)
+
+	c := s.NewClient(stakego.WithHTTPClient(http.Client{Timeout: 50 * time.Millisecond}))
+	c.Credentials.SetSessionToken(s.IssueToken())
+	s.Delay("/api/asx/cash", time.Second)
+	if _, err := c.GetCash(); err == nil {
+		t.Fatal("GetCash succeeded despite the delay exceeding the client timeout")
+	}
+	s.ClearFaults()
+	if _, err := c.GetCash(); err != nil {
+		t.Fatalf("GetCash after ClearFaults: %v", err)
+	}
+}
+
+func TestStateIsACopy(t *testing.T) {
+	s, c := newLoggedIn(t, 100)
+	token := c.Credentials.GetSessionToken()
+
+	st := s.State()
+	delete(st.Tokens, token)
+	st.Location[0] = 'x'
+	if !s.State().Tokens[token] {
+		t.Error("changing State().Tokens changed the server")
+	}
+	if s.State().Location[0] != '{' {
+		t.Error("changing State().Location changed the server")
+	}
+}
+
+func TestKeepaliveRelogin(t *testing.T) {
+	s, c := newLoggedIn(t, 100)
+	defer c.Close()
+
+	opts := stakego.KeepaliveOptions{Interval: 10 * time.Millisecond, Relogin: true}
+	events := c.StartKeepalive(opts)
+
+	// revoke every token so the keepalive has to log in again, while this
+	// goroutine keeps using the client
+	s.Update(func(st *staketest.State) { st.Tokens = make(map[string]bool) })
+	deadline := time.After(5 * time.Second)
+	for {
+		_ = c.CurrentUser()
+		_ = c.CurrentSession()
+		_, _ = c.GetCash()
+		select {
+		case ev := <-events:
+			if ev.Type != stakego.SessionEventRelogin {
+				continue
+			}
+			if c.ReloginCount() != 1 {
+				t.Errorf("ReloginCount = %d, want 1", c.ReloginCount())
+			}
+			if !s.State().Tokens[c.Credentials.GetSessionToken()] {
+				t.Error("token after relogin isn't valid")
+			}
+			return
+		case <-deadline:
+			t.Fatal("no relogin event")
+		}
+	}
+}
+
+func TestGetTransactionsPages(t *testing.T) {
+	s, c := newLoggedIn(t, 0)
+	n := stakego.TransactionPageSize + 20
+	for i := 0; i < n; i++ {
+		s.AddTransaction(stakego.TransactionDeposit, 10, "Deposit")
+	}
+	s.AddTransaction(stakego.TransactionDividend, 5, "BHP dividend")
+
+	txs, err := c.GetTransactions(context.Background(), time.Now().Add(-time.Hour), time.Now())
+	if err != nil {
+		t.Fatalf("GetTransactions: %v", err)
+	}
+	if len(txs) != n+1 {
+		t.Fatalf("%d transactions, want %d", len(txs), n+1)
+	}
+	if n := s.Requests("/api/asx/transactions"); n != 2 {
+		t.Errorf("%d requests, want 2 pages", n)
+	}
+
+	txs, err = c.GetTransactions(context.Background(), time.Now().Add(-time.Hour), time.Now(), "dividend")
+	if err != nil {
+		t.Fatalf("GetTransactions dividends: %v", err)
+	}
+	if len(txs) != 1 || txs[0].Kind() != stakego.TransactionDividend {
+		t.Errorf("dividends = %+v, want the one dividend", txs)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	s, c := newLoggedIn(t, 0)
+	s.AddTransaction(stakego.TransactionDeposit, 5000, "Deposit")
+	o := stakego.NewBuyOrder()
+	o.InstrumentCode = "BHP"
+	o.Units = 50
+	o.Price = 40
+	resp, err := c.PlaceOrder(*o)
+	if err != nil {
+		t.Fatalf("PlaceOrder: %v", err)
+	}
+	if err := s.FillOrder(resp.Order.ID); err != nil {
+		t.Fatalf("FillOrder: %v", err)
+	}
+
+	r, err := c.Reconcile(context.Background(), time.Time{})
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if r.Status() != stakego.ReconcileBalanced || r.Opening != 0 || !near(r.Closing, 2999.4) {
+		t.Errorf("status %s, opening %.2f, closing %.2f, want balanced from 0 to 2999.40", r.Status(), r.Opening, r.Closing)
+	}
+	if !near(r.Totals[stakego.TransactionBrokerage], -0.6) {
+		t.Errorf("brokerage total %.2f, want -0.60", r.Totals[stakego.TransactionBrokerage])
+	}
+
+	// a deposit missing from the ledger
+	s.Update(func(st *staketest.State) { st.Cash.PostedBalance += 100 })
+	r, _ = c.Reconcile(context.Background(), time.Time{})
+	if r.Status() != stakego.ReconcileUnbalanced || !near(r.Difference, -100) {
+		t.Errorf("status %s, difference %.2f, want unbalanced by -100", r.Status(), r.Difference)
+	}
+}
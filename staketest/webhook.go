@@ -0,0 +1,91 @@
+package staketest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/mdusher/stakego"
+)
+
+// Webhook - a request received by a WebhookServer
+type Webhook struct {
+	Header http.Header
+	Body   []byte
+	// Notification - the body decoded as a notification, if it is one
+	Notification stakego.Notification
+	// SignatureValid - whether the signature matched the server's secret
+	SignatureValid bool
+}
+
+// NewWebhookServer - start a local stand-in for a webhook receiver that
+// records each request and checks signatures against secret. Close it when done.
+func NewWebhookServer(secret string) *WebhookServer {
+	w := WebhookServer{}
+	w.secret = secret
+	w.httpserver = httptest.NewServer(http.HandlerFunc(w.receive))
+	return &w
+}
+
+// WebhookServer - records webhooks, optionally failing some of them
+type WebhookServer struct {
+	secret     string
+	httpserver *httptest.Server
+
+	mutex    sync.Mutex
+	received []Webhook
+	failures []int
+}
+
+// URL - the URL to post webhooks to
+func (w *WebhookServer) URL() string {
+	return w.httpserver.URL
+}
+
+// Close - shut down the server
+func (w *WebhookServer) Close() {
+	w.httpserver.Close()
+}
+
+// Fail - answer the next n requests with status instead of 200
+func (w *WebhookServer) Fail(status int, n int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for i := 0; i < n; i++ {
+		w.failures = append(w.failures, status)
+	}
+}
+
+// Received - the webhooks answered with 200, in the order they arrived
+func (w *WebhookServer) Received() []Webhook {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return append([]Webhook{}, w.received...)
+}
+
+func (w *WebhookServer) receive(rw http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(w.failures) > 0 {
+		status := w.failures[0]
+		w.failures = w.failures[1:]
+		rw.WriteHeader(status)
+		return
+	}
+
+	hook := Webhook{Header: r.Header.Clone(), Body: body}
+	_ = json.Unmarshal(body, &hook.Notification)
+	if w.secret != "" {
+		hook.SignatureValid = stakego.VerifyWebhook(w.secret, r.Header.Get(stakego.WebhookTimestampHeader), body, r.Header.Get(stakego.WebhookSignatureHeader))
+	}
+	w.received = append(w.received, hook)
+	rw.WriteHeader(http.StatusOK)
+}
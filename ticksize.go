@@ -0,0 +1,31 @@
+package stakego
+
+import (
+	"math"
+)
+
+// ASXTickSize - returns the minimum price step for an ASX equity at price
+func ASXTickSize(price float64) float64 {
+	switch {
+	case price <= 0.10:
+		return 0.001
+	case price <= 2.00:
+		return 0.005
+	}
+	return 0.01
+}
+
+// RoundToTick - round a price to a valid ASX tick. Buys are rounded up and
+// sells rounded down, so the order stays marketable.
+func RoundToTick(price float64, side string) float64 {
+	tick := ASXTickSize(price)
+	steps := price / tick
+	// avoid float noise pushing an exact tick to the next one
+	steps = math.Round(steps*1e6) / 1e6
+	if side == OrderBUY {
+		steps = math.Ceil(steps)
+	} else {
+		steps = math.Floor(steps)
+	}
+	return math.Round(steps*tick*1000) / 1000
+}
@@ -0,0 +1,249 @@
+package stakego
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// TokenStore - persists a session token between process restarts
+type TokenStore interface {
+	// Load - returns the saved session, or nil if there isn't one
+	Load() (*StoredSession, error)
+	// Save - persist the session
+	Save(s StoredSession) error
+	// Clear - remove any saved session
+	Clear() error
+}
+
+// StoredSession - a session token and what's known about the session it belongs to
+type StoredSession struct {
+	Token     string       `json:"token"`
+	Session   *UserSession `json:"session,omitempty"`
+	IssuedAt  time.Time    `json:"issuedAt"`
+	ExpiresAt time.Time    `json:"expiresAt"` // zero if unknown
+}
+
+// marshalStoredSession - encode a StoredSession for writing to a store
+func marshalStoredSession(s StoredSession) ([]byte, error) {
+	return json.Marshal(s)
+}
+
+// unmarshalStoredSession - decode a StoredSession, accepting a bare token
+// as written by earlier versions
+func unmarshalStoredSession(b []byte) (*StoredSession, error) {
+	t := strings.TrimSpace(string(b))
+	if t == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(t, "{") {
+		return &StoredSession{Token: t}, nil
+	}
+	var s StoredSession
+	err := json.Unmarshal([]byte(t), &s)
+	if err != nil {
+		return nil, err
+	}
+	if s.Token == "" {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+// NewFileTokenStore - create a TokenStore that saves the token in a plain file
+func NewFileTokenStore(path string) *FileTokenStore {
+	s := FileTokenStore{}
+	s.Path = path
+	return &s
+}
+
+// FileTokenStore - stores the session in a file readable only by the owner
+type FileTokenStore struct {
+	Path string
+}
+
+// Load - read the session from the file
+func (s *FileTokenStore) Load() (*StoredSession, error) {
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, NewStakeError("token store", err)
+	}
+	ss, err := unmarshalStoredSession(b)
+	if err != nil {
+		return nil, NewStakeError("token store", err)
+	}
+	return ss, nil
+}
+
+// Save - atomically write the session to the file with 0600 permissions
+func (s *FileTokenStore) Save(ss StoredSession) error {
+	b, err := marshalStoredSession(ss)
+	if err != nil {
+		return NewStakeError("token store", err)
+	}
+	err = WriteFileAtomic(s.Path, b, 0600)
+	if err != nil {
+		return NewStakeError("token store", err)
+	}
+	return nil
+}
+
+// Clear - remove the token file
+func (s *FileTokenStore) Clear() error {
+	err := os.Remove(s.Path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return NewStakeError("token store", err)
+	}
+	return nil
+}
+
+// encryptedTokenIterations - PBKDF2 iterations used to derive the key from the passphrase
+const encryptedTokenIterations = 200000
+
+// NewEncryptedFileTokenStore - create a TokenStore that encrypts the token
+// with a key derived from passphrase
+func NewEncryptedFileTokenStore(path string, passphrase string) *EncryptedFileTokenStore {
+	s := EncryptedFileTokenStore{}
+	s.Path = path
+	s.Passphrase = passphrase
+	return &s
+}
+
+// EncryptedFileTokenStore - stores the session in a file, encrypted
+// with AES-256-GCM
+type EncryptedFileTokenStore struct {
+	Path       string
+	Passphrase string
+}
+
+// encryptedTokenFile - on disk format of the encrypted token
+type encryptedTokenFile struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Load - read and decrypt the session
+func (s *EncryptedFileTokenStore) Load() (*StoredSession, error) {
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, NewStakeError("token store", err)
+	}
+
+	var f encryptedTokenFile
+	err = json.Unmarshal(b, &f)
+	if err != nil {
+		return nil, NewStakeError("token store", err)
+	}
+	gcm, err := s.cipher(f.Salt)
+	if err != nil {
+		return nil, NewStakeError("token store", err)
+	}
+	plain, err := gcm.Open(nil, f.Nonce, f.Ciphertext, nil)
+	if err != nil {
+		return nil, NewStakeError("token store", err)
+	}
+	ss, err := unmarshalStoredSession(plain)
+	if err != nil {
+		return nil, NewStakeError("token store", err)
+	}
+	return ss, nil
+}
+
+// Save - encrypt and atomically write the session with 0600 permissions
+func (s *EncryptedFileTokenStore) Save(ss StoredSession) error {
+	plain, err := marshalStoredSession(ss)
+	if err != nil {
+		return NewStakeError("token store", err)
+	}
+	var f encryptedTokenFile
+	f.Salt = make([]byte, 16)
+	_, err = io.ReadFull(rand.Reader, f.Salt)
+	if err != nil {
+		return NewStakeError("token store", err)
+	}
+	gcm, err := s.cipher(f.Salt)
+	if err != nil {
+		return NewStakeError("token store", err)
+	}
+	f.Nonce = make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, f.Nonce)
+	if err != nil {
+		return NewStakeError("token store", err)
+	}
+	f.Ciphertext = gcm.Seal(nil, f.Nonce, plain, nil)
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		return NewStakeError("token store", err)
+	}
+	err = WriteFileAtomic(s.Path, b, 0600)
+	if err != nil {
+		return NewStakeError("token store", err)
+	}
+	return nil
+}
+
+// Clear - remove the token file
+func (s *EncryptedFileTokenStore) Clear() error {
+	return NewFileTokenStore(s.Path).Clear()
+}
+
+// cipher - create the AEAD for the given salt
+func (s *EncryptedFileTokenStore) cipher(salt []byte) (cipher.AEAD, error) {
+	if s.Passphrase == "" {
+		return nil, errors.New("passphrase is empty")
+	}
+	key := pbkdf2SHA256([]byte(s.Passphrase), salt, encryptedTokenIterations, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2SHA256 - derive a key using PBKDF2 with HMAC-SHA256 (RFC 8018)
+func pbkdf2SHA256(password []byte, salt []byte, iter int, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		for n := 2; n <= iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
@@ -0,0 +1,92 @@
+package stakego
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// RFC 7914 section 11
+	tests := []struct {
+		password string
+		salt     string
+		iter     int
+		want     string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iter, 64))
+		if got != tt.want {
+			t.Errorf("pbkdf2SHA256(%q, %q, %d) = %s, want %s", tt.password, tt.salt, tt.iter, got, tt.want)
+		}
+	}
+	// a key shorter than one block is a prefix of the full block
+	if got := hex.EncodeToString(pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 20)); got != tests[0].want[:40] {
+		t.Errorf("20 byte key = %s, want %s", got, tests[0].want[:40])
+	}
+}
+
+func TestTokenStores(t *testing.T) {
+	dir := t.TempDir()
+	ss := StoredSession{
+		Token:     "token",
+		Session:   &UserSession{SessionKey: "token", Email: "test@example.com"},
+		IssuedAt:  time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC),
+		ExpiresAt: time.Date(2024, 7, 31, 10, 0, 0, 0, time.UTC),
+	}
+	stores := map[string]TokenStore{
+		"file":      NewFileTokenStore(filepath.Join(dir, "plain")),
+		"encrypted": NewEncryptedFileTokenStore(filepath.Join(dir, "encrypted"), "passphrase"),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			if got, err := store.Load(); got != nil || err != nil {
+				t.Fatalf("Load before Save = %v, %v, want nil, nil", got, err)
+			}
+			if err := store.Save(ss); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			got, err := store.Load()
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if got.Token != ss.Token || got.Session.Email != ss.Session.Email || !got.IssuedAt.Equal(ss.IssuedAt) || !got.ExpiresAt.Equal(ss.ExpiresAt) {
+				t.Errorf("Load = %+v, want %+v", got, ss)
+			}
+			if err := store.Clear(); err != nil {
+				t.Fatalf("Clear: %v", err)
+			}
+			if got, _ := store.Load(); got != nil {
+				t.Errorf("Load after Clear = %+v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestEncryptedTokenStoreWrongPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := NewEncryptedFileTokenStore(path, "right").Save(StoredSession{Token: "token"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewEncryptedFileTokenStore(path, "wrong").Load(); err == nil {
+		t.Fatal("Load with the wrong passphrase succeeded")
+	}
+
+	// Login reports the error instead of falling back to the password
+	c := NewASXClient(WithBaseURL("http://127.0.0.1:0/"))
+	c.Credentials = NewCredentials()
+	c.TokenStore = NewEncryptedFileTokenStore(path, "wrong")
+	err := c.Login()
+	if err == nil || !strings.Contains(err.Error(), "token store") {
+		t.Fatalf("Login = %v, want the token store error", err)
+	}
+	if _, statErr := os.Stat(path); statErr != nil {
+		t.Errorf("token file removed: %v", statErr)
+	}
+}
@@ -0,0 +1,295 @@
+package stakego
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Transaction kinds
+const TransactionDeposit = "DEPOSIT"
+const TransactionWithdrawal = "WITHDRAWAL"
+const TransactionTradeSettlement = "TRADE_SETTLEMENT"
+const TransactionBrokerage = "BROKERAGE"
+const TransactionDividend = "DIVIDEND"
+const TransactionWalletTransfer = "WALLET_TRANSFER"
+const TransactionOther = "OTHER"
+
+// TransactionPageSize - transactions requested per page
+const TransactionPageSize = 100
+
+// Transaction - a movement of cash in the ASX wallet, as reported by Stake
+// or recorded by the caller, e.g. from a statement. Amount is positive for
+// credits and negative for debits.
+type Transaction struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"` // as reported; see Kind
+	Time        time.Time `json:"time"`
+	Description string    `json:"description"`
+	Amount      float64   `json:"amount"`
+	Balance     *float64  `json:"balance,omitempty"` // balance after the transaction, if recorded
+	Symbol      string    `json:"symbol,omitempty"`
+	OrderID     string    `json:"orderId,omitempty"`
+}
+
+// Kind - the transaction's kind, one of the Transaction constants
+func (t Transaction) Kind() string {
+	return TransactionKind(t.Type)
+}
+
+// TransactionKind - map a transaction type reported by Stake or taken from
+// a statement to one of the Transaction constants
+func TransactionKind(kind string) string {
+	r := strings.ToUpper(kind)
+	switch {
+	case r == TransactionDeposit || r == TransactionWithdrawal || r == TransactionTradeSettlement ||
+		r == TransactionBrokerage || r == TransactionDividend || r == TransactionWalletTransfer:
+		return r
+	case strings.Contains(r, "TRANSFER") || strings.Contains(r, "WALLET"):
+		return TransactionWalletTransfer
+	case strings.Contains(r, "DIVIDEND") || strings.Contains(r, "DISTRIBUTION"):
+		return TransactionDividend
+	case strings.Contains(r, "BROKERAGE") || strings.Contains(r, "FEE"):
+		return TransactionBrokerage
+	case strings.Contains(r, "WITHDRAW"):
+		return TransactionWithdrawal
+	case strings.Contains(r, "DEPOSIT") || strings.Contains(r, "FUNDING"):
+		return TransactionDeposit
+	case strings.Contains(r, "SETTLE") || strings.Contains(r, "TRADE") || r == OrderBUY || r == OrderSELL:
+		return TransactionTradeSettlement
+	}
+	return TransactionOther
+}
+
+// NewTransactionsFromJSON - create a slice of Transactions from a json
+// array, in the format Transaction marshals to
+func NewTransactionsFromJSON(jsonStr []byte) ([]Transaction, error) {
+	var txs []Transaction
+	err := json.Unmarshal(jsonStr, &txs)
+	if err != nil {
+		return nil, NewStakeError("transactions", err)
+	}
+	return txs, nil
+}
+
+// TransactionPage - one page of the transactions API
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	HasNext      bool          `json:"hasNext"`
+}
+
+// NewTransactionPageFromJSON - create a TransactionPage from a json byte slice
+func NewTransactionPageFromJSON(jsonStr []byte) *TransactionPage {
+	var p TransactionPage
+	err := json.Unmarshal(jsonStr, &p)
+	if err != nil {
+		return nil
+	}
+	return &p
+}
+
+// TransactionReader - reads the cash ledger
+type TransactionReader interface {
+	GetTransactions(ctx context.Context, from time.Time, to time.Time, kinds ...string) ([]Transaction, error)
+}
+
+var _ TransactionReader = (*ASXClient)(nil)
+
+// GetTransactions - get cash transactions between from and to, oldest
+// first, fetching every page. If kinds are given, only transactions of those
+// kinds are returned.
+//
+// The transactions endpoint isn't documented; this uses
+// GET asx/transactions?from=&to=&offset=&limit=&types=, which may need
+// adjusting to match the web app.
+func (c *ASXClient) GetTransactions(ctx context.Context, from time.Time, to time.Time, kinds ...string) ([]Transaction, error) {
+	if c.paper != nil {
+		return nil, NewStakeError("transactions", errors.New("not available when paper trading"))
+	}
+
+	u, err := url.JoinPath(c.apiUrl, "asx/transactions")
+	if err != nil {
+		return nil, NewStakeError("transactions", err)
+	}
+
+	want := map[string]bool{}
+	types := []string{}
+	for _, k := range kinds {
+		want[TransactionKind(k)] = true
+		types = append(types, TransactionKind(k))
+	}
+
+	txs := []Transaction{}
+	for offset := 0; ; offset += TransactionPageSize {
+		q := url.Values{}
+		q.Set("from", from.UTC().Format(time.RFC3339Nano))
+		q.Set("to", to.UTC().Format(time.RFC3339Nano))
+		q.Set("offset", fmt.Sprint(offset))
+		q.Set("limit", fmt.Sprint(TransactionPageSize))
+		if len(types) > 0 {
+			q.Set("types", strings.Join(types, ","))
+		}
+
+		rd, err := c.AuthedRequestContext(ctx, "GET", u+"?"+q.Encode(), nil)
+		if err != nil {
+			return nil, NewStakeError("transactions", err)
+		}
+		if rd.StatusCode != 200 {
+			return nil, NewStakeError("transactions", ErrInvalidAPIResponse)
+		}
+		page := NewTransactionPageFromJSON(rd.Body)
+		if page == nil {
+			return nil, NewStakeError("transactions", ErrInvalidAPIResponse)
+		}
+
+		for _, t := range page.Transactions {
+			if len(want) == 0 || want[t.Kind()] {
+				txs = append(txs, t)
+			}
+		}
+		if !page.HasNext || len(page.Transactions) == 0 {
+			break
+		}
+	}
+
+	sort.SliceStable(txs, func(i, j int) bool { return txs[i].Time.Before(txs[j].Time) })
+	return txs, nil
+}
+
+// Reconciliation statuses
+const ReconcileBalanced = "BALANCED"
+const ReconcileUnbalanced = "UNBALANCED"
+const ReconcileUnverified = "UNVERIFIED" // no opening balance was known, so nothing could be checked
+
+// LedgerEntry - a transaction with the balance after it
+type LedgerEntry struct {
+	Transaction Transaction
+	Balance     float64
+}
+
+// Reconciliation - a running balance rebuilt from transactions and compared
+// with the posted balance
+type Reconciliation struct {
+	Opening float64 // balance before the first transaction
+	// OpeningInferred - no opening balance was given and no transaction
+	// recorded a balance, so Opening was worked back from the posted
+	// balance. Difference is always zero and Status is ReconcileUnverified.
+	OpeningInferred bool
+	Closing         float64 // Opening plus every transaction
+	Posted          float64 // Cash.PostedBalance
+	Difference      float64 // Closing - Posted
+	Entries         []LedgerEntry
+	// Mismatches - entries whose recorded balance differs from the running balance
+	Mismatches []LedgerEntry
+	Totals     map[string]float64 // sum of amounts by kind
+}
+
+// Status - ReconcileBalanced if the running balance matches the posted
+// balance and every recorded balance to the cent, ReconcileUnverified if
+// there was no opening balance to start from, otherwise ReconcileUnbalanced
+func (r *Reconciliation) Status() string {
+	switch {
+	case r.OpeningInferred:
+		return ReconcileUnverified
+	case math.Abs(r.Difference) < 0.005 && len(r.Mismatches) == 0:
+		return ReconcileBalanced
+	}
+	return ReconcileUnbalanced
+}
+
+// Balanced - checks the reconciliation was verified and balanced
+func (r *Reconciliation) Balanced() bool {
+	return r.Status() == ReconcileBalanced
+}
+
+// ReconcileTransactions - rebuild the running balance from transactions
+// and compare it with cash.PostedBalance. The transactions should run up
+// to now, unfiltered, or the closing balance won't match. The opening
+// balance is taken from the first transaction that records a balance; if
+// none do, the result can't be verified. Use ReconcileFromOpening when the
+// opening balance is known, e.g. zero for the account's whole history.
+func ReconcileTransactions(txs []Transaction, cash *Cash) *Reconciliation {
+	sorted := sortTransactions(txs)
+	sum := 0.0
+	for _, t := range sorted {
+		sum += t.Amount
+		if t.Balance != nil {
+			return reconcile(sorted, *t.Balance-sum, false, cash)
+		}
+	}
+	return reconcile(sorted, cash.PostedBalance-sum, true, cash)
+}
+
+// ReconcileFromOpening - like ReconcileTransactions, but starting from a
+// known opening balance, so the result can be verified even if no
+// transaction records a balance
+func ReconcileFromOpening(txs []Transaction, opening float64, cash *Cash) *Reconciliation {
+	return reconcile(sortTransactions(txs), opening, false, cash)
+}
+
+// sortTransactions - a copy of txs, oldest first
+func sortTransactions(txs []Transaction) []Transaction {
+	sorted := append([]Transaction{}, txs...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.Before(sorted[j].Time) })
+	return sorted
+}
+
+// reconcile - rebuild the running balance of sorted transactions from opening
+func reconcile(sorted []Transaction, opening float64, inferred bool, cash *Cash) *Reconciliation {
+	r := Reconciliation{Opening: opening, OpeningInferred: inferred, Posted: cash.PostedBalance, Totals: map[string]float64{}}
+	balance := r.Opening
+	for _, t := range sorted {
+		balance += t.Amount
+		r.Totals[t.Kind()] += t.Amount
+		e := LedgerEntry{Transaction: t, Balance: roundCents(balance)}
+		r.Entries = append(r.Entries, e)
+		if t.Balance != nil && math.Abs(*t.Balance-balance) >= 0.005 {
+			r.Mismatches = append(r.Mismatches, e)
+		}
+	}
+	r.Opening = roundCents(r.Opening)
+	r.Closing = roundCents(balance)
+	r.Difference = roundCents(r.Closing - r.Posted)
+	return &r
+}
+
+// LedgerReader - reads the cash ledger and the balance it should add up to
+type LedgerReader interface {
+	TransactionReader
+	GetCash() (*Cash, error)
+}
+
+// Reconcile - fetch every transaction since from and reconcile them with
+// the posted cash balance. If from is zero the account's whole history is
+// fetched and reconciled from a zero opening balance.
+func (c *ASXClient) Reconcile(ctx context.Context, from time.Time) (*Reconciliation, error) {
+	return Reconcile(ctx, c, from)
+}
+
+// Reconcile - reconcile the transactions from any LedgerReader since from,
+// see ASXClient.Reconcile
+func Reconcile(ctx context.Context, c LedgerReader, from time.Time) (*Reconciliation, error) {
+	txs, err := c.GetTransactions(ctx, from, time.Now())
+	if err != nil {
+		return nil, NewStakeError("reconcile", err)
+	}
+	cash, err := c.GetCash()
+	if err != nil {
+		return nil, NewStakeError("reconcile", err)
+	}
+	if from.IsZero() {
+		return ReconcileFromOpening(txs, 0, cash), nil
+	}
+	return ReconcileTransactions(txs, cash), nil
+}
+
+// roundCents - round to the nearest cent
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
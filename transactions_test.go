@@ -0,0 +1,138 @@
+package stakego_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mdusher/stakego"
+	"github.com/mdusher/stakego/staketest"
+)
+
+// tx - a transaction n days after a fixed start, with an optional recorded balance
+func tx(day int, kind string, amount float64, balance ...float64) stakego.Transaction {
+	t := stakego.Transaction{Type: kind, Amount: amount, Time: time.Date(2024, 7, 1+day, 10, 0, 0, 0, time.UTC)}
+	if len(balance) > 0 {
+		t.Balance = &balance[0]
+	}
+	return t
+}
+
+func TestReconcileTransactions(t *testing.T) {
+	tests := []struct {
+		name    string
+		txs     []stakego.Transaction
+		posted  float64
+		opening *float64
+		status  string
+		open    float64
+		diff    float64
+	}{
+		{
+			name:   "balance recorded",
+			txs:    []stakego.Transaction{tx(0, "DEPOSIT", 500, 1500), tx(1, "BROKERAGE", -3, 1497)},
+			posted: 1497,
+			status: stakego.ReconcileBalanced,
+			open:   1000,
+		},
+		{
+			name:   "missing transaction",
+			txs:    []stakego.Transaction{tx(0, "DEPOSIT", 500, 1500)},
+			posted: 1497,
+			status: stakego.ReconcileUnbalanced,
+			open:   1000,
+			diff:   3,
+		},
+		{
+			name:   "recorded balance mismatch",
+			txs:    []stakego.Transaction{tx(0, "DEPOSIT", 500, 1500), tx(1, "DIVIDEND", 20, 1510)},
+			posted: 1520,
+			status: stakego.ReconcileUnbalanced,
+			open:   1000,
+		},
+		{
+			name:   "out of order",
+			txs:    []stakego.Transaction{tx(1, "WITHDRAWAL", -100), tx(0, "DEPOSIT", 500, 500)},
+			posted: 400,
+			status: stakego.ReconcileBalanced,
+		},
+		{
+			name:   "no balance recorded",
+			txs:    []stakego.Transaction{tx(0, "DEPOSIT", 500), tx(1, "BUY", -200)},
+			posted: 300,
+			status: stakego.ReconcileUnverified,
+		},
+		{
+			name:    "whole history from zero",
+			txs:     []stakego.Transaction{tx(0, "DEPOSIT", 500), tx(1, "BUY", -200)},
+			posted:  300,
+			opening: new(float64),
+			status:  stakego.ReconcileBalanced,
+		},
+		{
+			name:    "whole history from zero, unbalanced",
+			txs:     []stakego.Transaction{tx(0, "DEPOSIT", 500)},
+			posted:  300,
+			opening: new(float64),
+			status:  stakego.ReconcileUnbalanced,
+			diff:    200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cash := &stakego.Cash{PostedBalance: tt.posted}
+			var r *stakego.Reconciliation
+			if tt.opening != nil {
+				r = stakego.ReconcileFromOpening(tt.txs, *tt.opening, cash)
+			} else {
+				r = stakego.ReconcileTransactions(tt.txs, cash)
+			}
+			if r.Status() != tt.status {
+				t.Errorf("Status = %s, want %s", r.Status(), tt.status)
+			}
+			if tt.status != stakego.ReconcileUnverified && (r.Opening != tt.open || r.Difference != tt.diff) {
+				t.Errorf("opening %.2f, difference %.2f, want %.2f, %.2f", r.Opening, r.Difference, tt.open, tt.diff)
+			}
+		})
+	}
+}
+
+func TestTransactionKind(t *testing.T) {
+	tests := map[string]string{
+		"deposit":               stakego.TransactionDeposit,
+		"Funding":               stakego.TransactionDeposit,
+		"WITHDRAWAL":            stakego.TransactionWithdrawal,
+		"BUY":                   stakego.TransactionTradeSettlement,
+		"Trade settled":         stakego.TransactionTradeSettlement,
+		"Brokerage fee":         stakego.TransactionBrokerage,
+		"Distribution":          stakego.TransactionDividend,
+		"Transfer from Wall St": stakego.TransactionWalletTransfer,
+		"interest":              stakego.TransactionOther,
+	}
+	for reported, want := range tests {
+		if got := stakego.TransactionKind(reported); got != want {
+			t.Errorf("TransactionKind(%q) = %s, want %s", reported, got, want)
+		}
+	}
+}
+
+func TestReconcileFromLedgerReader(t *testing.T) {
+	m := &staketest.MockClient{
+		GetTransactionsFunc: func(ctx context.Context, from time.Time, to time.Time, kinds ...string) ([]stakego.Transaction, error) {
+			return []stakego.Transaction{tx(0, "DEPOSIT", 1000), tx(1, "BROKERAGE", -3)}, nil
+		},
+		GetCashFunc: func() (*stakego.Cash, error) {
+			return &stakego.Cash{PostedBalance: 997}, nil
+		},
+	}
+	r, err := stakego.Reconcile(context.Background(), m, time.Time{})
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if !r.Balanced() {
+		t.Errorf("Status = %s, difference %.2f, want balanced", r.Status(), r.Difference)
+	}
+	if m.Calls("GetTransactions") != 1 || m.Calls("GetCash") != 1 {
+		t.Errorf("calls: %d GetTransactions, %d GetCash, want 1 each", m.Calls("GetTransactions"), m.Calls("GetCash"))
+	}
+}
@@ -0,0 +1,308 @@
+package stakego
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Watch event types emitted by a Watcher
+const EventOrderPlaced = "ORDER_PLACED"
+const EventOrderFilled = "ORDER_FILLED"
+const EventOrderCancelled = "ORDER_CANCELLED"
+const EventPositionOpened = "POSITION_OPENED"
+const EventPositionClosed = "POSITION_CLOSED"
+const EventQuantityChanged = "QUANTITY_CHANGED"
+const EventCashSettled = "CASH_SETTLED"
+const EventWatchError = "WATCH_ERROR"
+
+// DefaultWatchInterval - how often a Watcher polls if Interval is not set
+const DefaultWatchInterval = 30 * time.Second
+
+// WatchEvent - a change between two snapshots of the account
+type WatchEvent struct {
+	Type    string
+	Time    time.Time
+	Symbol  string
+	OrderID string
+	Side    string
+
+	// Units - units placed, filled or cancelled for order events, the
+	// change in units for position events
+	Units int
+	Price float64
+
+	// Previous and Current - units held before and after, for position events
+	Previous int
+	Current  int
+
+	// Amount and Balance - the change in settled cash and the new settled
+	// cash balance, for EventCashSettled
+	Amount  float64
+	Balance float64
+
+	Order    *OrderDetails
+	Position *EquityPositionItem
+	Err      error
+}
+
+// watchSnapshot - the account as seen by one poll
+type watchSnapshot struct {
+	orders    map[string]OrderDetails
+	positions map[string]EquityPositionItem
+	cash      Cash
+}
+
+// NewWatcher - create a Watcher polling c every DefaultWatchInterval
+func NewWatcher(c AccountReader) *Watcher {
+	w := Watcher{}
+	w.client = c
+	w.Interval = DefaultWatchInterval
+	return &w
+}
+
+// Watcher - polls orders, positions and cash, and emits a WatchEvent for
+// each change between one poll and the next
+type Watcher struct {
+	Interval time.Duration
+
+	client      AccountReader
+	mutex       sync.Mutex
+	prev        *watchSnapshot
+	subscribers []chan WatchEvent
+	stopped     bool
+}
+
+// Subscribe - get a channel of events. Events are delivered to every
+// subscriber, and Run blocks until each has received them, so subscribers
+// must keep reading. The channel is closed when Run returns, or straight
+// away if Run has already returned.
+func (w *Watcher) Subscribe(buffer int) <-chan WatchEvent {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	ch := make(chan WatchEvent, buffer)
+	if w.stopped {
+		close(ch)
+		return ch
+	}
+	w.subscribers = append(w.subscribers, ch)
+	return ch
+}
+
+// Run - poll every Interval until ctx is done, sending events to subscribers
+func (w *Watcher) Run(ctx context.Context) error {
+	defer func() {
+		w.mutex.Lock()
+		for _, ch := range w.subscribers {
+			close(ch)
+		}
+		w.subscribers = nil
+		w.stopped = true
+		w.mutex.Unlock()
+	}()
+
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	for {
+		events, err := w.Poll(ctx)
+		if err != nil && ctx.Err() == nil {
+			events = append(events, WatchEvent{Type: EventWatchError, Time: time.Now(), Err: err})
+		}
+		for _, ev := range events {
+			if w.publish(ctx, ev) != nil {
+				return ctx.Err()
+			}
+		}
+		err = sleepCtx(ctx, interval)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// publish - send an event to every subscriber
+func (w *Watcher) publish(ctx context.Context, ev WatchEvent) error {
+	w.mutex.Lock()
+	subscribers := append([]chan WatchEvent{}, w.subscribers...)
+	w.mutex.Unlock()
+	for _, ch := range subscribers {
+		select {
+		case ch <- ev:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Poll - take one snapshot and return the changes since the last one. The
+// first poll records a baseline and returns no events.
+func (w *Watcher) Poll(ctx context.Context) ([]WatchEvent, error) {
+	p, err := GetPortfolio(ctx, w.client)
+	if err != nil {
+		return nil, err
+	}
+
+	cur := &watchSnapshot{
+		orders:    map[string]OrderDetails{},
+		positions: map[string]EquityPositionItem{},
+		cash:      p.Cash,
+	}
+	for _, o := range p.Orders {
+		cur.orders[o.ID] = o
+	}
+	for _, pos := range p.Positions {
+		if pos.OpenQty > 0 {
+			cur.positions[pos.Symbol] = pos
+		}
+	}
+
+	w.mutex.Lock()
+	prev := w.prev
+	w.prev = cur
+	w.mutex.Unlock()
+	if prev == nil {
+		return nil, nil
+	}
+	return diffSnapshots(prev, cur, p.AsOf), nil
+}
+
+// diffSnapshots - the events between two snapshots
+func diffSnapshots(prev *watchSnapshot, cur *watchSnapshot, now time.Time) []WatchEvent {
+	events := []WatchEvent{}
+
+	// units bought (positive) or sold (negative) per symbol, used to tell
+	// whether an order that is no longer pending was filled or cancelled
+	moved := map[string]int{}
+	for sym, p := range cur.positions {
+		moved[sym] = p.OpenQty - prev.positions[sym].OpenQty
+	}
+	for sym, p := range prev.positions {
+		if _, ok := cur.positions[sym]; !ok {
+			moved[sym] = -p.OpenQty
+		}
+	}
+
+	orderEvent := func(t string, o OrderDetails, units int) WatchEvent {
+		order := o
+		return WatchEvent{Type: t, Time: now, Symbol: o.InstrumentCode, OrderID: o.ID, Side: o.Side, Units: units, Price: o.LimitPrice, Order: &order}
+	}
+
+	for _, id := range sortedKeys(cur.orders) {
+		o := cur.orders[id]
+		old, seen := prev.orders[id]
+		if !seen {
+			events = append(events, orderEvent(EventOrderPlaced, o, o.UnitsRequested))
+			old = OrderDetails{}
+		}
+		if isOrderDone(old) {
+			continue
+		}
+		filled := o.FilledUnits - old.FilledUnits
+		if isOrderFilled(o) {
+			filled = o.UnitsRequested - old.FilledUnits
+		}
+		if filled > 0 {
+			events = append(events, orderEvent(EventOrderFilled, o, filled))
+			moved[o.InstrumentCode] -= signedUnits(o.Side, filled)
+		}
+		if isOrderCancelled(o) {
+			events = append(events, orderEvent(EventOrderCancelled, o, o.UnitsRemaining))
+		}
+	}
+
+	// orders that are no longer listed, oldest first
+	gone := []OrderDetails{}
+	for _, id := range sortedKeys(prev.orders) {
+		o := prev.orders[id]
+		if _, ok := cur.orders[id]; !ok && !isOrderDone(o) {
+			gone = append(gone, o)
+		}
+	}
+	sort.SliceStable(gone, func(i, j int) bool { return gone[i].PlacedTimestamp < gone[j].PlacedTimestamp })
+	for _, o := range gone {
+		units := o.UnitsRemaining
+		if units == 0 {
+			units = o.UnitsRequested - o.FilledUnits
+		}
+		want := signedUnits(o.Side, units)
+		m := moved[o.InstrumentCode]
+		if (want > 0 && m >= want) || (want < 0 && m <= want) {
+			events = append(events, orderEvent(EventOrderFilled, o, units))
+			moved[o.InstrumentCode] -= want
+		} else {
+			events = append(events, orderEvent(EventOrderCancelled, o, units))
+		}
+	}
+
+	for _, sym := range sortedKeys(cur.positions) {
+		p := cur.positions[sym]
+		old, held := prev.positions[sym]
+		pos := p
+		ev := WatchEvent{Time: now, Symbol: sym, Units: p.OpenQty - old.OpenQty, Price: p.MktPrice, Previous: old.OpenQty, Current: p.OpenQty, Position: &pos}
+		switch {
+		case !held:
+			ev.Type = EventPositionOpened
+		case p.OpenQty != old.OpenQty:
+			ev.Type = EventQuantityChanged
+		default:
+			continue
+		}
+		events = append(events, ev)
+	}
+	for _, sym := range sortedKeys(prev.positions) {
+		if _, ok := cur.positions[sym]; !ok {
+			p := prev.positions[sym]
+			events = append(events, WatchEvent{Type: EventPositionClosed, Time: now, Symbol: sym, Units: -p.OpenQty, Price: p.MktPrice, Previous: p.OpenQty, Position: &p})
+		}
+	}
+
+	// compared in cents so float rounding noise isn't reported as a change
+	if amount := roundCents(cur.cash.SettledCash - prev.cash.SettledCash); amount != 0 {
+		events = append(events, WatchEvent{Type: EventCashSettled, Time: now, Amount: amount, Balance: cur.cash.SettledCash})
+	}
+	return events
+}
+
+// signedUnits - units as a change in position, negative for sells
+func signedUnits(side string, units int) int {
+	if side == OrderSELL {
+		return -units
+	}
+	return units
+}
+
+// isOrderFilled - checks if an order has completed by being filled
+func isOrderFilled(o OrderDetails) bool {
+	return strings.EqualFold(o.OrderStatus, OrderStatusFilled) || strings.EqualFold(o.OrderCompletionType, OrderStatusFilled)
+}
+
+// isOrderCancelled - checks if an order has completed without being filled
+func isOrderCancelled(o OrderDetails) bool {
+	for _, s := range []string{o.OrderStatus, o.OrderCompletionType} {
+		s = strings.ToUpper(s)
+		if strings.Contains(s, "CANCEL") || strings.Contains(s, "EXPIRE") || strings.Contains(s, "REJECT") {
+			return true
+		}
+	}
+	return false
+}
+
+// isOrderDone - checks if an order has completed
+func isOrderDone(o OrderDetails) bool {
+	return isOrderFilled(o) || isOrderCancelled(o)
+}
+
+// sortedKeys - the keys of m, sorted
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,70 @@
+package stakego_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mdusher/stakego"
+	"github.com/mdusher/stakego/staketest"
+)
+
+func TestWatcherCashSettled(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to float64
+		want     float64
+	}{
+		{"deposit", 1000, 1250.5, 250.5},
+		{"rounding noise", 0.1 + 0.2, 0.3, 0},
+		{"sub cent", 1000, 1000.004, 0},
+		{"withdrawal", 1000, 899.99, -100.01},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settled := tt.from
+			m := &staketest.MockClient{
+				GetCashFunc: func() (*stakego.Cash, error) {
+					return &stakego.Cash{SettledCash: settled}, nil
+				},
+				GetEquityPositionsFunc: func() (*stakego.EquityPositions, error) {
+					return &stakego.EquityPositions{}, nil
+				},
+				GetOrdersFunc: func() (*[]stakego.OrderDetails, error) {
+					return &[]stakego.OrderDetails{}, nil
+				},
+			}
+			w := stakego.NewWatcher(m)
+			if _, err := w.Poll(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			settled = tt.to
+			events, err := w.Poll(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == 0 {
+				if len(events) != 0 {
+					t.Errorf("events = %+v, want none", events)
+				}
+				return
+			}
+			if len(events) != 1 || events[0].Type != stakego.EventCashSettled || events[0].Amount != tt.want || events[0].Balance != tt.to {
+				t.Errorf("events = %+v, want %s of %.2f", events, stakego.EventCashSettled, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatcherSubscribeAfterRun(t *testing.T) {
+	w := stakego.NewWatcher(&staketest.MockClient{})
+	before := w.Subscribe(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = w.Run(ctx)
+
+	for name, ch := range map[string]<-chan stakego.WatchEvent{"before": before, "after": w.Subscribe(1)} {
+		if _, open := <-ch; open {
+			t.Errorf("channel subscribed %s Run is still open after it returned", name)
+		}
+	}
+}